@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,9 +32,97 @@ type AppSpec struct {
 	AppType       string            `json:"appType,omitempty"` // back, front-spa, front-srr
 	Annotations   map[string]string `json:"annotations,omitempty"`
 	ContainerName string            `json:"containerName"`
-	IngressHost   string            `json:"ingressHost"`
+	IngressHost   string            `json:"ingressHost,omitempty"`
 	// +optional
 	LifeCycle *v1.Lifecycle `json:"lifeCycle,omitempty"`
+	// Resources defaults to the operator config entry for AppType.
+	// +optional
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+	// Config is projected into the container. Pods are rolled whenever its
+	// content changes.
+	// +optional
+	Config *ConfigSpec `json:"config,omitempty"`
+	// InitContainers run to completion, in order, before the application
+	// container starts.
+	// +optional
+	InitContainers []ContainerSpec `json:"initContainers,omitempty"`
+	// Sidecars run next to the application container.
+	// +optional
+	Sidecars []SidecarSpec `json:"sidecars,omitempty"`
+	// ImagePullSecrets are used to pull the images of the pods, and to
+	// resolve the digest of Image when it is pinned.
+	// +optional
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// ContainerSpec declares an additional container of the pod.
+type ContainerSpec struct {
+	Name string `json:"name"`
+	// +optional
+	Image string `json:"image,omitempty"`
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// +optional
+	Args []string `json:"args,omitempty"`
+	// +optional
+	Env []v1.EnvVar `json:"env,omitempty"`
+	// +optional
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+	// VolumeMounts may refer to the volumes of spec.storage and the config
+	// volumes of the application.
+	// +optional
+	VolumeMounts []v1.VolumeMount `json:"volumeMounts,omitempty"`
+	// +optional
+	Ports []ContainerPort `json:"ports,omitempty"`
+}
+
+type ContainerPort struct {
+	Name          string `json:"name"`
+	ContainerPort int32  `json:"containerPort"`
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	// +optional
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+	// Expose adds the port to the Service of the Application.
+	// +optional
+	Expose bool `json:"expose,omitempty"`
+}
+
+// SidecarSpec declares a sidecar container, either from scratch or from a
+// preset of the operator config. Fields set here override the preset; env,
+// mounts and ports are appended to those of the preset.
+type SidecarSpec struct {
+	ContainerSpec `json:",inline"`
+	// Preset names a sidecar preset of the operator config.
+	// +optional
+	Preset string `json:"preset,omitempty"`
+}
+
+type ConfigSpec struct {
+	// Files maps file names to their content. They are stored in a
+	// ConfigMap owned by the Application and mounted under MountPath.
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+	// MountPath of Files, /etc/config by default.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+	// From lists existing ConfigMaps and Secrets in the namespace.
+	// +optional
+	From []ConfigSource `json:"from,omitempty"`
+}
+
+// ConfigSource refers to either a ConfigMap or a Secret.
+type ConfigSource struct {
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+	// +optional
+	Secret string `json:"secret,omitempty"`
+	// MountPath mounts the keys as files. They are exposed as environment
+	// variables when it is empty.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+	// Prefix is prepended to the names of the environment variables.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
 }
 
 type PodDisruptionBudgetSpec struct {
@@ -60,7 +149,48 @@ type ServiceSpec struct {
 type IngressSpec struct {
 	Enabled     bool              `json:"enabled"`
 	Annotations map[string]string `json:"annotations,omitempty"`
-	Rules       IngressSpecRules  `json:"rules"`
+	// ClassName defaults to the operator config ingress class.
+	// +optional
+	ClassName *string          `json:"className,omitempty"`
+	Rules     IngressSpecRules `json:"rules"`
+	// TLS serves the host of the ingress over HTTPS.
+	// +optional
+	TLS *IngressTLSSpec `json:"tls,omitempty"`
+	// Mode exposes the rules through an Ingress or a Gateway API HTTPRoute.
+	// It defaults to the operator config.
+	// +optional
+	// +kubebuilder:validation:Enum=Ingress;Gateway
+	Mode string `json:"mode,omitempty"`
+	// Gateway is the parent of the HTTPRoute, defaulting to the Gateway of
+	// the operator config.
+	// +optional
+	Gateway *GatewayReference `json:"gateway,omitempty"`
+}
+
+// GatewayReference names a Gateway API Gateway.
+type GatewayReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the Application.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// SectionName attaches the route to a single listener.
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// IngressTLSSpec selects the certificate served for the ingress host.
+type IngressTLSSpec struct {
+	// SecretName is an existing kubernetes.io/tls Secret to serve. The
+	// operator issues a certificate into <application>-tls when it is not
+	// set.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// Issuer issues the certificate: cert-manager through a cert-manager
+	// Certificate, local by signing it with the CA of the operator config,
+	// or self-signing it without one. It defaults to the operator config.
+	// +optional
+	// +kubebuilder:validation:Enum=cert-manager;local
+	Issuer string `json:"issuer,omitempty"`
 }
 
 // +kubebuilder:object:generate=true
@@ -77,6 +207,29 @@ type IngressPath struct {
 	ServiceName string `json:"serviceName,omitempty"`
 	// +optional
 	Port *int32 `json:"port,omitempty"`
+	// Headers restrict the path to requests carrying these header values.
+	// Only HTTPRoutes match headers.
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+	// Backends split the traffic of the path by weight, e.g. between a
+	// stable and a canary Service, in place of serviceName and port. Only
+	// HTTPRoutes split traffic.
+	// +optional
+	// +listType=atomic
+	Backends []WeightedBackend `json:"backends,omitempty"`
+}
+
+// WeightedBackend is a Service receiving a share of the traffic of a path.
+type WeightedBackend struct {
+	ServiceName string `json:"serviceName"`
+	// Port defaults to the container port.
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+	// Weight is the share of the Service relative to the other backends of
+	// the path, 1 by default.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Weight *int32 `json:"weight,omitempty"`
 }
 
 type ProbeSpec struct {
@@ -85,11 +238,226 @@ type ProbeSpec struct {
 	Readiness *v1.Probe `json:"readiness,omitempty"`
 }
 
+type HorizontalPodAutoscalerSpec struct {
+	Enabled bool `json:"enabled"`
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas int32  `json:"maxReplicas"`
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// +optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+}
+
+// NetworkingSpec declares the traffic of the Application. When it is set
+// all other traffic to and from its pods, except DNS lookups, is denied.
+type NetworkingSpec struct {
+	// AllowFrom lists the Applications that may call this one on its
+	// container port.
+	// +optional
+	AllowFrom []ApplicationPeer `json:"allowFrom,omitempty"`
+	// AllowTo lists the Applications this one calls on their container port.
+	// +optional
+	AllowTo []ApplicationPeer `json:"allowTo,omitempty"`
+	// Egress lists the networks outside the cluster this one calls.
+	// +optional
+	Egress []CIDRPeer `json:"egress,omitempty"`
+}
+
+// ApplicationPeer selects Applications either by name or by label.
+type ApplicationPeer struct {
+	// Namespace defaults to the namespace of the Application.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Selector matches the labels of Applications.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type CIDRPeer struct {
+	CIDR string `json:"cidr"`
+	// +optional
+	Except []string `json:"except,omitempty"`
+	// Ports restricts the traffic to these ports. Any port is allowed when
+	// empty.
+	// +optional
+	Ports []NetworkPort `json:"ports,omitempty"`
+}
+
+type NetworkPort struct {
+	Port int32 `json:"port"`
+	// +optional
+	// +kubebuilder:default=TCP
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+}
+
+type StorageSpec struct {
+	// Volumes are mounted into the application container.
+	// +optional
+	Volumes []StorageVolume `json:"volumes,omitempty"`
+}
+
+// StorageVolume is backed by exactly one of EmptyDir or
+// PersistentVolumeClaim.
+type StorageVolume struct {
+	// Name of the volume in the pod, other than the config volumes. Claims
+	// are named <application>-<name>.
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	// +optional
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// +optional
+	EmptyDir *v1.EmptyDirVolumeSource `json:"emptyDir,omitempty"`
+	// +optional
+	PersistentVolumeClaim *PersistentVolumeClaimSpec `json:"persistentVolumeClaim,omitempty"`
+}
+
+// PersistentVolumeClaimSpec is a claim created and owned by the operator.
+type PersistentVolumeClaimSpec struct {
+	// Size is the requested capacity. Growing it expands the claim when
+	// its storage class allows it; shrinking is not supported.
+	Size resource.Quantity `json:"size"`
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes default to ReadWriteOnce.
+	// +optional
+	AccessModes []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// RetainPolicy Retain keeps the claim when the Application or the volume
+	// is deleted.
+	// +optional
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +kubebuilder:default=Delete
+	RetainPolicy string `json:"retainPolicy,omitempty"`
+}
+
+// WorkloadSpec tunes the workload running the pods of an Application.
+type WorkloadSpec struct {
+	// PodManagementPolicy of a StatefulSet, OrderedReady by default. It
+	// cannot be changed once the StatefulSet exists.
+	// +optional
+	// +kubebuilder:validation:Enum=OrderedReady;Parallel
+	PodManagementPolicy string `json:"podManagementPolicy,omitempty"`
+	// Partition stages a StatefulSet rolling update: only pods with an
+	// ordinal of at least Partition are updated.
+	// +optional
+	Partition *int32 `json:"partition,omitempty"`
+	// AllowMigration lets the operator replace the workload of an existing
+	// Application when WorkloadKind changes. The new workload is created
+	// next to the old one, which is deleted once the new one is ready. The
+	// content of claims is not copied.
+	// +optional
+	AllowMigration bool `json:"allowMigration,omitempty"`
+}
+
+// HooksSpec declares the Jobs run when the pod template of an Application
+// changes. Each hook runs once per pod template.
+type HooksSpec struct {
+	// PreDeploy runs with the new pod template, e.g. a database migration.
+	// The workload is only updated once it succeeded.
+	// +optional
+	PreDeploy *HookSpec `json:"preDeploy,omitempty"`
+	// PostDeploy runs once the workload has rolled out the new pod template.
+	// +optional
+	PostDeploy *HookSpec `json:"postDeploy,omitempty"`
+}
+
+// HookSpec is the Job of a hook. Its pod runs the application container,
+// with its config and volumes, under the command given here.
+type HookSpec struct {
+	// Image defaults to the image of the application.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// +optional
+	Args []string `json:"args,omitempty"`
+	// Env is added to the environment of the application container.
+	// +optional
+	Env []v1.EnvVar `json:"env,omitempty"`
+	// +optional
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+	// BackoffLimit is the number of retries before the hook fails.
+	// +optional
+	// +kubebuilder:default=2
+	// +kubebuilder:validation:Minimum=0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// Timeout fails the hook when it runs longer, retries included. It is
+	// 10 minutes by default.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// SecuritySpec renders the security contexts of the pods from a profile.
+type SecuritySpec struct {
+	// Profile is restricted, which meets the restricted Pod Security
+	// Standard, baseline, which meets the baseline one while letting the
+	// image run as root, or custom, which uses the security contexts below
+	// as given.
+	// +optional
+	// +kubebuilder:validation:Enum=restricted;baseline;custom
+	// +kubebuilder:default=restricted
+	Profile string `json:"profile,omitempty"`
+	// RunAsUser overrides the user of the image.
+	// +optional
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+	// FSGroup owns the volumes of the pods.
+	// +optional
+	FSGroup *int64 `json:"fsGroup,omitempty"`
+	// ReadOnlyRootFilesystem is true by default with the restricted profile.
+	// +optional
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+	// WritablePaths are backed by emptyDir volumes when the root filesystem
+	// is read-only. They default to /tmp.
+	// +optional
+	WritablePaths []string `json:"writablePaths,omitempty"`
+	// PodSecurityContext is used by the custom profile.
+	// +optional
+	PodSecurityContext *v1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	// ContainerSecurityContext is used by the custom profile for every
+	// container without its own.
+	// +optional
+	ContainerSecurityContext *v1.SecurityContext `json:"containerSecurityContext,omitempty"`
+}
+
+// ImagePinningSpec runs the application image by the digest its tag
+// resolves to in the registry, so that a tag moved in the registry only
+// rolls out when the operator resolves it again.
+type ImagePinningSpec struct {
+	// ResolveInterval resolves the tag again this often and rolls out the
+	// new digest when the tag moved. The tag is only resolved again when
+	// the image changes when it is not set.
+	// +optional
+	ResolveInterval *metav1.Duration `json:"resolveInterval,omitempty"`
+}
+
+// ImageUpdateSpec follows new releases of the application image by
+// polling the tags of its repository. A tag must satisfy every filter that
+// is set, and at least one must be.
+type ImageUpdateSpec struct {
+	// Semver is a range like ^1.2 or >=1.0 <2.0 the tag must satisfy.
+	// +optional
+	Semver string `json:"semver,omitempty"`
+	// Pattern is a regular expression the whole tag must match. With only
+	// a pattern, tags are ordered by semantic version when they are one
+	// and lexically otherwise.
+	// +optional
+	Pattern string `json:"pattern,omitempty"`
+	// Interval is how often the registry is polled. It defaults to 5m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// WriteBack writes the selected image to spec.app.image instead of
+	// only running it.
+	// +optional
+	WriteBack bool `json:"writeBack,omitempty"`
+}
+
 type SchedulerSpec struct {
-	NodeSelector            map[string]string       `json:"nodeSelector,omitempty"`
-	PodDisruptionBudgetSpec PodDisruptionBudgetSpec `json:"podDisruptionBudget,omitempty"`
-	Affinity                *v1.Affinity            `json:"affinity,omitempty"`
-	// TODO: need hpa spec
+	NodeSelector            map[string]string           `json:"nodeSelector,omitempty"`
+	PodDisruptionBudgetSpec PodDisruptionBudgetSpec     `json:"podDisruptionBudget,omitempty"`
+	Affinity                *v1.Affinity                `json:"affinity,omitempty"`
+	HPA                     HorizontalPodAutoscalerSpec `json:"hpa,omitempty"`
 }
 
 // ApplicationSpec defines the desired state of Application
@@ -102,12 +470,144 @@ type ApplicationSpec struct {
 	TerminationGracePeriodSeconds *int64        `json:"terminationGracePeriodSeconds,omitempty"`
 	Service                       ServiceSpec   `json:"service,omitempty"`
 	Ingress                       IngressSpec   `json:"ingress,omitempty"`
+	// +optional
+	Networking *NetworkingSpec `json:"networking,omitempty"`
+	// +optional
+	Storage StorageSpec `json:"storage,omitempty"`
+	// WorkloadKind runs the pods in a Deployment, or in a StatefulSet with
+	// a headless Service and one claim per replica for each claim volume.
+	// +optional
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	// +kubebuilder:default=Deployment
+	WorkloadKind string `json:"workloadKind,omitempty"`
+	// +optional
+	Workload WorkloadSpec `json:"workload,omitempty"`
+	// Hooks run Jobs around each rollout of a new pod template.
+	// +optional
+	Hooks *HooksSpec `json:"hooks,omitempty"`
+	// Security hardens the pods. Their security contexts are left to the
+	// images when it is not set.
+	// +optional
+	Security *SecuritySpec `json:"security,omitempty"`
+	// ImagePinning pins spec.app.image to a digest. Images that carry a
+	// digest already are run as they are.
+	// +optional
+	ImagePinning *ImagePinningSpec `json:"imagePinning,omitempty"`
+	// ImageUpdate updates spec.app.image to the newest matching tag of its
+	// repository. Images that carry a digest are not updated.
+	// +optional
+	ImageUpdate *ImageUpdateSpec `json:"imageUpdate,omitempty"`
 }
 
 // ApplicationStatus defines the observed state of Application
 type ApplicationStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions holds one condition per managed resource kind.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Shard is the identity of the operator replica that last reconciled
+	// the Application when sharding is enabled.
+	// +optional
+	Shard string `json:"shard,omitempty"`
+	// Image is the digest the application image was pinned to.
+	// +optional
+	Image *ImageStatus `json:"image,omitempty"`
+	// ImageUpdate records the tags selected by spec.imageUpdate.
+	// +optional
+	ImageUpdate *ImageUpdateStatus `json:"imageUpdate,omitempty"`
+	// Signature records the verification of the signature of the image.
+	// +optional
+	Signature *SignatureStatus `json:"signature,omitempty"`
+	// Certificate describes the certificate served by the ingress.
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+	// DNS is the record published for the ingress host.
+	// +optional
+	DNS *DNSRecordStatus `json:"dns,omitempty"`
+	// QuotaRequested is what the pods last applied for the Application
+	// count against the ResourceQuotas of its namespace. The quota checks
+	// of the other Applications in the namespace read it instead of
+	// rendering the Application again.
+	// +optional
+	QuotaRequested v1.ResourceList `json:"quotaRequested,omitempty"`
+}
+
+// DNSRecordStatus describes a published DNS record.
+type DNSRecordStatus struct {
+	Name string `json:"name"`
+	// Type is A or CNAME.
+	Type string `json:"type"`
+	// +optional
+	Targets []string `json:"targets,omitempty"`
+	// SyncedAt is when the record was last checked with the provider. It
+	// is checked again once the resync period has passed.
+	// +optional
+	SyncedAt metav1.Time `json:"syncedAt,omitempty"`
+}
+
+// CertificateStatus describes a served TLS certificate.
+type CertificateStatus struct {
+	SecretName string `json:"secretName"`
+	// Issuer is the issuer of the operator that issued the certificate, or
+	// empty for a Secret given in the spec.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// NotAfter is when the certificate expires.
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
+// SignatureStatus records a verified image signature.
+type SignatureStatus struct {
+	// Image is the repository and digest that were verified.
+	Image string `json:"image"`
+	// Reference is the image that resolved to Image. While it is the image
+	// of the application, Image is run in its place.
+	// +optional
+	Reference string `json:"reference,omitempty"`
+	// Key is the name of the key that signed Image.
+	Key string `json:"key"`
+	// VerifiedAt is when the signature was verified.
+	VerifiedAt metav1.Time `json:"verifiedAt"`
+}
+
+// ImageStatus records the resolution of an image tag to a digest.
+type ImageStatus struct {
+	// Image is the reference that was resolved.
+	Image string `json:"image"`
+	// Digest is the manifest digest Image resolved to.
+	Digest string `json:"digest"`
+	// ResolvedAt is when Image was last resolved.
+	ResolvedAt metav1.Time `json:"resolvedAt"`
+}
+
+// ImageUpdateStatus records the polls of spec.imageUpdate.
+type ImageUpdateStatus struct {
+	// Image is the image selected for spec.app.image. Without write-back it
+	// is run in its place.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Base is the spec.app.image Image was selected for. A change of it
+	// discards the selection and polls again.
+	Base string `json:"base"`
+	// LastPolled is when the registry was last polled.
+	LastPolled metav1.Time `json:"lastPolled"`
+	// History lists the last updates, most recent first.
+	// +optional
+	History []ImageUpdateRecord `json:"history,omitempty"`
+}
+
+// ImageUpdateRecord is one update of the application image.
+type ImageUpdateRecord struct {
+	From string      `json:"from"`
+	To   string      `json:"to"`
+	Time metav1.Time `json:"time"`
 }
 
 //+kubebuilder:object:root=true
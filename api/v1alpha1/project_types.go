@@ -0,0 +1,128 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProjectMember is granted a role in every namespace of the Project.
+type ProjectMember struct {
+	// +kubebuilder:validation:Enum=User;Group;ServiceAccount
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Namespace of a ServiceAccount member.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Role is the ClusterRole bound to the member.
+	// +kubebuilder:validation:Enum=admin;edit;view
+	Role string `json:"role"`
+}
+
+// ProjectSpec defines the desired state of Project
+type ProjectSpec struct {
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+	// Namespaces are created when missing and labeled as belonging to the
+	// Project. Existing namespaces are only bound when annotated with
+	// app.cloudclub.com/allowed-project set to the name of the Project, and
+	// default and kube-* never are. A namespace belongs to at most one
+	// Project.
+	Namespaces []string `json:"namespaces"`
+	// +optional
+	Members []ProjectMember `json:"members,omitempty"`
+	// DefaultLabels are added to every object managed for the Applications
+	// of the Project.
+	// +optional
+	DefaultLabels map[string]string `json:"defaultLabels,omitempty"`
+	// AllowedDomains restricts the ingress hosts of the Applications of the
+	// Project to these domains and their subdomains. Any host is allowed when
+	// empty.
+	// +optional
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+	// Quota is the resource budget of each namespace of the Project.
+	// +optional
+	Quota *QuotaPolicy `json:"quota,omitempty"`
+	// SignatureKeys are trusted to sign the images of the Applications of
+	// the Project, in addition to the keys of the operator config.
+	// +optional
+	SignatureKeys []SignatureKey `json:"signatureKeys,omitempty"`
+}
+
+// SignatureKey is a public key trusted to sign images.
+type SignatureKey struct {
+	Name string `json:"name"`
+	// PublicKey is a PEM encoded ECDSA or RSA public key.
+	PublicKey string `json:"publicKey"`
+}
+
+// QuotaPolicy is enforced through a ResourceQuota and a LimitRange in each
+// namespace it applies to.
+type QuotaPolicy struct {
+	// Hard is the total amount of each resource the namespace may request,
+	// e.g. requests.cpu, limits.memory or pods.
+	// +optional
+	Hard v1.ResourceList `json:"hard,omitempty"`
+	// DefaultRequest and Default are the requests and limits of containers
+	// that do not set their own.
+	// +optional
+	DefaultRequest v1.ResourceList `json:"defaultRequest,omitempty"`
+	// +optional
+	Default v1.ResourceList `json:"default,omitempty"`
+	// Max bounds the limits of a single container.
+	// +optional
+	Max v1.ResourceList `json:"max,omitempty"`
+}
+
+// ProjectStatus defines the observed state of Project
+type ProjectStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Namespaces lists the namespaces currently bound to the Project.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// Project is the Schema for the projects API
+type Project struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ProjectSpec   `json:"spec,omitempty"`
+	Status            ProjectStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProjectList contains a list of Project
+type ProjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Project `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Project{}, &ProjectList{})
+}
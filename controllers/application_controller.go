@@ -18,14 +18,42 @@ package controllers
 
 import (
 	"context"
+	"time"
+
 	cloudclub "github.com/cloud-club/cloudclub-operator/internal"
-	corev1 "k8s.io/api/core/v1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/driver"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/requeue"
+	"github.com/cloud-club/cloudclub-operator/internal/shard"
 
 	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
-	appsv1 "k8s.io/api/apps/v1"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// mapTimeout bounds the lookups of the Applications an event maps to.
+	mapTimeout = 30 * time.Second
+	// peerIndex indexes Applications by the keys of their network peers.
+	peerIndex = "spec.networking.peers"
+	// configIndex indexes Applications by the keys of the ConfigMaps and
+	// Secrets they refer to.
+	configIndex = "spec.app.config.refs"
 )
 
 // ApplicationReconciler reconciles a Application object
@@ -33,15 +61,39 @@ type ApplicationReconciler struct {
 	client.Client
 	CloudClub *cloudclub.Manager
 	Scheme    *runtime.Scheme
+	Config    *config.Store
+	// Shard restricts the reconciler to its hash range of Applications when
+	// sharding is enabled.
+	Shard *shard.Member
+	// OperatorNamespace, when set, is watched for changes of the Secrets
+	// named by the operator config, like registry credentials, by a cache
+	// of its own. It is needed when the namespace is not watched.
+	OperatorNamespace string
 }
 
 //+kubebuilder:rbac:groups=app.cloudclub.com,resources=applications,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.cloudclub.com,resources=applications/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.cloudclub.com,resources=applications/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods/logs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=app.cloudclub.com,resources=projects,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=resourcequotas;limitranges,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -53,15 +105,179 @@ type ApplicationReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	return r.CloudClub.ApplicationClient.Reconcile(ctx, req)
+	if r.Shard != nil && !r.Shard.Owns(req.NamespacedName) {
+		return ctrl.Result{}, nil
+	}
+	result, err := r.CloudClub.ApplicationClient.Reconcile(ctx, req)
+	return requeue.Apply(ctx, r.Config.Get().Reconcile, result, err)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		//TODO: add ingress,hpa,pdb
-		For(&appv1alpha1.Application{}).
-		Owns(&appsv1.Deployment{}).
-		Owns(&corev1.Service{}).
-		Complete(r)
+	resync := make(chan event.GenericEvent)
+	// The callbacks run on the goroutine of the config Store or the shard
+	// Member, which must not wait for the controller to drain the channel.
+	enqueueAll := func(ctx context.Context) {
+		go r.enqueueAll(ctx, resync)
+	}
+	r.Config.OnReload(enqueueAll)
+	if r.Shard != nil {
+		r.Shard.OnChange(enqueueAll)
+		// Every replica reconciles its own shard.
+		mgr = shard.EveryReplica(mgr)
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&appv1alpha1.Application{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		Watches(&source.Channel{Source: resync}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Config.Get().Reconcile.MaxConcurrentReconciles,
+			RateLimiter:             requeue.NewRateLimiter(r.Config.Get().Reconcile.RateLimiter),
+		})
+	// Peers are watched for creation, deletion and label changes, which
+	// change the NetworkPolicies of the Applications referring to them.
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1alpha1.Application{}, peerIndex, func(obj client.Object) []string {
+		return driver.PeerKeys(obj.(*appv1alpha1.Application))
+	}); err != nil {
+		return err
+	}
+	b = b.Watches(&source.Kind{Type: &appv1alpha1.Application{}}, handler.EnqueueRequestsFromMapFunc(r.networkPeers),
+		builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{})))
+	// Referenced config is watched so that external edits roll the pods.
+	// The events only find the referrers; the content is hashed from the API
+	// server, as the cache may not hold the edit yet when they arrive.
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1alpha1.Application{}, configIndex, func(obj client.Object) []string {
+		return driver.ConfigKeys(obj.(*appv1alpha1.Application))
+	}); err != nil {
+		return err
+	}
+	b = b.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.configReferrers(false)), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.configReferrers(true)), builder.OnlyMetadata)
+	if r.OperatorNamespace != "" {
+		secrets, err := cache.New(mgr.GetConfig(), cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper(), Namespace: r.OperatorNamespace})
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(secrets); err != nil {
+			return err
+		}
+		secret := &metav1.PartialObjectMetadata{}
+		secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		b = b.Watches(source.NewKindWithCache(secret, secrets), handler.EnqueueRequestsFromMapFunc(r.configReferrers(true)))
+	}
+	if r.CloudClub.ApplicationClient.Projects {
+		b = b.Watches(&source.Kind{Type: &appv1alpha1.Project{}}, handler.EnqueueRequestsFromMapFunc(r.projectApplications))
+	}
+	for _, d := range r.CloudClub.Drivers() {
+		// Optional kinds, like Gateway API routes, are only watched when
+		// their CRD is installed.
+		gvk, err := apiutil.GVKForObject(d.Object(), r.Scheme)
+		if err != nil {
+			return err
+		}
+		if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); meta.IsNoMatchError(err) {
+			log.Warn(context.Background(), "not watching "+d.Name()+": kind is not installed", zap.String("kind", gvk.String()))
+			continue
+		}
+		b = b.Owns(d.Object(), builder.WithPredicates(driver.Predicate(d)))
+	}
+	return b.Complete(r)
+}
+
+// enqueueAll queues every Application so a configuration change or a shard
+// rebalance is applied without waiting for the next event on each of them.
+func (r *ApplicationReconciler) enqueueAll(ctx context.Context, events chan<- event.GenericEvent) {
+	apps := &appv1alpha1.ApplicationList{}
+	if err := r.List(ctx, apps); err != nil {
+		log.Errorf(ctx, err)
+		return
+	}
+	for i := range apps.Items {
+		select {
+		case events <- event.GenericEvent{Object: &apps.Items[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// projectApplications maps a Project to the Applications in its namespaces,
+// so that policy changes are enforced right away.
+func (r *ApplicationReconciler) projectApplications(obj client.Object) []reconcile.Request {
+	p, ok := obj.(*appv1alpha1.Project)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mapTimeout)
+	defer cancel()
+	var requests []reconcile.Request
+	for _, namespace := range p.Status.Namespaces {
+		apps := &appv1alpha1.ApplicationList{}
+		if err := r.List(ctx, apps, client.InNamespace(namespace)); err != nil {
+			log.Errorf(ctx, err)
+			continue
+		}
+		for _, app := range apps.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+		}
+	}
+	return requests
+}
+
+// networkPeers maps an Application to the Applications whose networking
+// refers to it, by name or by a selector in its namespace.
+func (r *ApplicationReconciler) networkPeers(obj client.Object) []reconcile.Request {
+	peer, ok := obj.(*appv1alpha1.Application)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mapTimeout)
+	defer cancel()
+	var requests []reconcile.Request
+	for _, key := range []string{driver.PeerKey(peer.Namespace, peer.Name), driver.PeerKey(peer.Namespace, "")} {
+		apps := &appv1alpha1.ApplicationList{}
+		if err := r.List(ctx, apps, client.MatchingFields{peerIndex: key}); err != nil {
+			log.Errorf(ctx, err)
+			return nil
+		}
+		for i := range apps.Items {
+			app := &apps.Items[i]
+			if app.Namespace == peer.Namespace && app.Name == peer.Name {
+				continue
+			}
+			if driver.SelectsPeer(app, peer) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+			}
+		}
+	}
+	return requests
+}
+
+// configReferrers maps a ConfigMap, or a Secret when secret is true, to the
+// Applications in its namespace projecting it or serving it as ingress
+// certificate. The source of a registry credential and the CA of the local
+// certificate issuer map to every Application.
+func (r *ApplicationReconciler) configReferrers(secret bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ctx, cancel := context.WithTimeout(context.Background(), mapTimeout)
+		defer cancel()
+		apps := &appv1alpha1.ApplicationList{}
+		opts := []client.ListOption{client.InNamespace(obj.GetNamespace()), client.MatchingFields{configIndex: driver.ConfigKey(secret, obj.GetName())}}
+		if secret && (driver.IsRegistryCredential(r.Config.Get(), obj) || driver.IsCertificateAuthority(r.Config.Get(), obj)) {
+			opts = nil
+		}
+		if err := r.List(ctx, apps, opts...); err != nil {
+			log.Errorf(ctx, err)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(apps.Items))
+		for i := range apps.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&apps.Items[i])})
+		}
+		return requests
+	}
 }
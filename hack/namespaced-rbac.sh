@@ -0,0 +1,123 @@
+#!/usr/bin/env bash
+# Renders a Role and RoleBinding per namespace from the generated
+# config/rbac/role.yaml, for operators started with --watch-namespaces.
+# Each operator instance must be given a disjoint set of namespaces.
+#
+# Rules on cluster-scoped resources cannot be granted by a Role and are
+# dropped: Projects and the ClusterRoles they bind are only managed by an
+# operator watching the whole cluster. Reading Namespaces, for their pod
+# security and image policy labels and for --watch-namespace-selector, is
+# granted by a separate ClusterRole, and reading the Secrets of the operator
+# config, like registry credentials, by a Role in the operator namespace.
+#
+# Usage: hack/namespaced-rbac.sh team-a,team-b [operator-namespace] [service-account]
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+NAMESPACES=${1:?comma separated namespaces are required}
+OPERATOR_NAMESPACE=${2:-cloud-club-operator-system}
+SERVICE_ACCOUNT=${3:-cloud-club-operator-controller-manager}
+ROLE_FILE=$(dirname "$0")/../config/rbac/role.yaml
+CLUSTER_SCOPED='namespaces|projects|projects/status|projects/finalizers|clusterroles'
+
+# Prints the rules of the role file, less those naming a cluster-scoped
+# resource.
+RULES=$(awk -v cluster="^  - (${CLUSTER_SCOPED})\$" '
+  function flush() { if (rule != "" && !skip) printf "%s", rule; rule = ""; skip = 0 }
+  /^rules:/ { print; in_rules = 1; next }
+  !in_rules { next }
+  /^- / { flush() }
+  $0 ~ cluster { skip = 1 }
+  { rule = rule $0 "\n" }
+  END { flush() }
+' "${ROLE_FILE}")
+
+for ns in ${NAMESPACES//,/ }; do
+cat <<YAML
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: cloud-club-operator-manager-role
+  namespace: ${ns}
+${RULES}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: cloud-club-operator-manager-rolebinding
+  namespace: ${ns}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: cloud-club-operator-manager-role
+subjects:
+- kind: ServiceAccount
+  name: ${SERVICE_ACCOUNT}
+  namespace: ${OPERATOR_NAMESPACE}
+YAML
+done
+
+cat <<YAML
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: cloud-club-operator-namespace-reader
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - namespaces
+  verbs:
+  - get
+  - list
+  - watch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cloud-club-operator-namespace-reader
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cloud-club-operator-namespace-reader
+subjects:
+- kind: ServiceAccount
+  name: ${SERVICE_ACCOUNT}
+  namespace: ${OPERATOR_NAMESPACE}
+YAML
+
+cat <<YAML
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: cloud-club-operator-config-reader
+  namespace: ${OPERATOR_NAMESPACE}
+rules:
+- apiGroups:
+  - ""
+  resources:
+  - secrets
+  verbs:
+  - get
+  - list
+  - watch
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: cloud-club-operator-config-reader
+  namespace: ${OPERATOR_NAMESPACE}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: cloud-club-operator-config-reader
+subjects:
+- kind: ServiceAccount
+  name: ${SERVICE_ACCOUNT}
+  namespace: ${OPERATOR_NAMESPACE}
+YAML
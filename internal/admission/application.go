@@ -0,0 +1,58 @@
+// Package admission holds the admission webhooks of the operator.
+package admission
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/driver"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+//+kubebuilder:webhook:path=/validate-app-cloudclub-com-v1alpha1-application,mutating=false,failurePolicy=ignore,sideEffects=None,groups=app.cloudclub.com,resources=applications,verbs=create;update,versions=v1alpha1,name=vapplication.cloudclub.com,admissionReviewVersions=v1
+
+// ApplicationValidator rejects Applications that violate the policies the
+// reconcile would otherwise report in their status. It fails open: the
+// reconcile still enforces the policies when the webhook is unavailable.
+type ApplicationValidator struct {
+	Applications *driver.ApplicationClient
+}
+
+func (v *ApplicationValidator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&appv1alpha1.Application{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *ApplicationValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	app, ok := obj.(*appv1alpha1.Application)
+	if !ok {
+		return fmt.Errorf("expected an Application, got %T", obj)
+	}
+	return v.Applications.Validate(ctx, app)
+}
+
+// ValidateUpdate only checks changes to the spec, so that Applications that
+// started violating a policy later can still be relabelled and deleted.
+func (v *ApplicationValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	old, ok := oldObj.(*appv1alpha1.Application)
+	if !ok {
+		return fmt.Errorf("expected an Application, got %T", oldObj)
+	}
+	app, ok := newObj.(*appv1alpha1.Application)
+	if !ok {
+		return fmt.Errorf("expected an Application, got %T", newObj)
+	}
+	if !app.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(old.Spec, app.Spec) {
+		return nil
+	}
+	return v.Applications.Validate(ctx, app)
+}
+
+func (v *ApplicationValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package admission
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/driver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newValidator(t *testing.T, cfg *config.OperatorConfig, namespace *corev1.Namespace) *ApplicationValidator {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	store, err := config.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(cfg)
+	kube := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace).Build()
+	a, err := driver.NewApplicationClient(kube, scheme, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Drivers = driver.Builtin(kube, nil, scheme, store)
+	return &ApplicationValidator{Applications: a}
+}
+
+func TestValidateRejectsMutableTagsInSelectedNamespaces(t *testing.T) {
+	cfg := config.Default()
+	cfg.ImagePolicy.NamespaceSelector = "env=production"
+	production := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"env": "production"}}}
+	v := newValidator(t, cfg, production)
+
+	app := &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+		Spec:       appv1alpha1.ApplicationSpec{App: appv1alpha1.AppSpec{Image: "nginx:latest", ContainerPort: 80}},
+	}
+	err := v.ValidateCreate(context.Background(), app)
+	if err == nil || !strings.Contains(err.Error(), "mutable tag latest") {
+		t.Errorf("err = %v, want the mutable tag rejected", err)
+	}
+
+	// Metadata changes of an existing violating Application are allowed.
+	relabelled := app.DeepCopy()
+	relabelled.Labels = map[string]string{"team": "a"}
+	if err := v.ValidateUpdate(context.Background(), app, relabelled); err != nil {
+		t.Errorf("relabelling was rejected: %v", err)
+	}
+
+	app.Spec.App.Image = "nginx:1.25"
+	if err := v.ValidateCreate(context.Background(), app); err != nil {
+		t.Errorf("pinned tag was rejected: %v", err)
+	}
+}
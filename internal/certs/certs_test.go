@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newCA(t *testing.T) *CA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := LoadCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func TestIssueAndRenew(t *testing.T) {
+	now := time.Now()
+	hosts := []string{"web.example.com"}
+	for name, ca := range map[string]*CA{"self-signed": nil, "local CA": newCA(t)} {
+		certPEM, _, err := Issue(ca, hosts, now, 90*24*time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		cert, err := Parse(certPEM)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if reason := NeedsRenewal(cert, ca, hosts, now, 30*24*time.Hour); reason != "" {
+			t.Errorf("%s: fresh certificate needs renewal: %s", name, reason)
+		}
+		if reason := NeedsRenewal(cert, ca, hosts, now.Add(61*24*time.Hour), 30*24*time.Hour); reason != "expiring" {
+			t.Errorf("%s: renewal 29 days before expiry = %q", name, reason)
+		}
+		if reason := NeedsRenewal(cert, ca, []string{"api.example.com"}, now, 30*24*time.Hour); reason != "hosts changed" {
+			t.Errorf("%s: renewal for other hosts = %q", name, reason)
+		}
+		if reason := NeedsRenewal(cert, newCA(t), hosts, now, 30*24*time.Hour); reason != "issuer changed" {
+			t.Errorf("%s: renewal for another CA = %q", name, reason)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package cloudclub
 
 import (
+	"fmt"
+
+	"github.com/cloud-club/cloudclub-operator/internal/config"
 	"github.com/cloud-club/cloudclub-operator/internal/driver"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -8,14 +11,51 @@ import (
 
 type Manager struct {
 	ApplicationClient *driver.ApplicationClient
+
+	drivers map[string]driver.ResourceDriver
+	enabled map[string]bool
 }
 
-func NewManager(kube client.Client, schema *runtime.Scheme) (*Manager, error) {
-	applicationClient, err := driver.NewApplicationClient(kube, schema)
+// NewManager registers the builtin resource drivers and enables the ones
+// named in enabled, or all of them when enabled is empty. reader reads the
+// objects the cache of kube may not hold; kube is used when it is nil.
+func NewManager(kube client.Client, reader client.Reader, schema *runtime.Scheme, cfg *config.Store, enabled []string) (*Manager, error) {
+	applicationClient, err := driver.NewApplicationClient(kube, schema, cfg)
 	if err != nil {
 		return nil, err
 	}
-	return &Manager{
+	applicationClient.Reader = reader
+	m := &Manager{
 		ApplicationClient: applicationClient,
-	}, nil
+		drivers:           map[string]driver.ResourceDriver{},
+	}
+	if len(enabled) > 0 {
+		m.enabled = map[string]bool{}
+		for _, name := range enabled {
+			m.enabled[name] = true
+		}
+	}
+	for _, d := range driver.Builtin(kube, reader, schema, cfg) {
+		m.Register(d)
+	}
+	for name := range m.enabled {
+		if _, ok := m.drivers[name]; !ok {
+			return nil, fmt.Errorf("unknown resource driver %q", name)
+		}
+	}
+	return m, nil
+}
+
+// Register adds d to the registry. Enabled drivers are reconciled after the
+// ones registered before them.
+func (m *Manager) Register(d driver.ResourceDriver) {
+	m.drivers[d.Name()] = d
+	if m.enabled == nil || m.enabled[d.Name()] {
+		m.ApplicationClient.Drivers = append(m.ApplicationClient.Drivers, d)
+	}
+}
+
+// Drivers returns the enabled drivers in reconcile order.
+func (m *Manager) Drivers() []driver.ResourceDriver {
+	return m.ApplicationClient.Drivers
 }
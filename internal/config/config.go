@@ -0,0 +1,278 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "config.cloudclub.com/v1alpha1"
+	Kind       = "OperatorConfig"
+)
+
+// OperatorConfig holds the cluster-wide policy applied to every Application.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Ingress IngressConfig `json:"ingress,omitempty"`
+	// Resources are the container resources used for each AppType when the
+	// Application does not set its own.
+	Resources map[string]corev1.ResourceRequirements `json:"resources,omitempty"`
+	// AllowedRegistries restricts application images to these registry
+	// hosts. Any registry is allowed when empty.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// ImagePolicy forbids mutable image references in some namespaces.
+	ImagePolicy ImagePolicyConfig `json:"imagePolicy,omitempty"`
+	// ImageSignatures requires application images to be signed.
+	ImageSignatures ImageSignatureConfig `json:"imageSignatures,omitempty"`
+	// RegistryCredentials are image pull secrets copied into the namespace
+	// of every Application and added to its pods.
+	RegistryCredentials []RegistryCredential `json:"registryCredentials,omitempty"`
+	// InsecureRegistries are spoken to over plain HTTP when images are
+	// pinned, updated or verified, e.g. a local registry.
+	InsecureRegistries []string `json:"insecureRegistries,omitempty"`
+	// DNS publishes records for the ingress hosts of Applications.
+	DNS DNSConfig `json:"dns,omitempty"`
+	// DefaultLabels and DefaultAnnotations are added to every managed object.
+	DefaultLabels      map[string]string `json:"defaultLabels,omitempty"`
+	DefaultAnnotations map[string]string `json:"defaultAnnotations,omitempty"`
+	// Drivers toggles management of each resource kind by driver name.
+	// Drivers missing from the map are enabled.
+	Drivers map[string]bool `json:"drivers,omitempty"`
+	// SidecarPresets are named sidecars that Applications add by setting
+	// the preset of a sidecar, e.g. a log shipper or a database proxy.
+	SidecarPresets map[string]SidecarPreset `json:"sidecarPresets,omitempty"`
+	Reconcile      ReconcileConfig          `json:"reconcile,omitempty"`
+	Watch          WatchConfig              `json:"watch,omitempty"`
+}
+
+type IngressConfig struct {
+	// ClassName is set on Ingresses that do not choose a class.
+	ClassName string `json:"className,omitempty"`
+	// Domain is used to build <name>.<namespace>.<domain> hosts for
+	// Applications without an ingress host.
+	Domain string `json:"domain,omitempty"`
+	// ControllerNamespace is the namespace of the ingress controller, which
+	// is allowed to call Applications that have an ingress and declare their
+	// networking. No namespace is allowed when empty, so the ingress of
+	// such Applications only works once it is set.
+	ControllerNamespace string `json:"controllerNamespace,omitempty"`
+	// TLS configures the certificates issued for ingresses.
+	TLS IngressTLSConfig `json:"tls,omitempty"`
+	// Mode exposes Applications that choose none through an Ingress or,
+	// with Gateway, a Gateway API HTTPRoute. It defaults to Ingress.
+	Mode string `json:"mode,omitempty"`
+	// Gateway is the parent of the HTTPRoutes of Applications naming none.
+	Gateway GatewayRef `json:"gateway,omitempty"`
+}
+
+// DNSConfig selects the DNS provider records are published with.
+type DNSConfig struct {
+	// Provider is rfc2136 or file. No records are published when it is
+	// empty.
+	Provider string `json:"provider,omitempty"`
+	// OwnerID tells the records of this operator from those of other
+	// operators publishing into the same zone.
+	OwnerID string `json:"ownerID,omitempty"`
+	// TTL of the published records in seconds.
+	TTL uint32 `json:"ttl,omitempty"`
+	// Zone is the zone records are published into, e.g. apps.example.com.
+	// Hosts outside of it are left alone.
+	Zone    string         `json:"zone,omitempty"`
+	RFC2136 RFC2136Config  `json:"rfc2136,omitempty"`
+	File    FileZoneConfig `json:"file,omitempty"`
+}
+
+// RFC2136Config configures dynamic updates of an authoritative server.
+type RFC2136Config struct {
+	// Server is the host:port of the primary server of the zone.
+	Server string `json:"server,omitempty"`
+	// TSIGKeyName names the key updates are signed with.
+	TSIGKeyName string `json:"tsigKeyName,omitempty"`
+	// TSIGAlgorithm defaults to hmac-sha256.
+	TSIGAlgorithm string `json:"tsigAlgorithm,omitempty"`
+	// TSIGSecretRef is a Secret whose secret key holds the base64 encoded
+	// TSIG secret.
+	TSIGSecretRef *corev1.SecretReference `json:"tsigSecretRef,omitempty"`
+}
+
+// FileZoneConfig configures a zone file, for local testing.
+type FileZoneConfig struct {
+	Path string `json:"path,omitempty"`
+}
+
+// GatewayRef names a Gateway API Gateway.
+type GatewayRef struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// SectionName attaches routes to a single listener.
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// IngressTLSConfig configures the issuers of ingress certificates.
+type IngressTLSConfig struct {
+	// Issuer is used by Applications that choose none: cert-manager or
+	// local. It defaults to local.
+	Issuer string `json:"issuer,omitempty"`
+	// CertManagerIssuer is the issuer cert-manager Certificates refer to.
+	CertManagerIssuer CertManagerIssuerRef `json:"certManagerIssuer,omitempty"`
+	// CASecret is a kubernetes.io/tls Secret holding the CA the local
+	// issuer signs with, usually in the namespace of the operator. Local
+	// certificates are self-signed without one.
+	CASecret *corev1.SecretReference `json:"caSecret,omitempty"`
+	// Duration is the validity of local certificates.
+	Duration metav1.Duration `json:"duration,omitempty"`
+	// RenewBefore is how long before they expire local certificates are
+	// issued again.
+	RenewBefore metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// CertManagerIssuerRef names a cert-manager issuer.
+type CertManagerIssuerRef struct {
+	Name string `json:"name,omitempty"`
+	// Kind is ClusterIssuer, the default, or Issuer, which is looked up in
+	// the namespace of each Application.
+	Kind string `json:"kind,omitempty"`
+}
+
+// ImagePolicyConfig restricts the image references of Applications in the
+// namespaces it selects.
+type ImagePolicyConfig struct {
+	// NamespaceSelector is a label selector on Namespace objects, e.g.
+	// "cloudclub.com/environment=production". No namespace is selected when
+	// it is empty.
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// MutableTags are forbidden unless the image is pinned by digest. An
+	// image without a tag counts as latest. It defaults to latest.
+	MutableTags []string `json:"mutableTags,omitempty"`
+	// RequireDigest requires every image to be pinned by digest.
+	RequireDigest bool `json:"requireDigest,omitempty"`
+}
+
+// ImageSignatureConfig lists the keys application images must carry a
+// cosign-style signature of.
+type ImageSignatureConfig struct {
+	// Keys are trusted for every Application, in addition to the keys of
+	// its Project. Images are not verified when no key applies.
+	Keys []SignatureKey `json:"keys,omitempty"`
+}
+
+// SignatureKey is a trusted public key.
+type SignatureKey struct {
+	Name string `json:"name"`
+	// PublicKey is a PEM encoded ECDSA or RSA public key.
+	PublicKey string `json:"publicKey"`
+}
+
+// RegistryCredential is an image pull secret shared by all Applications.
+type RegistryCredential struct {
+	// SecretRef is the source Secret, usually in the namespace of the
+	// operator. Its copies are refreshed when it changes.
+	SecretRef corev1.SecretReference `json:"secretRef"`
+	// Name is the name of the copies. It defaults to the name of the
+	// source.
+	Name string `json:"name,omitempty"`
+}
+
+// SidecarPreset is the container added for a preset. Its name is replaced by
+// the name of the sidecar in the Application.
+type SidecarPreset struct {
+	corev1.Container `json:",inline"`
+	// Expose lists the names of the ports added to the Service of the
+	// Application.
+	Expose []string `json:"expose,omitempty"`
+}
+
+type ReconcileConfig struct {
+	// MaxConcurrentReconciles and RateLimiter are read at startup only.
+	MaxConcurrentReconciles int               `json:"maxConcurrentReconciles,omitempty"`
+	RateLimiter             RateLimiterConfig `json:"rateLimiter,omitempty"`
+	// ResyncPeriod requeues every successfully reconciled Application after
+	// this long to correct drift. Zero disables periodic resyncs.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// ConflictDelay is the retry delay after an update conflict.
+	ConflictDelay metav1.Duration `json:"conflictDelay,omitempty"`
+	// QuotaExceededDelay is the retry delay after the API server rejected a
+	// write for exceeding a ResourceQuota.
+	QuotaExceededDelay metav1.Duration `json:"quotaExceededDelay,omitempty"`
+}
+
+// RateLimiterConfig combines a per-Application exponential backoff with an
+// overall token bucket, like the controller-runtime default.
+type RateLimiterConfig struct {
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+	MaxDelay  metav1.Duration `json:"maxDelay,omitempty"`
+	QPS       float64         `json:"qps,omitempty"`
+	Burst     int             `json:"burst,omitempty"`
+}
+
+// WatchConfig restricts the namespaces the operator watches. It is read at
+// startup only and overridden by the --watch-* flags.
+type WatchConfig struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector is a label selector on Namespace objects, e.g.
+	// "cloudclub.com/tenant=team-a".
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *OperatorConfig {
+	return &OperatorConfig{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		DNS:      DNSConfig{OwnerID: "cloudclub-operator", TTL: 300},
+		Ingress: IngressConfig{
+			TLS: IngressTLSConfig{
+				Issuer:      "local",
+				Duration:    metav1.Duration{Duration: 90 * 24 * time.Hour},
+				RenewBefore: metav1.Duration{Duration: 30 * 24 * time.Hour},
+			},
+		},
+		Reconcile: ReconcileConfig{
+			MaxConcurrentReconciles: 1,
+			RateLimiter: RateLimiterConfig{
+				BaseDelay: metav1.Duration{Duration: 5 * time.Millisecond},
+				MaxDelay:  metav1.Duration{Duration: 1000 * time.Second},
+				QPS:       10,
+				Burst:     100,
+			},
+			ResyncPeriod:       metav1.Duration{Duration: 10 * time.Minute},
+			ConflictDelay:      metav1.Duration{Duration: 100 * time.Millisecond},
+			QuotaExceededDelay: metav1.Duration{Duration: 5 * time.Minute},
+		},
+	}
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*OperatorConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return nil, fmt.Errorf("%s: unsupported config %s %s, want %s %s", path, cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	return cfg, nil
+}
+
+// validate rejects settings that are only wrong together.
+func (c *OperatorConfig) validate() error {
+	if tls := c.Ingress.TLS; tls.RenewBefore.Duration >= tls.Duration.Duration {
+		return fmt.Errorf("ingress.tls.renewBefore %s must be shorter than ingress.tls.duration %s", tls.RenewBefore.Duration, tls.Duration.Duration)
+	}
+	return nil
+}
+
+// DriverEnabled reports whether the driver called name is toggled on.
+func (c *OperatorConfig) DriverEnabled(name string) bool {
+	enabled, ok := c.Drivers[name]
+	return !ok || enabled
+}
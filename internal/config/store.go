@@ -0,0 +1,113 @@
+package config
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Store holds the current configuration and reloads it when the file
+// changes. It implements manager.Runnable.
+type Store struct {
+	path      string
+	current   atomic.Value
+	overrides []func(*OperatorConfig)
+	onReload  []func(context.Context)
+}
+
+// NewStore loads the configuration at path, or the defaults when path is
+// empty. The overrides, typically command line flags, are applied on top of
+// the file every time it is loaded.
+func NewStore(path string, overrides ...func(*OperatorConfig)) (*Store, error) {
+	s := &Store{path: path, overrides: overrides}
+	cfg, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+func (s *Store) load() (*OperatorConfig, error) {
+	cfg := Default()
+	if s.path != "" {
+		var err error
+		if cfg, err = Load(s.path); err != nil {
+			return nil, err
+		}
+	}
+	for _, override := range s.overrides {
+		override(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Get returns the current configuration. Callers must not modify it.
+func (s *Store) Get() *OperatorConfig {
+	return s.current.Load().(*OperatorConfig)
+}
+
+// Set replaces the current configuration.
+func (s *Store) Set(cfg *OperatorConfig) {
+	s.current.Store(cfg)
+}
+
+// OnReload registers fn to be called after every successful reload. It must
+// be called before the Store is started.
+func (s *Store) OnReload(fn func(context.Context)) {
+	s.onReload = append(s.onReload, fn)
+}
+
+// NeedLeaderElection reloads the configuration on every replica, since
+// webhooks and sharded controllers run on all of them.
+func (s *Store) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the directory of the configuration file until ctx is done.
+// The directory is watched rather than the file because ConfigMap volumes
+// swap a symlink on update. Invalid files are logged and ignored.
+func (s *Store) Start(ctx context.Context) error {
+	if s.path == "" {
+		<-ctx.Done()
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			log.Errorf(ctx, err)
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			cfg, err := s.load()
+			if err != nil {
+				log.Error(ctx, "ignoring invalid operator config", zap.Error(err))
+				continue
+			}
+			s.Set(cfg)
+			log.Info(ctx, "reloaded operator config", zap.String("path", s.path))
+			for _, fn := range s.onReload {
+				fn(ctx)
+			}
+		}
+	}
+}
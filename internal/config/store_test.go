@@ -0,0 +1,113 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const validConfig = `apiVersion: config.cloudclub.com/v1alpha1
+kind: OperatorConfig
+ingress:
+  domain: apps.example.com
+`
+
+// writeConfig replaces the file at once, as the kubelet does for ConfigMap
+// volumes, so that the store never reads it half written.
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewStoreLoadsDefaults(t *testing.T) {
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Get().Reconcile.ResyncPeriod.Duration; got != 10*time.Minute {
+		t.Errorf("resync period = %v, want the default", got)
+	}
+}
+
+func TestNewStoreAppliesOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, validConfig)
+	s, err := NewStore(path, func(cfg *OperatorConfig) { cfg.Reconcile.MaxConcurrentReconciles = 4 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := s.Get()
+	if cfg.Ingress.Domain != "apps.example.com" {
+		t.Errorf("domain = %q, want the one of the file", cfg.Ingress.Domain)
+	}
+	if cfg.Reconcile.MaxConcurrentReconciles != 4 {
+		t.Errorf("max concurrent reconciles = %d, want the override", cfg.Reconcile.MaxConcurrentReconciles)
+	}
+	// Fields missing from the file keep their defaults.
+	if cfg.DNS.TTL != 300 {
+		t.Errorf("dns ttl = %d, want the default", cfg.DNS.TTL)
+	}
+}
+
+func TestNewStoreRejectsInvalidFiles(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown field": validConfig + "unknown: true\n",
+		"wrong kind":    "apiVersion: config.cloudclub.com/v1alpha1\nkind: Other\n",
+		"late renewal":  validConfig + "  tls:\n    duration: 24h\n    renewBefore: 24h\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			writeConfig(t, path, content)
+			if _, err := NewStore(path); err == nil {
+				t.Error("NewStore accepted an invalid config")
+			}
+		})
+	}
+}
+
+func TestStoreReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, validConfig)
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan string, 10)
+	s.OnReload(func(context.Context) { reloaded <- s.Get().Ingress.Domain })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Start(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// An invalid file is ignored until it is fixed. The watch may not be
+	// set up yet, so the file is rewritten until a reload is seen.
+	writeConfig(t, path, "not: [yaml")
+	deadline := time.After(10 * time.Second)
+	for {
+		writeConfig(t, path, validConfig+"  className: nginx\n")
+		select {
+		case domain := <-reloaded:
+			if domain != "apps.example.com" || s.Get().Ingress.ClassName != "nginx" {
+				t.Errorf("reloaded config = %+v", s.Get().Ingress)
+			}
+			return
+		case <-deadline:
+			t.Fatal("config was not reloaded")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
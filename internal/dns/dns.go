@@ -0,0 +1,199 @@
+// Package dns publishes the records of ingress hosts through DNS providers,
+// claiming each name with an ownership TXT record so that records created
+// by others are never replaced.
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Record types managed by the operator.
+const (
+	TypeA     = "A"
+	TypeCNAME = "CNAME"
+	TypeTXT   = "TXT"
+)
+
+// Record is the record set of a name and type.
+type Record struct {
+	// Name is fully qualified, without the trailing dot.
+	Name    string
+	Type    string
+	Targets []string
+	TTL     uint32
+}
+
+// Provider reads and writes the records of a zone.
+type Provider interface {
+	// Records returns the A, CNAME and TXT records of name.
+	Records(ctx context.Context, name string) ([]Record, error)
+	// Replace makes r the only record set of its name and type.
+	Replace(ctx context.Context, r Record) error
+	// Delete removes the record set of name and recordType.
+	Delete(ctx context.Context, name, recordType string) error
+}
+
+// ErrNotOwned is returned for names whose records belong to someone else.
+var ErrNotOwned = errors.New("records are not owned by the operator")
+
+// ownerPrefix names the ownership record of a name. It lives beside the
+// name, as a CNAME cannot share its name with a TXT record.
+const ownerPrefix = "_cloudclub-owner."
+
+// Owner identifies the operator and the object a name is published for.
+type Owner struct {
+	// ID tells operators sharing a zone apart.
+	ID string
+	// Resource is the object the records are published for.
+	Resource string
+}
+
+func (o Owner) txt() string {
+	return fmt.Sprintf("heritage=cloudclub-operator,owner=%s,resource=%s", o.ID, o.Resource)
+}
+
+// Desired returns the record pointing host at targets: an A record for
+// addresses, or a CNAME to the first hostname when there are none.
+func Desired(host string, targets []string, ttl uint32) Record {
+	r := Record{Name: Canonical(host), Type: TypeA, TTL: ttl}
+	for _, t := range targets {
+		if net.ParseIP(t) != nil {
+			r.Targets = append(r.Targets, t)
+		}
+	}
+	if len(r.Targets) == 0 && len(targets) > 0 {
+		r.Type, r.Targets = TypeCNAME, []string{Canonical(targets[0])}
+	}
+	sort.Strings(r.Targets)
+	return r
+}
+
+// Canonical lowercases name and strips its trailing dot.
+func Canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Sync publishes r, claiming its name for o first. It reports whether it
+// changed any record.
+func Sync(ctx context.Context, p Provider, o Owner, r Record) (bool, error) {
+	records, owned, err := lookup(ctx, p, o, r.Name)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	if !owned {
+		claim := Record{Name: ownerPrefix + r.Name, Type: TypeTXT, Targets: []string{o.txt()}, TTL: r.TTL}
+		if err := p.Replace(ctx, claim); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	current := false
+	for _, rec := range records {
+		switch {
+		case rec.Type == r.Type:
+			current = sameTargets(rec.Targets, r.Targets) && rec.TTL == r.TTL
+		case rec.Type == TypeA || rec.Type == TypeCNAME:
+			if err := p.Delete(ctx, r.Name, rec.Type); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+	}
+	if current {
+		return changed, nil
+	}
+	return true, p.Replace(ctx, r)
+}
+
+// Check returns nil when r is published, ErrNotOwned when its name belongs
+// to someone else, and an error describing the difference otherwise.
+func Check(ctx context.Context, p Provider, o Owner, r Record) error {
+	records, _, err := lookup(ctx, p, o, r.Name)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if rec.Type == r.Type && sameTargets(rec.Targets, r.Targets) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s is not published", r.Type, r.Name)
+}
+
+// Remove deletes the records of name and its ownership record when o owns
+// them. It reports whether it deleted any.
+func Remove(ctx context.Context, p Provider, o Owner, name string) (bool, error) {
+	name = Canonical(name)
+	records, owned, err := lookup(ctx, p, o, name)
+	if errors.Is(err, ErrNotOwned) || err == nil && !owned {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range records {
+		if rec.Type == TypeA || rec.Type == TypeCNAME {
+			if err := p.Delete(ctx, name, rec.Type); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, p.Delete(ctx, ownerPrefix+name, TypeTXT)
+}
+
+// lookup returns the records of name and whether o owns it. Names with
+// records but no ownership record of o are not owned by anyone who may
+// change them, which is reported as ErrNotOwned.
+func lookup(ctx context.Context, p Provider, o Owner, name string) ([]Record, bool, error) {
+	records, err := p.Records(ctx, name)
+	if err != nil {
+		return nil, false, err
+	}
+	claims, err := p.Records(ctx, ownerPrefix+name)
+	if err != nil {
+		return nil, false, err
+	}
+	claimed, owned := false, false
+	for _, c := range claims {
+		if c.Type != TypeTXT {
+			continue
+		}
+		claimed = true
+		for _, t := range c.Targets {
+			owned = owned || t == o.txt()
+		}
+	}
+	if owned {
+		return records, true, nil
+	}
+	if claimed {
+		return nil, false, fmt.Errorf("%s: %w", name, ErrNotOwned)
+	}
+	for _, rec := range records {
+		if rec.Type == TypeA || rec.Type == TypeCNAME {
+			return nil, false, fmt.Errorf("%s: %w", name, ErrNotOwned)
+		}
+	}
+	return records, false, nil
+}
+
+func sameTargets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+const tsigSecret = "c2VjcmV0LXNoYXJlZC13aXRoLXRoZS1zZXJ2ZXI="
+
+// serve answers queries from zone and applies to it the updates signed
+// with the operator key, like a primary server with dynamic updates.
+func serve(t *testing.T, zone *ZoneFile) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	server := &miekgdns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{"operator.": tsigSecret},
+		// The default accepts queries and notifies only.
+		MsgAcceptFunc: func(miekgdns.Header) miekgdns.MsgAcceptAction { return miekgdns.MsgAccept },
+	}
+	server.Handler = miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, req *miekgdns.Msg) {
+		m := new(miekgdns.Msg)
+		m.SetReply(req)
+		if req.Opcode == miekgdns.OpcodeUpdate {
+			if req.IsTsig() == nil || w.TsigStatus() != nil {
+				m.Rcode = miekgdns.RcodeRefused
+				_ = w.WriteMsg(m)
+				return
+			}
+			var inserted []miekgdns.RR
+			for _, rr := range req.Ns {
+				h := rr.Header()
+				if h.Class == miekgdns.ClassANY {
+					_ = zone.Delete(ctx, h.Name, miekgdns.TypeToString[h.Rrtype])
+				} else {
+					inserted = append(inserted, rr)
+				}
+			}
+			if len(inserted) > 0 {
+				for _, r := range toRecords(inserted, inserted[0].Header().Name) {
+					_ = zone.Replace(ctx, r)
+				}
+			}
+		} else {
+			q := req.Question[0]
+			records, _ := zone.Records(ctx, q.Name)
+			for _, r := range records {
+				if r.Type == miekgdns.TypeToString[q.Qtype] {
+					m.Answer, _ = toRRs(r)
+				}
+			}
+		}
+		_ = w.WriteMsg(m)
+	})
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestSyncClaimsAndReleasesNames(t *testing.T) {
+	ctx := context.Background()
+	zone := &ZoneFile{Path: filepath.Join(t.TempDir(), "apps.example.com.zone"), Origin: "apps.example.com"}
+	providers := map[string]Provider{
+		"file": zone,
+		"rfc2136": &RFC2136{Server: serve(t, &ZoneFile{Path: filepath.Join(t.TempDir(), "zone"), Origin: "apps.example.com"}),
+			Zone: "apps.example.com", TSIGKey: "operator", TSIGSecret: tsigSecret, Timeout: 5 * time.Second},
+	}
+	web := Owner{ID: "cluster-a", Resource: "application/default/web"}
+	api := Owner{ID: "cluster-a", Resource: "application/default/api"}
+
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			address := Desired("web.apps.example.com", []string{"10.0.0.1"}, 300)
+			if changed, err := Sync(ctx, p, web, address); err != nil || !changed {
+				t.Fatalf("Sync = %v, %v", changed, err)
+			}
+			if changed, err := Sync(ctx, p, web, address); err != nil || changed {
+				t.Errorf("Sync of a published record = %v, %v, want no change", changed, err)
+			}
+			if err := Check(ctx, p, web, address); err != nil {
+				t.Errorf("Check = %v", err)
+			}
+
+			// Moving to a load balancer hostname replaces the A record.
+			alias := Desired("web.apps.example.com", []string{"lb.example.net"}, 300)
+			if _, err := Sync(ctx, p, web, alias); err != nil {
+				t.Fatal(err)
+			}
+			records, _ := p.Records(ctx, "web.apps.example.com")
+			if len(records) != 1 || records[0].Type != TypeCNAME || records[0].Targets[0] != "lb.example.net" {
+				t.Errorf("records = %+v, want only the CNAME", records)
+			}
+
+			// Another Application cannot take the name over.
+			if _, err := Sync(ctx, p, api, address); !errors.Is(err, ErrNotOwned) {
+				t.Errorf("Sync by another owner = %v, want ErrNotOwned", err)
+			}
+			if removed, err := Remove(ctx, p, api, "web.apps.example.com"); err != nil || removed {
+				t.Errorf("Remove by another owner = %v, %v", removed, err)
+			}
+
+			if removed, err := Remove(ctx, p, web, "web.apps.example.com"); err != nil || !removed {
+				t.Fatalf("Remove = %v, %v", removed, err)
+			}
+			for _, name := range []string{"web.apps.example.com", ownerPrefix + "web.apps.example.com"} {
+				if records, _ := p.Records(ctx, name); len(records) != 0 {
+					t.Errorf("records of %s after Remove = %+v", name, records)
+				}
+			}
+		})
+	}
+
+	// Records created by hand are never replaced.
+	manual := Record{Name: "www.apps.example.com", Type: TypeA, Targets: []string{"192.0.2.1"}, TTL: 60}
+	if err := zone.Replace(ctx, manual); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, zone, web, Desired("www.apps.example.com", []string{"10.0.0.1"}, 300)); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("Sync over a manual record = %v, want ErrNotOwned", err)
+	}
+}
+
+func TestRFC2136RejectsUnsignedUpdates(t *testing.T) {
+	p := &RFC2136{Server: serve(t, &ZoneFile{Path: filepath.Join(t.TempDir(), "zone"), Origin: "apps.example.com"}), Zone: "apps.example.com"}
+	if err := p.Replace(context.Background(), Desired("web.apps.example.com", []string{"10.0.0.1"}, 300)); err == nil {
+		t.Error("unsigned update was accepted")
+	}
+}
@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+// RFC2136 reads records from an authoritative server and changes them with
+// dynamic updates, signed with TSIG when a key is given.
+type RFC2136 struct {
+	// Server is the host:port of the primary server of Zone.
+	Server string
+	Zone   string
+	// TSIGKey names the key updates are signed with, and TSIGSecret is its
+	// base64 encoded secret.
+	TSIGKey    string
+	TSIGSecret string
+	// TSIGAlgorithm defaults to hmac-sha256.
+	TSIGAlgorithm string
+	// Timeout bounds each exchange with the server; 10s when zero.
+	Timeout time.Duration
+}
+
+var _ Provider = &RFC2136{}
+
+var recordTypes = []uint16{miekgdns.TypeA, miekgdns.TypeCNAME, miekgdns.TypeTXT}
+
+func (p *RFC2136) Records(ctx context.Context, name string) ([]Record, error) {
+	var rrs []miekgdns.RR
+	for _, t := range recordTypes {
+		m := new(miekgdns.Msg)
+		m.SetQuestion(miekgdns.Fqdn(name), t)
+		m.RecursionDesired = false
+		r, err := p.exchange(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if r.Rcode != miekgdns.RcodeSuccess && r.Rcode != miekgdns.RcodeNameError {
+			return nil, fmt.Errorf("querying %s %s: %s", miekgdns.TypeToString[t], name, miekgdns.RcodeToString[r.Rcode])
+		}
+		for _, rr := range r.Answer {
+			// A CNAME answers queries of any type; it is kept from its own.
+			if rr.Header().Rrtype == t {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return toRecords(rrs, name), nil
+}
+
+func (p *RFC2136) Replace(ctx context.Context, r Record) error {
+	rrs, err := toRRs(r)
+	if err != nil {
+		return err
+	}
+	m := p.update(r.Name, r.Type)
+	m.Insert(rrs)
+	return p.send(ctx, m, r.Name)
+}
+
+func (p *RFC2136) Delete(ctx context.Context, name, recordType string) error {
+	return p.send(ctx, p.update(name, recordType), name)
+}
+
+// update returns an update of the zone removing the record set of name and
+// recordType.
+func (p *RFC2136) update(name, recordType string) *miekgdns.Msg {
+	m := new(miekgdns.Msg)
+	m.SetUpdate(miekgdns.Fqdn(p.Zone))
+	m.RemoveRRset([]miekgdns.RR{&miekgdns.ANY{Hdr: miekgdns.RR_Header{
+		Name:   miekgdns.Fqdn(name),
+		Rrtype: miekgdns.StringToType[recordType],
+	}}})
+	return m
+}
+
+func (p *RFC2136) send(ctx context.Context, m *miekgdns.Msg, name string) error {
+	r, err := p.exchange(ctx, m)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != miekgdns.RcodeSuccess {
+		return fmt.Errorf("updating %s: %s", name, miekgdns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+func (p *RFC2136) exchange(ctx context.Context, m *miekgdns.Msg) (*miekgdns.Msg, error) {
+	c := &miekgdns.Client{Timeout: p.Timeout}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if p.TSIGKey != "" && m.Opcode == miekgdns.OpcodeUpdate {
+		key, algorithm := miekgdns.Fqdn(p.TSIGKey), p.TSIGAlgorithm
+		if algorithm == "" {
+			algorithm = miekgdns.HmacSHA256
+		}
+		c.TsigSecret = map[string]string{key: p.TSIGSecret}
+		m.SetTsig(key, miekgdns.Fqdn(algorithm), 300, time.Now().Unix())
+	}
+	r, _, err := c.ExchangeContext(ctx, m, p.Server)
+	if err != nil {
+		return nil, fmt.Errorf("dns server %s: %w", p.Server, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,184 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+// ZoneFile keeps the records in a zone file in RFC 1035 master format. It
+// is meant for local testing, and for DNS servers that load zone files.
+// ZoneFiles of the same path share a lock.
+type ZoneFile struct {
+	// Path is the zone file, created on the first write.
+	Path string
+	// Origin is the zone, e.g. apps.example.com.
+	Origin string
+}
+
+// zoneLocks holds a *sync.Mutex per zone file path.
+var zoneLocks sync.Map
+
+func (z *ZoneFile) lock() func() {
+	mu, _ := zoneLocks.LoadOrStore(z.Path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+var _ Provider = &ZoneFile{}
+
+func (z *ZoneFile) Records(ctx context.Context, name string) ([]Record, error) {
+	defer z.lock()()
+	rrs, err := z.load()
+	if err != nil {
+		return nil, err
+	}
+	return toRecords(rrs, name), nil
+}
+
+func (z *ZoneFile) Replace(ctx context.Context, r Record) error {
+	rrs, err := toRRs(r)
+	if err != nil {
+		return err
+	}
+	return z.update(func(current []miekgdns.RR) []miekgdns.RR {
+		return append(without(current, r.Name, r.Type), rrs...)
+	})
+}
+
+func (z *ZoneFile) Delete(ctx context.Context, name, recordType string) error {
+	return z.update(func(current []miekgdns.RR) []miekgdns.RR {
+		return without(current, name, recordType)
+	})
+}
+
+func (z *ZoneFile) update(change func([]miekgdns.RR) []miekgdns.RR) error {
+	defer z.lock()()
+	rrs, err := z.load()
+	if err != nil {
+		return err
+	}
+	return z.save(change(rrs))
+}
+
+func (z *ZoneFile) load() ([]miekgdns.RR, error) {
+	f, err := os.Open(z.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rrs []miekgdns.RR
+	parser := miekgdns.NewZoneParser(f, miekgdns.Fqdn(z.Origin), z.Path)
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("parsing zone file %s: %w", z.Path, err)
+	}
+	return rrs, nil
+}
+
+// save writes rrs sorted by name, replacing the file at once so that
+// servers reloading it never read a partial zone.
+func (z *ZoneFile) save(rrs []miekgdns.RR) error {
+	lines := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	content := fmt.Sprintf("$ORIGIN %s\n%s\n", miekgdns.Fqdn(z.Origin), strings.Join(lines, "\n"))
+	tmp, err := os.CreateTemp(filepath.Dir(z.Path), filepath.Base(z.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), z.Path)
+}
+
+// without returns rrs less the record set of name and recordType.
+func without(rrs []miekgdns.RR, name, recordType string) []miekgdns.RR {
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		h := rr.Header()
+		if Canonical(h.Name) != Canonical(name) || miekgdns.TypeToString[h.Rrtype] != recordType {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+// toRecords groups the A, CNAME and TXT resource records of name into
+// record sets.
+func toRecords(rrs []miekgdns.RR, name string) []Record {
+	byType := map[string]*Record{}
+	var types []string
+	for _, rr := range rrs {
+		h := rr.Header()
+		if Canonical(h.Name) != Canonical(name) {
+			continue
+		}
+		var target string
+		switch rr := rr.(type) {
+		case *miekgdns.A:
+			target = rr.A.String()
+		case *miekgdns.CNAME:
+			target = Canonical(rr.Target)
+		case *miekgdns.TXT:
+			target = strings.Join(rr.Txt, "")
+		default:
+			continue
+		}
+		recordType := miekgdns.TypeToString[h.Rrtype]
+		r, ok := byType[recordType]
+		if !ok {
+			r = &Record{Name: Canonical(name), Type: recordType, TTL: h.Ttl}
+			byType[recordType] = r
+			types = append(types, recordType)
+		}
+		r.Targets = append(r.Targets, target)
+	}
+	sort.Strings(types)
+	records := make([]Record, 0, len(types))
+	for _, t := range types {
+		records = append(records, *byType[t])
+	}
+	return records
+}
+
+// toRRs returns the resource records of r.
+func toRRs(r Record) ([]miekgdns.RR, error) {
+	rrs := make([]miekgdns.RR, 0, len(r.Targets))
+	for _, t := range r.Targets {
+		value := t
+		switch r.Type {
+		case TypeCNAME:
+			value = miekgdns.Fqdn(t)
+		case TypeTXT:
+			value = fmt.Sprintf("%q", t)
+		}
+		rr, err := miekgdns.NewRR(fmt.Sprintf("%s %d IN %s %s", miekgdns.Fqdn(r.Name), r.TTL, r.Type, value))
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
@@ -2,16 +2,26 @@ package driver
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
 	"github.com/cloud-club/cloudclub-operator/internal/log"
-	v1 "k8s.io/api/apps/v1"
+	"github.com/cloud-club/cloudclub-operator/internal/metrics"
+	"github.com/cloud-club/cloudclub-operator/internal/project"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+	"github.com/cloud-club/cloudclub-operator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -19,189 +29,422 @@ import (
 type ApplicationClient struct {
 	Kubernetes client.Client
 	Schema     *runtime.Scheme
+	Config     *config.Store
+	// Drivers are reconciled in order for every Application.
+	Drivers []ResourceDriver
+	// Shard is recorded in the status of reconciled Applications.
+	Shard string
+	// Projects enables enforcement of the policy of the Project owning the
+	// namespace of each Application. It needs read access to Projects.
+	Projects bool
+	// Events records the transitions of some conditions, like failed hooks,
+	// as events on the Application when set.
+	Events record.EventRecorder
+	// Reader reads from the API server the objects the cache may not hold,
+	// like Namespaces when the operator watches a set of namespaces, or the
+	// Secrets named by the operator config. Kubernetes is used when nil.
+	Reader client.Reader
+	// Registry resolves pinned image tags to digests. The OCI distribution
+	// API is spoken to the registry of each image when it is nil.
+	Registry registry.Client
+	// now is the clock of image pinning; time.Now when nil.
+	now func() time.Time
 }
 
-func NewApplicationClient(kube client.Client, schema *runtime.Scheme) (*ApplicationClient, error) {
+func NewApplicationClient(kube client.Client, schema *runtime.Scheme, cfg *config.Store) (*ApplicationClient, error) {
 	return &ApplicationClient{
 		Kubernetes: kube,
 		Schema:     schema,
+		Config:     cfg,
 	}, nil
 }
 
-func (a *ApplicationClient) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log.Info("start application reconcile")
+// drivers returns the drivers not toggled off in the operator config.
+func (a *ApplicationClient) drivers() []ResourceDriver {
+	cfg := a.Config.Get()
+	drivers := make([]ResourceDriver, 0, len(a.Drivers))
+	for _, d := range a.Drivers {
+		if cfg.DriverEnabled(d.Name()) {
+			drivers = append(drivers, d)
+		}
+	}
+	return drivers
+}
+
+func (a *ApplicationClient) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "ApplicationClient.Reconcile",
+		attribute.String("k8s.namespace", req.Namespace),
+		attribute.String("k8s.name", req.Name),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	log.Debug(ctx, "start application reconcile")
 	app := &appv1alpha1.Application{}
-	err := a.Kubernetes.Get(ctx, req.NamespacedName, app)
+	err = a.Kubernetes.Get(ctx, req.NamespacedName, app)
 	if err != nil {
-		log.Errorf(err)
 		if errors.IsNotFound(err) {
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
+		log.Errorf(ctx, err)
 		return ctrl.Result{}, err
 	}
+	ctx = log.WithObject(ctx, app)
+	ctx, writes := WithWriteCounter(ctx)
+	span.SetAttributes(attribute.Int64("k8s.generation", app.Generation))
+	if !app.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, a.finalize(ctx, app)
+	}
+
+	var proj *appv1alpha1.Project
+	if a.Projects {
+		if proj, err = project.ForNamespace(ctx, a.Kubernetes, app.Namespace); err != nil {
+			log.Errorf(ctx, err)
+			return ctrl.Result{}, err
+		}
+		ctx = project.IntoContext(ctx, proj)
+	}
+	policy := a.projectPolicy(app, proj)
+	if policy != nil && policy.Status != metav1.ConditionTrue {
+		log.Warn(ctx, "application violates project policy", zap.String("reason", policy.Reason), zap.String("message", policy.Message))
+		exposing, err := a.unexpose(ctx, app)
+		if err != nil {
+			log.Errorf(ctx, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, a.updateStatus(ctx, app, exposing, nil, gates{projectPolicyCondition: policy})
+	}
 
-	if err := a.UpsertDeployment(ctx, req, app); err != nil {
-		log.Errorf(err)
+	now := a.clock()
+	ctx = withReconcileTime(ctx, now)
+	update, err := a.updateImage(ctx, app, now)
+	if err != nil {
+		log.Errorf(ctx, err)
 		return ctrl.Result{}, err
 	}
+	pinned, err := a.pinImage(ctx, app, now)
+	if err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+	checks := gates{projectPolicyCondition: policy, imageUpdateCondition: update, imagePinnedCondition: pinned}
+	if pinned != nil && pinned.Status != metav1.ConditionTrue {
+		log.Warn(ctx, "application image cannot be pinned", zap.String("message", pinned.Message))
+		return ctrl.Result{Requeue: true}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
+	signed, err := a.verifySignature(ctx, app, now)
+	if err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+	checks[signatureCondition] = signed
+	if signed != nil && signed.Status == metav1.ConditionTrue {
+		log.Warn(ctx, "application image signature is not verified", zap.String("message", signed.Message))
+		return ctrl.Result{Requeue: true}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
 
-	if err := a.UpsertService(ctx, req, app); err != nil {
-		log.Errorf(err)
+	invalid := a.checkSpec(ctx, app)
+	checks[invalidSpecCondition] = invalid
+	if invalid != nil {
+		log.Warn(ctx, "application spec is invalid", zap.String("message", invalid.Message))
+		return ctrl.Result{}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
+
+	drivers := a.drivers()
+	violation, err := a.checkImagePolicy(ctx, app, drivers)
+	if err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+	checks[policyViolationCondition] = violation
+	if violation.Status == metav1.ConditionTrue {
+		log.Warn(ctx, "application violates the image policy", zap.String("message", violation.Message))
+		return ctrl.Result{}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
+	exceeded, requested, err := a.checkQuota(ctx, app, drivers)
+	if err != nil {
+		log.Errorf(ctx, err)
 		return ctrl.Result{}, err
 	}
-	log.Info("finish application reconcile")
-	return ctrl.Result{}, nil
+	checks[quotaExceededCondition] = exceeded
+	if exceeded != nil && exceeded.Status == metav1.ConditionTrue {
+		log.Warn(ctx, "application exceeds namespace quota", zap.String("message", exceeded.Message))
+		return ctrl.Result{}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
+	security, err := a.checkPodSecurity(ctx, app, drivers)
+	if err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+	checks[podSecurityCondition] = security
+	if security != nil && security.Status != metav1.ConditionTrue {
+		log.Warn(ctx, "application violates the pod security level of its namespace", zap.String("message", security.Message))
+		return ctrl.Result{}, a.updateStatus(ctx, app, nil, nil, checks)
+	}
+
+	wanted := make(map[string]bool, len(drivers))
+	for _, d := range drivers {
+		if wanted[d.Name()], err = a.reconcileDriver(ctx, app, d); err != nil {
+			log.Errorf(ctx, err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := a.updateStatus(ctx, app, drivers, wanted, checks, func(status *appv1alpha1.ApplicationStatus) {
+		status.QuotaRequested = requested
+	}); err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+	// Polls, re-resolutions and renewals due before the resync are
+	// requeued for.
+	cfg := a.Config.Get()
+	result := ctrl.Result{RequeueAfter: cfg.Reconcile.ResyncPeriod.Duration}
+	for _, next := range []time.Duration{nextPoll(app, now), nextResolve(app, now), nextRenewal(app, now, cfg.Ingress.TLS.RenewBefore.Duration)} {
+		if next > 0 && (result.RequeueAfter <= 0 || next < result.RequeueAfter) {
+			result.RequeueAfter = next
+		}
+	}
+	if *writes == 0 {
+		metrics.ApplicationReconciles.WithLabelValues(metrics.ResultSkipped).Inc()
+		log.Debug(ctx, "application is up to date")
+		return result, nil
+	}
+	metrics.ApplicationReconciles.WithLabelValues(metrics.ResultExecuted).Inc()
+	log.Info(ctx, "finish application reconcile", zap.Int64("writes", *writes))
+	return result, nil
 }
 
-func (a *ApplicationClient) UpsertDeployment(ctx context.Context, req ctrl.Request, app *appv1alpha1.Application) error {
-	deployment := &v1.Deployment{}
-	err := a.Kubernetes.Get(ctx, req.NamespacedName, deployment)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			log.Info("creating new deployment")
-			newDeployment := a.createNewDeployment(req, app)
-			_ = ctrl.SetControllerReference(app, newDeployment, a.Schema)
-			if app.Spec.Scheduler.Affinity != nil {
-				newDeployment.Spec.Template.Spec.Affinity = app.Spec.Scheduler.Affinity
+// finalize lets the drivers holding a finalizer on app clean up before it
+// is deleted. Disabled drivers are included, as they may hold one from
+// before they were disabled.
+func (a *ApplicationClient) finalize(ctx context.Context, app *appv1alpha1.Application) error {
+	for _, d := range a.Drivers {
+		if f, ok := d.(Finalizer); ok {
+			if err := f.Finalize(ctx, app); err != nil {
+				log.Errorf(ctx, err)
+				return err
 			}
-			log.Info("finishing new deployment")
-			return a.Kubernetes.Create(ctx, newDeployment)
 		}
-		return err
 	}
+	return nil
+}
 
-	if *app.Spec.App.Replicas != *deployment.Spec.Replicas {
-		deployment.Spec.Replicas = app.Spec.App.Replicas
-		log.Info("updating deployment")
-		return a.Kubernetes.Update(ctx, deployment)
+// getUncached reads the object at key into obj from the API server.
+func (a *ApplicationClient) getUncached(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return getUncached(ctx, a.Kubernetes, a.Reader, key, obj)
+}
+
+func (a *ApplicationClient) clock() time.Time {
+	if a.now != nil {
+		return a.now()
 	}
+	return time.Now()
+}
 
-	return nil
+type reconcileTimeKey struct{}
+
+// withReconcileTime returns a copy of ctx carrying the time of the
+// reconcile, which the drivers judge expiry by.
+func withReconcileTime(ctx context.Context, now time.Time) context.Context {
+	return context.WithValue(ctx, reconcileTimeKey{}, now)
 }
 
-func (a *ApplicationClient) UpsertService(ctx context.Context, req ctrl.Request, app *appv1alpha1.Application) error {
-	service := &corev1.Service{}
-	err := a.Kubernetes.Get(ctx, req.NamespacedName, service)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			newService := a.createNewService(app)
-			_ = ctrl.SetControllerReference(app, newService, a.Schema)
-			return a.Kubernetes.Create(ctx, newService)
+// reconcileTime returns the time of the reconcile in ctx, or the current
+// time outside of one.
+func reconcileTime(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(reconcileTimeKey{}).(time.Time); ok {
+		return now
+	}
+	return time.Now()
+}
+
+// Validate runs the policy checks that hold back the reconcile of app and
+// returns their violations as an error. It backs the validating webhook,
+// which rejects new violations before they reach the reconcile.
+func (a *ApplicationClient) Validate(ctx context.Context, app *appv1alpha1.Application) error {
+	var checks []*metav1.Condition
+	if a.Projects {
+		proj, err := project.ForNamespace(ctx, a.Kubernetes, app.Namespace)
+		if err != nil {
+			return err
+		}
+		if policy := a.projectPolicy(app, proj); policy != nil && policy.Status != metav1.ConditionTrue {
+			checks = append(checks, policy)
 		}
+		ctx = project.IntoContext(ctx, proj)
 	}
-	appServicePort := intstr.IntOrString{IntVal: app.Spec.App.ContainerPort}
-	if service.Spec.Ports[0].TargetPort != appServicePort {
-		service.Spec.Ports[0].Port = app.Spec.App.ContainerPort
-		service.Spec.Ports[0].TargetPort = appServicePort
-		return a.Kubernetes.Update(ctx, service)
+	if invalid := a.checkSpec(ctx, app); invalid != nil {
+		return fmt.Errorf("application spec is invalid: %s", invalid.Message)
 	}
-	return nil
+	drivers := a.drivers()
+	violation, err := a.checkImagePolicy(ctx, app, drivers)
+	if err != nil {
+		return err
+	}
+	if violation.Status == metav1.ConditionTrue {
+		checks = append(checks, violation)
+	}
+	security, err := a.checkPodSecurity(ctx, app, drivers)
+	if err != nil {
+		return err
+	}
+	if security != nil && security.Status != metav1.ConditionTrue {
+		checks = append(checks, security)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(checks))
+	for _, c := range checks {
+		messages = append(messages, fmt.Sprintf("%s: %s", c.Type, c.Message))
+	}
+	return fmt.Errorf("application violates policy: %s", strings.Join(messages, "; "))
 }
 
-func (a *ApplicationClient) UpsertIngress(ctx context.Context, req ctrl.Request, app *appv1alpha1.Application) error {
-	ingress := &networkingv1.Ingress{}
-	err := a.Kubernetes.Get(ctx, req.NamespacedName, ingress)
+// reconcileDriver renders the object d manages for app and applies it, or
+// deletes it when app no longer wants one. It reports whether app wants it.
+func (a *ApplicationClient) reconcileDriver(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "ResourceDriver.Apply", attribute.String("driver", d.Name()))
+	defer func() { tracing.End(span, err) }()
+
+	if r, ok := d.(Reconciler); ok {
+		return r.Reconcile(ctx, app)
+	}
+	desired, err := d.Render(ctx, app)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			newIngress := a.createNewIngress(app)
-			_ = ctrl.SetControllerReference(app, newIngress, a.Schema)
-			return a.Kubernetes.Create(ctx, newIngress)
+		return false, err
+	}
+	if desired == nil {
+		return false, d.Delete(ctx, app)
+	}
+	return true, d.Apply(ctx, app, desired)
+}
+
+// projectPolicy checks app against the policy of proj. It returns nil when
+// app belongs to no Project.
+func (a *ApplicationClient) projectPolicy(app *appv1alpha1.Application, proj *appv1alpha1.Project) *metav1.Condition {
+	if proj == nil {
+		return nil
+	}
+	policy := condition(projectPolicyCondition, true, "Allowed", "application conforms to project "+proj.Name)
+	if app.Spec.Ingress.Enabled {
+		host := ingressHost(app, a.Config.Get().Ingress.Domain)
+		if !project.AllowsHost(proj, host) {
+			policy = condition(projectPolicyCondition, false, "HostNotAllowed",
+				fmt.Sprintf("ingress host %q is outside the allowed domains of project %s", host, proj.Name))
 		}
 	}
+	return &policy
+}
+
+const (
+	projectPolicyCondition = "ProjectPolicy"
+	invalidSpecCondition   = "InvalidSpec"
+)
+
+// checkSpec renders the pod template of app, which fails on mistakes no
+// reconcile can fix, like two containers of the same name. It returns nil
+// when the template renders.
+func (a *ApplicationClient) checkSpec(ctx context.Context, app *appv1alpha1.Application) *metav1.Condition {
+	b := base{Kubernetes: a.Kubernetes, Schema: a.Schema, Config: a.Config}
+	if _, err := b.podTemplate(ctx, app); err != nil {
+		invalid := condition(invalidSpecCondition, true, "InvalidPodTemplate", err.Error())
+		return &invalid
+	}
 	return nil
 }
 
-func (a *ApplicationClient) createNewService(app *appv1alpha1.Application) *corev1.Service {
-	newService := &corev1.Service{
-		Spec: corev1.ServiceSpec{
-			Type: "ClusterIP",
-			Ports: []corev1.ServicePort{
-				{
-					Name: "http",
-					Port: app.Spec.App.ContainerPort,
-					TargetPort: intstr.IntOrString{
-						IntVal: app.Spec.App.ContainerPort,
-					},
-				},
-			},
-			Selector: map[string]string{
-				"app": app.Name,
-			},
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      app.Name,
-			Namespace: app.Namespace,
-		},
-	}
-	return newService
-}
-
-func (a *ApplicationClient) createNewDeployment(req ctrl.Request, app *appv1alpha1.Application) *v1.Deployment {
-	return &v1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      req.Name,
-			Namespace: req.Namespace,
-		},
-		Spec: v1.DeploymentSpec{
-			Replicas: app.Spec.App.Replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": req.Name,
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": req.Name,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  req.Name,
-							Image: app.Spec.App.Image,
-						},
-					},
-				},
-			},
-		},
-	}
-}
-
-func (a *ApplicationClient) createNewIngress(app *appv1alpha1.Application) *networkingv1.Ingress {
-	pathType := networkingv1.PathTypePrefix
-	return &networkingv1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      app.Name,
-			Namespace: app.Namespace,
-			Labels: map[string]string{
-				"app": app.Name,
-			},
-		},
-		Spec: networkingv1.IngressSpec{
-			Rules: []networkingv1.IngressRule{
-				{
-					Host: app.Spec.App.IngressHost,
-					IngressRuleValue: networkingv1.IngressRuleValue{
-						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     "/",
-									PathType: &pathType,
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: app.Name,
-											Port: networkingv1.ServiceBackendPort{
-												Number: app.Spec.App.ContainerPort,
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+// exposingDrivers serve the ingress host of an Application.
+var exposingDrivers = map[string]bool{
+	"ingress":         true,
+	"httproute":       true,
+	certificateDriver: true,
+	dnsDriver:         true,
+}
+
+// unexpose deletes the objects serving the ingress host of app, which its
+// Project no longer allows, and returns their drivers. The other children
+// of app are left as they are until the violation is fixed.
+func (a *ApplicationClient) unexpose(ctx context.Context, app *appv1alpha1.Application) ([]ResourceDriver, error) {
+	var exposing []ResourceDriver
+	for _, d := range a.drivers() {
+		if !exposingDrivers[d.Name()] {
+			continue
+		}
+		if err := d.Delete(ctx, app); err != nil {
+			return nil, err
+		}
+		exposing = append(exposing, d)
+	}
+	return exposing, nil
+}
+
+// gates holds the conditions checked before any driver runs by type. A nil
+// condition is removed from the status.
+type gates map[string]*metav1.Condition
+
+// updateStatus records the conditions reported by drivers and gates, and
+// lets record fill in the other fields of the status.
+func (a *ApplicationClient) updateStatus(ctx context.Context, app *appv1alpha1.Application, drivers []ResourceDriver, wanted map[string]bool, gates gates, record ...func(*appv1alpha1.ApplicationStatus)) error {
+	status := app.Status.DeepCopy()
+	status.ObservedGeneration = app.Generation
+	status.Shard = a.Shard
+	for _, fn := range record {
+		fn(status)
+	}
+	for conditionType, c := range gates {
+		if c == nil {
+			meta.RemoveStatusCondition(&status.Conditions, conditionType)
+			continue
+		}
+		c.ObservedGeneration = app.Generation
+		meta.SetStatusCondition(&status.Conditions, *c)
+	}
+	for _, d := range drivers {
+		condition, err := d.Status(ctx, app)
+		if err != nil {
+			return err
+		}
+		if r, ok := d.(StatusRecorder); ok {
+			if err := r.RecordStatus(ctx, app, wanted[d.Name()], status); err != nil {
+				return err
+			}
+		}
+		if !wanted[d.Name()] {
+			meta.RemoveStatusCondition(&status.Conditions, condition.Type)
+			continue
+		}
+		condition.ObservedGeneration = app.Generation
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+	if equality.Semantic.DeepEqual(status, &app.Status) {
+		return nil
+	}
+	a.recordEvents(app, app.Status.Conditions, status.Conditions)
+	app.Status = *status
+	recordWrite(ctx)
+	return a.Kubernetes.Status().Update(ctx, app)
+}
+
+// eventConditions are the condition types whose changes of reason are also
+// recorded as events.
+var eventConditions = map[string]bool{hooksCondition: true}
+
+func (a *ApplicationClient) recordEvents(app *appv1alpha1.Application, old, new []metav1.Condition) {
+	if a.Events == nil {
+		return
+	}
+	for _, c := range new {
+		if !eventConditions[c.Type] {
+			continue
+		}
+		if previous := meta.FindStatusCondition(old, c.Type); previous != nil && previous.Reason == c.Reason {
+			continue
+		}
+		eventType := corev1.EventTypeNormal
+		if strings.HasSuffix(c.Reason, "Failed") {
+			eventType = corev1.EventTypeWarning
+		}
+		a.Events.Event(app, eventType, c.Reason, c.Message)
 	}
 }
@@ -0,0 +1,352 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/certs"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	certificateDriver    = "certificate"
+	certificateCondition = "CertificateReady"
+
+	// IssuerCertManager requests certificates from cert-manager.
+	IssuerCertManager = "cert-manager"
+	// IssuerLocal signs certificates with the CA of the operator config, or
+	// self-signs them without one.
+	IssuerLocal = "local"
+)
+
+var certManagerCertificate = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// CertificateIssuer issues the certificate of the ingress of an Application
+// into a kubernetes.io/tls Secret.
+type CertificateIssuer interface {
+	// Issue makes secretName hold a valid certificate for hosts, renewing it
+	// when it is due.
+	Issue(ctx context.Context, app *appv1alpha1.Application, secretName string, hosts []string) error
+	// Release removes what Issue created for app.
+	Release(ctx context.Context, app *appv1alpha1.Application) error
+}
+
+// CertificateDriver issues the certificates of ingresses that serve TLS
+// without a Secret of their own, through the issuer chosen by the
+// Application or the operator config.
+type CertificateDriver struct {
+	base
+	// Issuers are the issuers Applications may choose by name.
+	Issuers map[string]CertificateIssuer
+}
+
+func (d *CertificateDriver) Name() string {
+	return certificateDriver
+}
+
+func (d *CertificateDriver) Object() client.Object {
+	return &corev1.Secret{}
+}
+
+// Render returns nil: the issuers write their objects from Reconcile.
+func (d *CertificateDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	return nil, nil
+}
+
+func (d *CertificateDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	tls := ingressTLS(app)
+	issuer := d.issuer(app)
+	for name, i := range d.Issuers {
+		if name == issuer {
+			continue
+		}
+		if err := i.Release(ctx, app); err != nil {
+			return tls != nil, err
+		}
+	}
+	if tls == nil {
+		return false, nil
+	}
+	if issuer == "" {
+		return true, nil
+	}
+	i, ok := d.Issuers[issuer]
+	if !ok {
+		return true, fmt.Errorf("unknown certificate issuer %q", issuer)
+	}
+	host := ingressHost(app, d.Config.Get().Ingress.Domain)
+	if host == "" {
+		return true, fmt.Errorf("ingress has no host to issue a certificate for")
+	}
+	return true, i.Issue(ctx, app, tlsSecretName(app), []string{host})
+}
+
+// issuer returns the name of the issuer of the certificate of app, or ""
+// when the operator issues none for it.
+func (d *CertificateDriver) issuer(app *appv1alpha1.Application) string {
+	tls := ingressTLS(app)
+	if tls == nil || tls.SecretName != "" {
+		return ""
+	}
+	if tls.Issuer != "" {
+		return tls.Issuer
+	}
+	return d.Config.Get().Ingress.TLS.Issuer
+}
+
+func (d *CertificateDriver) Compare(desired, current client.Object) bool {
+	return true
+}
+
+func (d *CertificateDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	_, err := d.Reconcile(ctx, app)
+	return err
+}
+
+func (d *CertificateDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	for _, i := range d.Issuers {
+		if err := i.Release(ctx, app); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *CertificateDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	if ingressTLS(app) == nil {
+		return condition(certificateCondition, false, "NotRequested", "ingress serves no TLS"), nil
+	}
+	name := tlsSecretName(app)
+	secret := &corev1.Secret{}
+	err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, secret)
+	if errors.IsNotFound(err) {
+		return condition(certificateCondition, false, "Pending", fmt.Sprintf("secret %s does not exist yet", name)), nil
+	}
+	if err != nil {
+		return metav1.Condition{}, err
+	}
+	cert, err := certs.Parse(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return condition(certificateCondition, false, "Invalid", fmt.Sprintf("secret %s: %v", name, err)), nil
+	}
+	if !time.Now().Before(cert.NotAfter) {
+		return condition(certificateCondition, false, "Expired", fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))), nil
+	}
+	return condition(certificateCondition, true, "Valid", fmt.Sprintf("certificate expires at %s", cert.NotAfter.UTC().Format(time.RFC3339))), nil
+}
+
+// RecordStatus reports the served certificate and its expiry.
+func (d *CertificateDriver) RecordStatus(ctx context.Context, app *appv1alpha1.Application, wanted bool, status *appv1alpha1.ApplicationStatus) error {
+	status.Certificate = nil
+	if !wanted {
+		return nil
+	}
+	name := tlsSecretName(app)
+	secret := &corev1.Secret{}
+	if err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	cert, err := certs.Parse(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		// Reported by the condition.
+		return nil
+	}
+	status.Certificate = &appv1alpha1.CertificateStatus{
+		SecretName: name,
+		Issuer:     d.issuer(app),
+		DNSNames:   cert.DNSNames,
+		NotAfter:   metav1.NewTime(cert.NotAfter),
+	}
+	return nil
+}
+
+// ingressTLS returns the TLS settings of the ingress of app, or nil when it
+// serves no TLS.
+func ingressTLS(app *appv1alpha1.Application) *appv1alpha1.IngressTLSSpec {
+	if !app.Spec.Ingress.Enabled {
+		return nil
+	}
+	return app.Spec.Ingress.TLS
+}
+
+// tlsSecretName returns the Secret the ingress of app serves its
+// certificate from.
+func tlsSecretName(app *appv1alpha1.Application) string {
+	if tls := ingressTLS(app); tls != nil && tls.SecretName != "" {
+		return tls.SecretName
+	}
+	return app.Name + "-tls"
+}
+
+// IsCertificateAuthority reports whether secret holds the CA of the local
+// issuer of cfg.
+func IsCertificateAuthority(cfg *config.OperatorConfig, secret client.Object) bool {
+	ref := cfg.Ingress.TLS.CASecret
+	return ref != nil && ref.Namespace == secret.GetNamespace() && ref.Name == secret.GetName()
+}
+
+// nextRenewal returns how long until the local certificate of app is due
+// for renewal, or zero when it has none or is overdue because issuing it
+// failed, which the resync retries.
+func nextRenewal(app *appv1alpha1.Application, now time.Time, renewBefore time.Duration) time.Duration {
+	cert := app.Status.Certificate
+	if cert == nil || cert.Issuer != IssuerLocal {
+		return 0
+	}
+	next := cert.NotAfter.Add(-renewBefore).Sub(now)
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
+// localIssuer signs certificates itself and renews them ahead of their
+// expiry.
+type localIssuer struct {
+	base
+}
+
+func (i *localIssuer) Issue(ctx context.Context, app *appv1alpha1.Application, secretName string, hosts []string) error {
+	cfg := i.Config.Get().Ingress.TLS
+	ca, err := i.ca(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	now := reconcileTime(ctx)
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: secretName}}
+	var reason string
+	op, err := controllerutil.CreateOrUpdate(ctx, i.Kubernetes, secret, func() error {
+		if !secret.CreationTimestamp.IsZero() && !metav1.IsControlledBy(secret, app) {
+			return fmt.Errorf("secret %s exists and is not controlled by the application", secretName)
+		}
+		reason = "missing"
+		if cert, err := certs.Parse(secret.Data[corev1.TLSCertKey]); err == nil {
+			reason = certs.NeedsRenewal(cert, ca, hosts, now, cfg.RenewBefore.Duration)
+		}
+		if reason != "" {
+			certPEM, keyPEM, err := certs.Issue(ca, hosts, now, cfg.Duration.Duration)
+			if err != nil {
+				return err
+			}
+			secret.Data = map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM}
+			if ca != nil {
+				secret.Data["ca.crt"] = ca.PEM()
+			}
+		}
+		secret.Labels = merge(secret.Labels, i.Config.Get().DefaultLabels)
+		secret.Type = corev1.SecretTypeTLS
+		return controllerutil.SetControllerReference(app, secret, i.Schema)
+	})
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info(ctx, "issued certificate", zap.String("secret", secretName), zap.String("reason", reason))
+		recordWrite(ctx)
+	}
+	return nil
+}
+
+// ca returns the CA of the operator config, or nil to self-sign.
+func (i *localIssuer) ca(ctx context.Context, cfg config.IngressTLSConfig) (*certs.CA, error) {
+	if cfg.CASecret == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := i.getUncached(ctx, client.ObjectKey{Namespace: cfg.CASecret.Namespace, Name: cfg.CASecret.Name}, secret); err != nil {
+		return nil, fmt.Errorf("reading CA %s/%s: %w", cfg.CASecret.Namespace, cfg.CASecret.Name, err)
+	}
+	ca, err := certs.LoadCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("CA %s/%s: %w", cfg.CASecret.Namespace, cfg.CASecret.Name, err)
+	}
+	return ca, nil
+}
+
+func (i *localIssuer) Release(ctx context.Context, app *appv1alpha1.Application) error {
+	secret := &corev1.Secret{}
+	err := i.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: app.Name + "-tls"}, secret)
+	if err != nil || !metav1.IsControlledBy(secret, app) || secret.Type != corev1.SecretTypeTLS {
+		return client.IgnoreNotFound(err)
+	}
+	log.Info(ctx, "deleting certificate", zap.String("secret", secret.Name))
+	recordWrite(ctx)
+	return client.IgnoreNotFound(i.Kubernetes.Delete(ctx, secret))
+}
+
+// certManagerIssuer requests certificates through cert-manager Certificates,
+// which cert-manager renews by itself.
+type certManagerIssuer struct {
+	base
+}
+
+func (i *certManagerIssuer) Issue(ctx context.Context, app *appv1alpha1.Application, secretName string, hosts []string) error {
+	ref := i.Config.Get().Ingress.TLS.CertManagerIssuer
+	if ref.Name == "" {
+		return fmt.Errorf("no cert-manager issuer is configured")
+	}
+	kind := ref.Kind
+	if kind == "" {
+		kind = "ClusterIssuer"
+	}
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certManagerCertificate)
+	cert.SetNamespace(app.Namespace)
+	cert.SetName(app.Name)
+	op, err := controllerutil.CreateOrUpdate(ctx, i.Kubernetes, cert, func() error {
+		if cert.GetResourceVersion() != "" && !metav1.IsControlledBy(cert, app) {
+			return fmt.Errorf("certificate %s exists and is not controlled by the application", app.Name)
+		}
+		dnsNames := make([]interface{}, 0, len(hosts))
+		for _, h := range hosts {
+			dnsNames = append(dnsNames, h)
+		}
+		cert.SetLabels(merge(cert.GetLabels(), i.Config.Get().DefaultLabels))
+		cert.Object["spec"] = map[string]interface{}{
+			"secretName": secretName,
+			"dnsNames":   dnsNames,
+			"issuerRef": map[string]interface{}{
+				"name":  ref.Name,
+				"kind":  kind,
+				"group": certManagerCertificate.Group,
+			},
+		}
+		return controllerutil.SetControllerReference(app, cert, i.Schema)
+	})
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info(ctx, "requested certificate", zap.String("certificate", app.Name), zap.String("operation", string(op)))
+		recordWrite(ctx)
+	}
+	return nil
+}
+
+func (i *certManagerIssuer) Release(ctx context.Context, app *appv1alpha1.Application) error {
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certManagerCertificate)
+	err := i.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: app.Name}, cert)
+	if meta.IsNoMatchError(err) {
+		// cert-manager is not installed.
+		return nil
+	}
+	if err != nil || !metav1.IsControlledBy(cert, app) {
+		return client.IgnoreNotFound(err)
+	}
+	log.Info(ctx, "deleting certificate", zap.String("certificate", app.Name))
+	recordWrite(ctx)
+	return client.IgnoreNotFound(i.Kubernetes.Delete(ctx, cert))
+}
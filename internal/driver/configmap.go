@@ -0,0 +1,242 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ConfigHashAnnotation on the pod template holds the hash of all the
+	// config projected into the pods, so that changing it rolls them.
+	ConfigHashAnnotation = "app.cloudclub.com/config-hash"
+
+	defaultConfigMountPath = "/etc/config"
+)
+
+// ConfigMapDriver stores the inline config files of an Application in a
+// ConfigMap named like it.
+type ConfigMapDriver struct {
+	base
+}
+
+func (d *ConfigMapDriver) Name() string {
+	return "configmap"
+}
+
+func (d *ConfigMapDriver) Object() client.Object {
+	return &corev1.ConfigMap{}
+}
+
+func (d *ConfigMapDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	if app.Spec.App.Config == nil || len(app.Spec.App.Config.Files) == 0 {
+		return nil, nil
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Data:       app.Spec.App.Config.Files,
+	}, nil
+}
+
+func (d *ConfigMapDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*corev1.ConfigMap), current.(*corev1.ConfigMap)
+	return equality.Semantic.DeepEqual(want.Data, have.Data)
+}
+
+func (d *ConfigMapDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*corev1.ConfigMap), &corev1.ConfigMap{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Data = want.Data
+	})
+}
+
+func (d *ConfigMapDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *ConfigMapDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	found, err := d.get(ctx, app, &corev1.ConfigMap{})
+	if err != nil || !found {
+		return condition("ConfigReady", false, "NotFound", "config map does not exist"), err
+	}
+	return condition("ConfigReady", true, "Created", "config map exists"), nil
+}
+
+// configProjection returns the volumes, mounts and environment sources that
+// project the config of app into its container.
+func configProjection(app *appv1alpha1.Application) ([]corev1.Volume, []corev1.VolumeMount, []corev1.EnvFromSource) {
+	config := app.Spec.App.Config
+	if config == nil {
+		return nil, nil, nil
+	}
+	var (
+		volumes []corev1.Volume
+		mounts  []corev1.VolumeMount
+		env     []corev1.EnvFromSource
+	)
+	if len(config.Files) > 0 {
+		mountPath := config.MountPath
+		if mountPath == "" {
+			mountPath = defaultConfigMountPath
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: app.Name},
+			}},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: "config", MountPath: mountPath, ReadOnly: true})
+	}
+	for i, source := range config.From {
+		if source.ConfigMap == "" && source.Secret == "" {
+			continue
+		}
+		if source.MountPath == "" {
+			from := corev1.EnvFromSource{Prefix: source.Prefix}
+			if source.ConfigMap != "" {
+				from.ConfigMapRef = &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: source.ConfigMap}}
+			} else {
+				from.SecretRef = &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: source.Secret}}
+			}
+			env = append(env, from)
+			continue
+		}
+		volume := corev1.Volume{Name: fmt.Sprintf("config-%d", i)}
+		if source.ConfigMap != "" {
+			volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: source.ConfigMap}}
+		} else {
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: source.Secret}
+		}
+		volumes = append(volumes, volume)
+		mounts = append(mounts, corev1.VolumeMount{Name: volume.Name, MountPath: source.MountPath, ReadOnly: true})
+	}
+	return volumes, mounts, env
+}
+
+// checkVolumeNames rejects storage volumes of app named like one of its
+// config volumes or like each other, and a config source naming the
+// ConfigMap that holds the inline files of app.
+func checkVolumeNames(app *appv1alpha1.Application, configVolumes []corev1.Volume) error {
+	if config := app.Spec.App.Config; config != nil && len(config.Files) > 0 {
+		for _, source := range config.From {
+			if source.ConfigMap == app.Name {
+				return fmt.Errorf("config map %s holds the inline config files and cannot be a config source", app.Name)
+			}
+		}
+	}
+	names := map[string]bool{}
+	for _, v := range configVolumes {
+		names[v.Name] = true
+	}
+	for _, v := range app.Spec.Storage.Volumes {
+		if names[v.Name] {
+			return fmt.Errorf("storage volume %s is named like another volume of the pods", v.Name)
+		}
+		names[v.Name] = true
+	}
+	return nil
+}
+
+// annotateConfigHash sets the config hash of app on template.
+func (b *base) annotateConfigHash(ctx context.Context, app *appv1alpha1.Application, template *corev1.PodTemplateSpec) error {
+	if app.Spec.App.Config == nil {
+		return nil
+	}
+	hash, err := b.configHash(ctx, app)
+	if err != nil {
+		return err
+	}
+	template.Annotations = merge(template.Annotations, map[string]string{ConfigHashAnnotation: hash})
+	return nil
+}
+
+// configHash hashes the inline files of app and the content of the
+// ConfigMaps and Secrets it refers to, read from the API server so that an
+// edit is seen before the cache catches up. Missing objects are hashed as
+// such, so that their creation rolls the pods waiting for them.
+func (b *base) configHash(ctx context.Context, app *appv1alpha1.Application) (string, error) {
+	config := app.Spec.App.Config
+	h := sha256.New()
+	writeData(h.Write, "files", config.Files)
+	for _, source := range config.From {
+		key := client.ObjectKey{Namespace: app.Namespace}
+		switch {
+		case source.ConfigMap != "":
+			key.Name = source.ConfigMap
+			cm := &corev1.ConfigMap{}
+			err := b.getUncached(ctx, key, cm)
+			if err != nil && !errors.IsNotFound(err) {
+				return "", err
+			}
+			writeData(h.Write, "configmap/"+key.Name, cm.Data)
+			writeData(h.Write, "configmap-binary/"+key.Name, toStrings(cm.BinaryData))
+		case source.Secret != "":
+			key.Name = source.Secret
+			secret := &corev1.Secret{}
+			err := b.getUncached(ctx, key, secret)
+			if err != nil && !errors.IsNotFound(err) {
+				return "", err
+			}
+			writeData(h.Write, "secret/"+key.Name, toStrings(secret.Data))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+func writeData(write func([]byte) (int, error), section string, data map[string]string) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	_, _ = write([]byte(section + "\x00"))
+	for _, k := range keys {
+		_, _ = write([]byte(k + "\x00" + data[k] + "\x00"))
+	}
+}
+
+func toStrings(data map[string][]byte) map[string]string {
+	out := make(map[string]string, len(data))
+	for k, v := range data {
+		out[k] = string(v)
+	}
+	return out
+}
+
+// ConfigKeys returns the keys of the ConfigMaps and Secrets app projects
+// into its pods or serves as ingress certificate.
+func ConfigKeys(app *appv1alpha1.Application) []string {
+	var keys []string
+	if app.Spec.App.Config != nil {
+		for _, source := range app.Spec.App.Config.From {
+			switch {
+			case source.ConfigMap != "":
+				keys = append(keys, ConfigKey(false, source.ConfigMap))
+			case source.Secret != "":
+				keys = append(keys, ConfigKey(true, source.Secret))
+			}
+		}
+	}
+	if ingressTLS(app) != nil {
+		keys = append(keys, ConfigKey(true, tlsSecretName(app)))
+	}
+	return keys
+}
+
+// ConfigKey returns the key of the ConfigMap, or of the Secret when secret
+// is true, called name.
+func ConfigKey(secret bool, name string) string {
+	if secret {
+		return "secret/" + name
+	}
+	return "configmap/" + name
+}
@@ -0,0 +1,142 @@
+package driver
+
+import (
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// initContainers renders spec.app.initContainers.
+func initContainers(app *appv1alpha1.Application) ([]corev1.Container, error) {
+	var containers []corev1.Container
+	for _, spec := range app.Spec.App.InitContainers {
+		if spec.Image == "" {
+			return nil, fmt.Errorf("init container %s has no image", spec.Name)
+		}
+		container := corev1.Container{Name: spec.Name}
+		overrideContainer(&container, spec)
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// sidecars renders spec.app.sidecars on top of their presets, along with the
+// Service ports of the sidecars that expose any.
+func (b *base) sidecars(app *appv1alpha1.Application) ([]corev1.Container, []corev1.ServicePort, error) {
+	presets := b.Config.Get().SidecarPresets
+	var containers []corev1.Container
+	var exposed []corev1.ServicePort
+	for _, spec := range app.Spec.App.Sidecars {
+		container := corev1.Container{}
+		expose := map[string]bool{}
+		if spec.Preset != "" {
+			preset, ok := presets[spec.Preset]
+			if !ok {
+				return nil, nil, fmt.Errorf("sidecar %s: unknown preset %s", spec.Name, spec.Preset)
+			}
+			preset.Container.DeepCopyInto(&container)
+			for _, name := range preset.Expose {
+				expose[name] = true
+			}
+		}
+		container.Name = spec.Name
+		overrideContainer(&container, spec.ContainerSpec)
+		if container.Image == "" {
+			return nil, nil, fmt.Errorf("sidecar %s has no image", spec.Name)
+		}
+		for _, p := range spec.Ports {
+			if p.Expose {
+				expose[p.Name] = true
+			}
+		}
+		for _, p := range container.Ports {
+			if expose[p.Name] {
+				exposed = append(exposed, corev1.ServicePort{
+					Name:       p.Name,
+					Port:       p.ContainerPort,
+					Protocol:   p.Protocol,
+					TargetPort: intstr.FromInt(int(p.ContainerPort)),
+				})
+			}
+		}
+		containers = append(containers, container)
+	}
+	return containers, exposed, nil
+}
+
+// checkContainers rejects pods whose containers share a name, or whose
+// containers share a port name, like a sidecar port named http next to the
+// port of the application container.
+func checkContainers(spec *corev1.PodSpec) error {
+	names := map[string]bool{}
+	ports := map[string]string{}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for _, c := range containers {
+			if names[c.Name] {
+				return fmt.Errorf("container name %s is used twice", c.Name)
+			}
+			names[c.Name] = true
+			for _, p := range c.Ports {
+				if p.Name == "" {
+					continue
+				}
+				if other, ok := ports[p.Name]; ok {
+					return fmt.Errorf("port name %s of container %s is already used by container %s", p.Name, c.Name, other)
+				}
+				ports[p.Name] = c.Name
+			}
+		}
+	}
+	return nil
+}
+
+// overrideContainer sets the fields of spec on container. Env entries
+// replace those of the same name, mounts and ports are appended, everything
+// else replaces the value in container.
+func overrideContainer(container *corev1.Container, spec appv1alpha1.ContainerSpec) {
+	if spec.Image != "" {
+		container.Image = spec.Image
+	}
+	if spec.Command != nil {
+		container.Command = spec.Command
+	}
+	if spec.Args != nil {
+		container.Args = spec.Args
+	}
+	if spec.Resources != nil {
+		container.Resources = *spec.Resources
+	}
+	container.Env = mergeEnv(container.Env, spec.Env)
+	container.VolumeMounts = append(container.VolumeMounts, spec.VolumeMounts...)
+	for _, p := range spec.Ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: p.ContainerPort,
+			Protocol:      protocol,
+		})
+	}
+}
+
+// mergeEnv returns env with the variables of override, which replace those
+// of the same name in place.
+func mergeEnv(env, override []corev1.EnvVar) []corev1.EnvVar {
+	index := make(map[string]int, len(env))
+	for i, e := range env {
+		index[e.Name] = i
+	}
+	for _, e := range override {
+		if i, ok := index[e.Name]; ok {
+			env[i] = e
+			continue
+		}
+		index[e.Name] = len(env)
+		env = append(env, e)
+	}
+	return env
+}
@@ -0,0 +1,194 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type DeploymentDriver struct {
+	base
+}
+
+func (d *DeploymentDriver) Name() string {
+	return "deployment"
+}
+
+func (d *DeploymentDriver) Object() client.Object {
+	return &v1.Deployment{}
+}
+
+func (d *DeploymentDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	if workloadKind(app) != WorkloadDeployment {
+		return nil, nil
+	}
+	template, err := d.workloadTemplate(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	deployment := &v1.Deployment{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: v1.DeploymentSpec{
+			Replicas: app.Spec.App.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorLabels(app),
+			},
+			Template: template,
+		},
+	}
+	if app.Spec.Scheduler.HPA.Enabled {
+		deployment.Annotations = map[string]string{hpaAnnotation: "true"}
+	}
+	return deployment, annotateTemplateHash(deployment)
+}
+
+func (d *DeploymentDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*v1.Deployment), current.(*v1.Deployment)
+	if hpaEnabled(want) != hpaEnabled(have) {
+		return false
+	}
+	// Replicas are left to the autoscaler when the Application has one.
+	if !hpaEnabled(want) && want.Spec.Replicas != nil &&
+		(have.Spec.Replicas == nil || *want.Spec.Replicas != *have.Spec.Replicas) {
+		return false
+	}
+	return equality.Semantic.DeepDerivative(want.Spec.Template, have.Spec.Template)
+}
+
+func (d *DeploymentDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*v1.Deployment), &v1.Deployment{}
+	return d.apply(ctx, app, d, want, have, func() {
+		if hpaEnabled(want) {
+			metav1.SetMetaDataAnnotation(&have.ObjectMeta, hpaAnnotation, "true")
+		} else {
+			delete(have.Annotations, hpaAnnotation)
+			have.Spec.Replicas = want.Spec.Replicas
+		}
+		have.Spec.Template = want.Spec.Template
+	})
+}
+
+func (d *DeploymentDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *DeploymentDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	return d.reconcileWorkload(ctx, app, d, WorkloadDeployment, &v1.StatefulSet{})
+}
+
+func (d *DeploymentDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	if blocked, err := d.migrationBlocked(ctx, app, &v1.StatefulSet{}); err != nil || blocked {
+		return migrationCondition("DeploymentReady"), err
+	}
+	deployment := &v1.Deployment{}
+	found, err := d.get(ctx, app, deployment)
+	if err != nil || !found {
+		return condition("DeploymentReady", false, "NotFound", "deployment does not exist"), err
+	}
+	want := int32(1)
+	if deployment.Spec.Replicas != nil {
+		want = *deployment.Spec.Replicas
+	}
+	if !workloadReady(deployment) {
+		return condition("DeploymentReady", false, "Progressing",
+			fmt.Sprintf("%d/%d replicas available", deployment.Status.AvailableReplicas, want)), nil
+	}
+	return condition("DeploymentReady", true, "Available",
+		fmt.Sprintf("%d/%d replicas available", deployment.Status.AvailableReplicas, want)), nil
+}
+
+// hpaAnnotation marks workloads whose replica count is owned by an HPA.
+const hpaAnnotation = "app.cloudclub.com/autoscaled"
+
+func hpaEnabled(workload metav1.Object) bool {
+	return workload.GetAnnotations()[hpaAnnotation] == "true"
+}
+
+func (b *base) podTemplate(ctx context.Context, app *appv1alpha1.Application) (corev1.PodTemplateSpec, error) {
+	cfg := b.Config.Get()
+	name := app.Spec.App.ContainerName
+	if name == "" {
+		name = app.Name
+	}
+	container := corev1.Container{
+		Name:           name,
+		Image:          appImage(app),
+		Lifecycle:      app.Spec.App.LifeCycle,
+		StartupProbe:   app.Spec.Probe.Startup,
+		LivenessProbe:  app.Spec.Probe.Liveness,
+		ReadinessProbe: app.Spec.Probe.Readiness,
+	}
+	if app.Spec.App.Resources != nil {
+		container.Resources = *app.Spec.App.Resources
+	} else if resources, ok := cfg.Resources[app.Spec.App.AppType]; ok {
+		container.Resources = resources
+	}
+	volumes, mounts, env := configProjection(app)
+	if err := checkVolumeNames(app, volumes); err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	storageVolumes, storageMounts := storageProjection(app)
+	volumes = append(volumes, storageVolumes...)
+	container.VolumeMounts = append(mounts, storageMounts...)
+	container.EnvFrom = env
+	if app.Spec.App.ContainerPort != 0 {
+		container.Ports = []corev1.ContainerPort{
+			{
+				Name:          "http",
+				ContainerPort: app.Spec.App.ContainerPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		}
+	}
+	inits, err := initContainers(app)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	sidecars, _, err := b.sidecars(app)
+	if err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      b.labels(ctx, app),
+			Annotations: merge(cfg.DefaultAnnotations, app.Spec.App.Annotations),
+		},
+		Spec: corev1.PodSpec{
+			InitContainers:                inits,
+			Containers:                    append([]corev1.Container{container}, sidecars...),
+			Volumes:                       volumes,
+			NodeSelector:                  app.Spec.Scheduler.NodeSelector,
+			Affinity:                      app.Spec.Scheduler.Affinity,
+			TerminationGracePeriodSeconds: app.Spec.TerminationGracePeriodSeconds,
+			ImagePullSecrets:              append(append([]corev1.LocalObjectReference{}, app.Spec.App.ImagePullSecrets...), b.registryCredentialSecrets()...),
+		},
+	}
+	if err := checkContainers(&template.Spec); err != nil {
+		return corev1.PodTemplateSpec{}, err
+	}
+	applySecurity(app, &template.Spec)
+	return template, nil
+}
+
+func (d *DeploymentDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*v1.Deployment), new.(*v1.Deployment)
+	return o.Status.ObservedGeneration != n.Status.ObservedGeneration ||
+		o.Status.Replicas != n.Status.Replicas ||
+		o.Status.UpdatedReplicas != n.Status.UpdatedReplicas ||
+		o.Status.AvailableReplicas != n.Status.AvailableReplicas
+}
+
+func (d *DeploymentDriver) Pods(obj client.Object) (int32, corev1.PodSpec) {
+	deployment := obj.(*v1.Deployment)
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return replicas, deployment.Spec.Template.Spec
+}
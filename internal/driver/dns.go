@@ -0,0 +1,313 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/dns"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	dnsDriver    = "dns"
+	dnsCondition = "DNSRecordReady"
+	// dnsFinalizer holds back the deletion of Applications until their
+	// records are removed.
+	dnsFinalizer = "app.cloudclub.com/dns-records"
+	// tsigSecretKey is the key of the TSIG secret in its Secret.
+	tsigSecretKey = "secret"
+)
+
+// DNSDriver publishes an A or CNAME record for the ingress host of each
+// Application, pointing at the load balancer of its Ingress or at its
+// Gateway. A TXT record beside each name claims it for the Application, and
+// names claimed by nobody else's record are the only ones written.
+type DNSDriver struct {
+	base
+	// Provider overrides the provider of the operator config.
+	Provider dns.Provider
+}
+
+func (d *DNSDriver) Name() string {
+	return dnsDriver
+}
+
+// Object returns an Ingress, whose load balancer the records follow.
+func (d *DNSDriver) Object() client.Object {
+	return &networkingv1.Ingress{}
+}
+
+// Render returns nil: the records are written by Reconcile.
+func (d *DNSDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	return nil, nil
+}
+
+func (d *DNSDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	host := d.host(app)
+	if host == "" {
+		return false, d.release(ctx, app)
+	}
+	targets, err := d.targets(ctx, app)
+	if err != nil {
+		return true, err
+	}
+	now := reconcileTime(ctx)
+	record := dns.Desired(host, targets, d.Config.Get().DNS.TTL)
+	if len(targets) > 0 && d.synced(app, record, now) {
+		return true, nil
+	}
+	p, err := d.provider(ctx)
+	if err != nil {
+		return true, err
+	}
+	if s := app.Status.DNS; s != nil && s.Name != host {
+		if err := d.unpublish(ctx, app, p); err != nil {
+			return true, err
+		}
+	}
+	if len(targets) == 0 {
+		return true, nil
+	}
+	if !controllerutil.ContainsFinalizer(app, dnsFinalizer) {
+		controllerutil.AddFinalizer(app, dnsFinalizer)
+		recordWrite(ctx)
+		if err := d.Kubernetes.Update(ctx, app); err != nil {
+			return true, err
+		}
+	}
+	changed, err := dns.Sync(ctx, p, d.owner(app), record)
+	if errors.Is(err, dns.ErrNotOwned) {
+		// Reported by the condition.
+		log.Warn(ctx, "dns name is owned by someone else", zap.String("name", host))
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if changed {
+		log.Info(ctx, "published dns record", zap.String("name", record.Name), zap.String("type", record.Type), zap.Strings("targets", record.Targets))
+		recordWrite(ctx)
+	}
+	app.Status.DNS = &appv1alpha1.DNSRecordStatus{Name: record.Name, Type: record.Type, Targets: record.Targets, SyncedAt: metav1.NewTime(now)}
+	recordWrite(ctx)
+	return true, d.Kubernetes.Status().Update(ctx, app)
+}
+
+// synced reports whether record is the one in the status of app and was
+// checked with the provider within the resync period, so that only
+// resyncs check it again.
+func (d *DNSDriver) synced(app *appv1alpha1.Application, record dns.Record, now time.Time) bool {
+	s := app.Status.DNS
+	if !published(s, record) {
+		return false
+	}
+	return now.Before(s.SyncedAt.Add(d.Config.Get().Reconcile.ResyncPeriod.Duration))
+}
+
+// published reports whether s describes record.
+func published(s *appv1alpha1.DNSRecordStatus, record dns.Record) bool {
+	return s != nil && s.Name == record.Name && s.Type == record.Type && equality.Semantic.DeepEqual(s.Targets, record.Targets)
+}
+
+// release removes the records of app and lets it be deleted.
+func (d *DNSDriver) release(ctx context.Context, app *appv1alpha1.Application) error {
+	if app.Status.DNS == nil && !controllerutil.ContainsFinalizer(app, dnsFinalizer) {
+		return nil
+	}
+	p, err := d.provider(ctx)
+	if err != nil {
+		return err
+	}
+	if err := d.unpublish(ctx, app, p); err != nil {
+		return err
+	}
+	if !controllerutil.RemoveFinalizer(app, dnsFinalizer) {
+		return nil
+	}
+	recordWrite(ctx)
+	return client.IgnoreNotFound(d.Kubernetes.Update(ctx, app))
+}
+
+// unpublish removes the record in the status of app. Without a provider,
+// the record is forgotten and left to whoever removed the provider.
+func (d *DNSDriver) unpublish(ctx context.Context, app *appv1alpha1.Application, p dns.Provider) error {
+	s := app.Status.DNS
+	if s == nil {
+		return nil
+	}
+	if p != nil {
+		removed, err := dns.Remove(ctx, p, d.owner(app), s.Name)
+		if err != nil {
+			return err
+		}
+		if removed {
+			log.Info(ctx, "removed dns record", zap.String("name", s.Name))
+		}
+	}
+	app.Status.DNS = nil
+	recordWrite(ctx)
+	return d.Kubernetes.Status().Update(ctx, app)
+}
+
+// Finalize removes the records of an Application being deleted.
+func (d *DNSDriver) Finalize(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.release(ctx, app)
+}
+
+// host returns the name published for app, or "" when there is none: no
+// provider is configured, the ingress is disabled or its host lies outside
+// the zone.
+func (d *DNSDriver) host(app *appv1alpha1.Application) string {
+	cfg := d.Config.Get()
+	if d.Provider == nil && cfg.DNS.Provider == "" || !app.Spec.Ingress.Enabled {
+		return ""
+	}
+	host := dns.Canonical(ingressHost(app, cfg.Ingress.Domain))
+	zone := dns.Canonical(cfg.DNS.Zone)
+	if host == "" || zone != "" && host != zone && !strings.HasSuffix(host, "."+zone) {
+		return ""
+	}
+	return host
+}
+
+// targets returns the addresses the host of app points at: those of the
+// load balancer of its Ingress, or of its Gateway.
+func (d *DNSDriver) targets(ctx context.Context, app *appv1alpha1.Application) ([]string, error) {
+	cfg := d.Config.Get().Ingress
+	if exposureMode(app, cfg.Mode) == ModeGateway {
+		ref := gatewayOf(app, cfg.Gateway)
+		if ref.Name == "" {
+			return nil, nil
+		}
+		if ref.Namespace == "" {
+			ref.Namespace = app.Namespace
+		}
+		gw := &unstructured.Unstructured{}
+		gw.SetGroupVersionKind(gatewayKind)
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, gw)
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		addresses, _, _ := unstructured.NestedSlice(gw.Object, "status", "addresses")
+		var targets []string
+		for _, a := range addresses {
+			if a, ok := a.(map[string]interface{}); ok {
+				if value, _ := a["value"].(string); value != "" {
+					targets = append(targets, value)
+				}
+			}
+		}
+		return targets, nil
+	}
+	ingress := &networkingv1.Ingress{}
+	found, err := d.get(ctx, app, ingress)
+	if err != nil || !found {
+		return nil, err
+	}
+	return loadBalancerAddresses(ingress), nil
+}
+
+// provider returns the provider of the operator config, or nil when none
+// is configured.
+func (d *DNSDriver) provider(ctx context.Context) (dns.Provider, error) {
+	if d.Provider != nil {
+		return d.Provider, nil
+	}
+	cfg := d.Config.Get().DNS
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "file":
+		return &dns.ZoneFile{Path: cfg.File.Path, Origin: cfg.Zone}, nil
+	case "rfc2136":
+		if cfg.Zone == "" {
+			return nil, fmt.Errorf("the rfc2136 dns provider needs dns.zone")
+		}
+		p := &dns.RFC2136{
+			Server:        cfg.RFC2136.Server,
+			Zone:          cfg.Zone,
+			TSIGKey:       cfg.RFC2136.TSIGKeyName,
+			TSIGAlgorithm: cfg.RFC2136.TSIGAlgorithm,
+		}
+		if ref := cfg.RFC2136.TSIGSecretRef; ref != nil {
+			secret := &corev1.Secret{}
+			if err := d.getUncached(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+				return nil, fmt.Errorf("reading TSIG secret %s/%s: %w", ref.Namespace, ref.Name, err)
+			}
+			p.TSIGSecret = string(secret.Data[tsigSecretKey])
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown dns provider %q", cfg.Provider)
+	}
+}
+
+func (d *DNSDriver) owner(app *appv1alpha1.Application) dns.Owner {
+	return dns.Owner{ID: d.Config.Get().DNS.OwnerID, Resource: "application/" + app.Namespace + "/" + app.Name}
+}
+
+func (d *DNSDriver) Compare(desired, current client.Object) bool {
+	return true
+}
+
+func (d *DNSDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	_, err := d.Reconcile(ctx, app)
+	return err
+}
+
+func (d *DNSDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.release(ctx, app)
+}
+
+func (d *DNSDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	host := d.host(app)
+	if host == "" {
+		return condition(dnsCondition, false, "NotRequested", "no ingress host to publish"), nil
+	}
+	targets, err := d.targets(ctx, app)
+	if err != nil {
+		return metav1.Condition{}, err
+	}
+	if len(targets) == 0 {
+		return condition(dnsCondition, false, "Pending", "load balancer address is not assigned yet"), nil
+	}
+	record := dns.Desired(host, targets, d.Config.Get().DNS.TTL)
+	message := fmt.Sprintf("%s %s -> %s", record.Type, record.Name, strings.Join(record.Targets, ","))
+	if published(app.Status.DNS, record) {
+		return condition(dnsCondition, true, "Published", message), nil
+	}
+	p, err := d.provider(ctx)
+	if err != nil {
+		return condition(dnsCondition, false, "ProviderError", err.Error()), nil
+	}
+	err = dns.Check(ctx, p, d.owner(app), record)
+	switch {
+	case errors.Is(err, dns.ErrNotOwned):
+		return condition(dnsCondition, false, "NotOwned", err.Error()), nil
+	case err != nil:
+		return condition(dnsCondition, false, "NotPublished", err.Error()), nil
+	}
+	return condition(dnsCondition, true, "Published", message), nil
+}
+
+func (d *DNSDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*networkingv1.Ingress), new.(*networkingv1.Ingress)
+	return !equality.Semantic.DeepEqual(o.Status.LoadBalancer, n.Status.LoadBalancer)
+}
@@ -0,0 +1,247 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/project"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ResourceDriver manages one kind of child resource of an Application.
+type ResourceDriver interface {
+	// Name is the key the driver is registered and enabled under.
+	Name() string
+	// Object returns an empty object of the managed kind.
+	Object() client.Object
+	// Render returns the desired object for app, or nil when app does not
+	// want one.
+	Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error)
+	// Compare reports whether current already matches desired.
+	Compare(desired, current client.Object) bool
+	// Apply creates desired or updates the existing object to match it.
+	Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error
+	// Delete removes the object owned by app, if any.
+	Delete(ctx context.Context, app *appv1alpha1.Application) error
+	// Status reports the observed state of the object as a condition.
+	Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error)
+}
+
+// StatusWatcher is implemented by drivers whose Status reads the status of
+// the live object. Updates of owned objects that only touch status are
+// dropped unless StatusChanged reports a change in a field Status reads.
+type StatusWatcher interface {
+	StatusChanged(old, new client.Object) bool
+}
+
+// Reconciler is implemented by drivers managing a varying number of objects
+// per Application. ApplicationClient calls Reconcile instead of rendering a
+// single object and applying or deleting it.
+type Reconciler interface {
+	// Reconcile brings the objects of app up to date and reports whether
+	// app wants any.
+	Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error)
+}
+
+// PodRenderer is implemented by drivers whose objects run the pods of an
+// Application. Their rendered objects are checked against the quota of the
+// namespace before anything is applied.
+type PodRenderer interface {
+	// Pods returns the number of replicas and the pod spec of obj.
+	Pods(obj client.Object) (int32, corev1.PodSpec)
+}
+
+// StatusRecorder is implemented by drivers reporting more than a condition.
+// RecordStatus fills in their fields of status, clearing them when app does
+// not want their objects.
+type StatusRecorder interface {
+	RecordStatus(ctx context.Context, app *appv1alpha1.Application, wanted bool, status *appv1alpha1.ApplicationStatus) error
+}
+
+// Finalizer is implemented by drivers managing state outside the cluster,
+// which garbage collection cannot remove. They hold a finalizer on the
+// Applications with such state, and Finalize removes both once the
+// Application is being deleted.
+type Finalizer interface {
+	Finalize(ctx context.Context, app *appv1alpha1.Application) error
+}
+
+// Builtin returns the drivers shipped with the operator in the order they
+// are reconciled. The Secrets named by cfg are read with reader, which may
+// be nil to read them with kube.
+func Builtin(kube client.Client, reader client.Reader, schema *runtime.Scheme, cfg *config.Store) []ResourceDriver {
+	b := base{Kubernetes: kube, Reader: reader, Schema: schema, Config: cfg}
+	return []ResourceDriver{
+		&RegistryCredentialDriver{base: b},
+		&ConfigMapDriver{base: b},
+		&StorageDriver{base: b},
+		&HookDriver{base: b},
+		&DeploymentDriver{base: b},
+		&StatefulSetDriver{base: b},
+		&ServiceDriver{base: b},
+		&CertificateDriver{base: b, Issuers: map[string]CertificateIssuer{
+			IssuerCertManager: &certManagerIssuer{base: b},
+			IssuerLocal:       &localIssuer{base: b},
+		}},
+		&IngressDriver{base: b},
+		&HTTPRouteDriver{base: b},
+		&DNSDriver{base: b},
+		&HPADriver{base: b},
+		&PDBDriver{base: b},
+		&NetworkPolicyDriver{base: b},
+	}
+}
+
+type base struct {
+	Kubernetes client.Client
+	// Reader reads the objects outside the watched namespaces, like the
+	// Secrets named by the operator config. Kubernetes is used when nil.
+	Reader client.Reader
+	Schema *runtime.Scheme
+	Config *config.Store
+}
+
+// readTimeout bounds the reads that bypass the cache.
+const readTimeout = 10 * time.Second
+
+// getUncached reads the object at key into obj from the API server.
+func (b *base) getUncached(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return getUncached(ctx, b.Kubernetes, b.Reader, key, obj)
+}
+
+// getUncached reads the object at key into obj with reader, or with kube
+// when reader is nil.
+func getUncached(ctx context.Context, kube client.Client, reader client.Reader, key client.ObjectKey, obj client.Object) error {
+	if reader == nil {
+		reader = kube
+	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+	return reader.Get(ctx, key, obj)
+}
+
+// apply creates desired when it does not exist yet. Otherwise it loads the
+// live object into current and, unless it was written from the same desired
+// state and d reports no drift, lets update copy the desired state onto it
+// before writing it back.
+func (b *base) apply(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver, desired, current client.Object, update func()) error {
+	hash, err := specHash(desired)
+	if err != nil {
+		return err
+	}
+	desired.SetAnnotations(merge(desired.GetAnnotations(), map[string]string{SpecHashAnnotation: hash}))
+	if err := ctrl.SetControllerReference(app, desired, b.Schema); err != nil {
+		return err
+	}
+	err = b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(desired), current)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info(ctx, "creating "+d.Name())
+			recordWrite(ctx)
+			return b.Kubernetes.Create(ctx, desired)
+		}
+		return err
+	}
+	metadataSynced := equality.Semantic.DeepDerivative(desired.GetLabels(), current.GetLabels()) &&
+		equality.Semantic.DeepDerivative(desired.GetAnnotations(), current.GetAnnotations())
+	if metadataSynced && d.Compare(desired, current) {
+		return nil
+	}
+	current.SetLabels(merge(current.GetLabels(), desired.GetLabels()))
+	current.SetAnnotations(merge(current.GetAnnotations(), desired.GetAnnotations()))
+	update()
+	log.Info(ctx, "updating "+d.Name())
+	recordWrite(ctx)
+	return b.Kubernetes.Update(ctx, current)
+}
+
+// delete removes the object named like app when it is controlled by app.
+func (b *base) delete(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver) error {
+	return b.deleteNamed(ctx, app, d, app.Name)
+}
+
+// deleteNamed removes the object called name in the namespace of app when it
+// is controlled by app.
+func (b *base) deleteNamed(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver, name string) error {
+	current := d.Object()
+	err := b.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, current)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(current, app) {
+		return nil
+	}
+	log.Info(ctx, "deleting "+d.Name())
+	recordWrite(ctx)
+	return client.IgnoreNotFound(b.Kubernetes.Delete(ctx, current))
+}
+
+// get loads the object named like app into obj and reports whether it exists.
+func (b *base) get(ctx context.Context, app *appv1alpha1.Application, obj client.Object) (bool, error) {
+	err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), obj)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func condition(conditionType string, ok bool, reason, message string) metav1.Condition {
+	status := metav1.ConditionFalse
+	if ok {
+		status = metav1.ConditionTrue
+	}
+	return metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// objectMeta names a child object after app and applies the operator's
+// default labels and annotations to it.
+func (b *base) objectMeta(ctx context.Context, app *appv1alpha1.Application) metav1.ObjectMeta {
+	cfg := b.Config.Get()
+	return metav1.ObjectMeta{
+		Name:        app.Name,
+		Namespace:   app.Namespace,
+		Labels:      b.labels(ctx, app),
+		Annotations: merge(cfg.DefaultAnnotations),
+	}
+}
+
+// labels returns the labels of every object managed for app: the operator's
+// defaults, those of the Project in ctx, and the selector labels.
+func (b *base) labels(ctx context.Context, app *appv1alpha1.Application) map[string]string {
+	return merge(b.Config.Get().DefaultLabels, project.DefaultLabels(ctx), selectorLabels(app))
+}
+
+// merge returns the union of maps, later maps winning, or nil when all of
+// them are empty.
+func merge(maps ...map[string]string) map[string]string {
+	var merged map[string]string
+	for _, m := range maps {
+		for k, v := range m {
+			if merged == nil {
+				merged = map[string]string{}
+			}
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func selectorLabels(app *appv1alpha1.Application) map[string]string {
+	return map[string]string{
+		"app": app.Name,
+	}
+}
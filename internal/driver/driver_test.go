@@ -0,0 +1,1844 @@
+package driver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/dns"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+	"github.com/cloud-club/cloudclub-operator/internal/signature"
+	v1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestBase(t *testing.T, objs ...client.Object) base {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base{
+		Kubernetes: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Schema:     scheme,
+		Config:     cfg,
+	}
+}
+
+func newTestApplication() *appv1alpha1.Application {
+	replicas := int32(2)
+	return &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid"},
+		Spec: appv1alpha1.ApplicationSpec{
+			App: appv1alpha1.AppSpec{
+				Image:         "nginx:1.25",
+				ContainerName: "nginx",
+				ContainerPort: 80,
+				Replicas:      &replicas,
+			},
+		},
+	}
+}
+
+func reconcile(t *testing.T, d ResourceDriver, app *appv1alpha1.Application) {
+	t.Helper()
+	ctx := context.Background()
+	desired, err := d.Render(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desired == nil {
+		err = d.Delete(ctx, app)
+	} else {
+		err = d.Apply(ctx, app, desired)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeploymentDriverCreatesAndUpdates(t *testing.T) {
+	app := newTestApplication()
+	d := &DeploymentDriver{base: newTestBase(t, app)}
+
+	reconcile(t, d, app)
+	deployment := &v1.Deployment{}
+	if err := d.Kubernetes.Get(context.Background(), client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(deployment, app) {
+		t.Errorf("deployment is not controlled by the application")
+	}
+
+	replicas := int32(5)
+	app.Spec.App.Replicas = &replicas
+	app.Spec.App.Image = "nginx:1.26"
+	reconcile(t, d, app)
+	if err := d.Kubernetes.Get(context.Background(), client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 5 {
+		t.Errorf("replicas = %d, want 5", *deployment.Spec.Replicas)
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.26" {
+		t.Errorf("image = %s, want nginx:1.26", image)
+	}
+}
+
+func TestDeploymentDriverLeavesReplicasToAutoscaler(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Scheduler.HPA = appv1alpha1.HorizontalPodAutoscalerSpec{Enabled: true, MaxReplicas: 10}
+	d := &DeploymentDriver{base: newTestBase(t, app)}
+	reconcile(t, d, app)
+
+	deployment := &v1.Deployment{}
+	_ = d.Kubernetes.Get(context.Background(), client.ObjectKeyFromObject(app), deployment)
+	scaled := int32(7)
+	deployment.Spec.Replicas = &scaled
+	if err := d.Kubernetes.Update(context.Background(), deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	desired, _ := d.Render(context.Background(), app)
+	if !d.Compare(desired, deployment) {
+		t.Errorf("replica drift caused by the autoscaler should not be reconciled")
+	}
+}
+
+func TestIngressDriverDeletesWhenDisabled(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	d := &IngressDriver{base: newTestBase(t, app)}
+	reconcile(t, d, app)
+
+	ingress := &networkingv1.Ingress{}
+	if err := d.Kubernetes.Get(context.Background(), client.ObjectKeyFromObject(app), ingress); err != nil {
+		t.Fatal(err)
+	}
+	if host := ingress.Spec.Rules[0].Host; host != "web.example.com" {
+		t.Errorf("host = %s, want web.example.com", host)
+	}
+
+	app.Spec.Ingress.Enabled = false
+	reconcile(t, d, app)
+	err := d.Kubernetes.Get(context.Background(), client.ObjectKeyFromObject(app), ingress)
+	if !errors.IsNotFound(err) {
+		t.Errorf("ingress still exists after it was disabled: %v", err)
+	}
+}
+
+func TestApplySkipsUnchangedObjects(t *testing.T) {
+	app := newTestApplication()
+	d := &DeploymentDriver{base: newTestBase(t, app)}
+	reconcile(t, d, app)
+
+	ctx, writes := WithWriteCounter(context.Background())
+	desired, err := d.Render(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(ctx, app, desired); err != nil {
+		t.Fatal(err)
+	}
+	if *writes != 0 {
+		t.Errorf("unchanged deployment was written %d times", *writes)
+	}
+
+	app.Spec.App.ContainerPort = 0
+	desired, _ = d.Render(ctx, app)
+	if err := d.Apply(ctx, app, desired); err != nil {
+		t.Fatal(err)
+	}
+	if *writes != 1 {
+		t.Errorf("removing the container port made %d writes, want 1", *writes)
+	}
+	deployment := &v1.Deployment{}
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment)
+	if ports := deployment.Spec.Template.Spec.Containers[0].Ports; len(ports) != 0 {
+		t.Errorf("ports = %v, want none", ports)
+	}
+}
+
+func TestReconcileMarksQuotaExceeded(t *testing.T) {
+	app := newTestApplication()
+	cpu := resource.MustParse("500m")
+	app.Spec.App.Resources = &corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "budget", Namespace: app.Namespace},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			corev1.ResourceRequestsCPU: resource.MustParse("800m"),
+		}},
+	}
+	b := newTestBase(t, app, quota)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+
+	ctx := context.Background()
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(app.Status.Conditions, quotaExceededCondition) {
+		t.Errorf("conditions = %v, want QuotaExceeded", app.Status.Conditions)
+	}
+	err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("deployment over quota was created: %v", err)
+	}
+}
+
+func TestReconcileChargesQuotaForRecordedAndAutoscaledPods(t *testing.T) {
+	cpu := resource.MustParse("100m")
+	app := newTestApplication()
+	app.Spec.App.Resources = &corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}}
+	// Another Application recorded a request that its spec alone would not
+	// tell, so only reading the status counts it.
+	other := newTestApplication()
+	other.Name, other.UID = "api", "api-uid"
+	other.Status.QuotaRequested = corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("600m")}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "budget", Namespace: app.Namespace},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			corev1.ResourceRequestsCPU: resource.MustParse("1"),
+		}},
+	}
+	b := newTestBase(t, app, other, quota)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if meta.IsStatusConditionTrue(app.Status.Conditions, quotaExceededCondition) {
+		t.Fatalf("conditions = %v, want within quota", app.Status.Conditions)
+	}
+	if got := app.Status.QuotaRequested[corev1.ResourceRequestsCPU]; got.Cmp(resource.MustParse("200m")) != 0 {
+		t.Errorf("recorded cpu request = %s, want 200m for 2 replicas", got.String())
+	}
+
+	// An autoscaler may scale to 5 replicas, which no longer fit.
+	app.Spec.Scheduler.HPA = appv1alpha1.HorizontalPodAutoscalerSpec{Enabled: true, MaxReplicas: 5}
+	if err := a.Kubernetes.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if !meta.IsStatusConditionTrue(app.Status.Conditions, quotaExceededCondition) {
+		t.Errorf("conditions = %v, want QuotaExceeded for the autoscaler maximum", app.Status.Conditions)
+	}
+}
+
+func TestNetworkPolicyDriverResolvesPeers(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Networking = &appv1alpha1.NetworkingSpec{
+		AllowFrom: []appv1alpha1.ApplicationPeer{{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "front"}}}},
+		AllowTo:   []appv1alpha1.ApplicationPeer{{Name: "db"}},
+	}
+	front := newTestApplication()
+	front.Name, front.UID, front.Labels = "front", "uid-front", map[string]string{"tier": "front"}
+	d := &NetworkPolicyDriver{base: newTestBase(t, app, front)}
+	reconcile(t, d, app)
+
+	ctx := context.Background()
+	policy := &networkingv1.NetworkPolicy{}
+	if err := d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Ingress) != 1 || policy.Spec.Ingress[0].From[0].PodSelector.MatchLabels["app"] != "front" {
+		t.Errorf("ingress rules = %+v, want one from front", policy.Spec.Ingress)
+	}
+	// Only DNS is allowed out until db exists.
+	if len(policy.Spec.Egress) != 1 {
+		t.Errorf("egress rules = %+v, want only DNS", policy.Spec.Egress)
+	}
+	deny := &networkingv1.NetworkPolicy{}
+	if err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: denyPolicyName(app)}, deny); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestApplication()
+	db.Name, db.UID = "db", "uid-db"
+	if !SelectsPeer(app, db) || !SelectsPeer(app, front) {
+		t.Errorf("peers of the application are not selected")
+	}
+	if keys := PeerKeys(app); len(keys) != 2 || keys[0] != "default/" || keys[1] != "default/db" {
+		t.Errorf("peer keys = %v, want the selector and db", keys)
+	}
+	if err := d.Kubernetes.Create(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	reconcile(t, d, app)
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), policy)
+	if len(policy.Spec.Egress) != 2 || policy.Spec.Egress[1].To[0].PodSelector.MatchLabels["app"] != "db" {
+		t.Errorf("egress rules = %+v, want DNS and db", policy.Spec.Egress)
+	}
+
+	// The ingress controller may only call in from its own namespace.
+	app.Spec.Ingress.Enabled = true
+	reconcile(t, d, app)
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), policy)
+	if len(policy.Spec.Ingress) != 1 {
+		t.Errorf("ingress rules = %+v, want none for an unknown controller namespace", policy.Spec.Ingress)
+	}
+	cfg := config.Default()
+	cfg.Ingress.ControllerNamespace = "ingress-nginx"
+	d.Config.Set(cfg)
+	reconcile(t, d, app)
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), policy)
+	if len(policy.Spec.Ingress) != 2 || policy.Spec.Ingress[1].From[0].NamespaceSelector.MatchLabels[namespaceNameLabel] != "ingress-nginx" {
+		t.Errorf("ingress rules = %+v, want one from ingress-nginx", policy.Spec.Ingress)
+	}
+
+	app.Spec.Networking = nil
+	reconcile(t, d, app)
+	for _, name := range []string{app.Name, denyPolicyName(app)} {
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, &networkingv1.NetworkPolicy{})
+		if !errors.IsNotFound(err) {
+			t.Errorf("network policy %s still exists: %v", name, err)
+		}
+	}
+}
+
+func TestDeploymentDriverRollsOnConfigChange(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Config = &appv1alpha1.ConfigSpec{
+		Files: map[string]string{"app.yaml": "debug: false"},
+		From:  []appv1alpha1.ConfigSource{{Secret: "credentials", Prefix: "DB_"}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: app.Namespace},
+		Data:       map[string][]byte{"PASSWORD": []byte("one")},
+	}
+	b := newTestBase(t, app, secret)
+	d := &DeploymentDriver{base: b}
+	reconcile(t, &ConfigMapDriver{base: b}, app)
+	reconcile(t, d, app)
+
+	ctx := context.Background()
+	deployment := &v1.Deployment{}
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment)
+	hash := deployment.Spec.Template.Annotations[ConfigHashAnnotation]
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if hash == "" || len(container.VolumeMounts) != 1 || container.EnvFrom[0].SecretRef.Name != "credentials" {
+		t.Fatalf("config is not projected: hash %q, container %+v", hash, container)
+	}
+	if err := d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &corev1.ConfigMap{}); err != nil {
+		t.Fatal(err)
+	}
+
+	secret.Data["PASSWORD"] = []byte("two")
+	if err := d.Kubernetes.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if keys := ConfigKeys(app); len(keys) != 1 || keys[0] != ConfigKey(true, "credentials") {
+		t.Errorf("application does not reference its secret")
+	}
+	reconcile(t, d, app)
+	_ = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment)
+	if deployment.Spec.Template.Annotations[ConfigHashAnnotation] == hash {
+		t.Errorf("config hash did not change after the secret was edited")
+	}
+}
+
+func TestConfigHashReadsPastTheCache(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Config = &appv1alpha1.ConfigSpec{From: []appv1alpha1.ConfigSource{{ConfigMap: "settings"}}}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: app.Namespace},
+		Data:       map[string]string{"MODE": "one"},
+	}
+	b := newTestBase(t, app, cm)
+	ctx := context.Background()
+	cached, err := b.configHash(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache still holds the old content when the edit is announced.
+	edited := cm.DeepCopy()
+	edited.Data["MODE"] = "two"
+	b.Reader = fake.NewClientBuilder().WithScheme(b.Schema).WithObjects(edited).Build()
+	hash, err := b.configHash(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == cached {
+		t.Errorf("config hash was computed from the cache")
+	}
+}
+
+func TestStorageDriverExpandsAndRetainsClaims(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Storage.Volumes = []appv1alpha1.StorageVolume{
+		{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appv1alpha1.PersistentVolumeClaimSpec{Size: resource.MustParse("1Gi")}},
+		{Name: "cache", MountPath: "/cache", EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+	d := &StorageDriver{base: newTestBase(t, app)}
+	ctx := context.Background()
+	if _, err := d.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	claim := &corev1.PersistentVolumeClaim{}
+	key := client.ObjectKey{Namespace: app.Namespace, Name: "web-data"}
+	if err := d.Kubernetes.Get(ctx, key, claim); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(claim, app) {
+		t.Errorf("claim is not owned by the application")
+	}
+
+	app.Spec.Storage.Volumes[0].PersistentVolumeClaim.Size = resource.MustParse("5Gi")
+	app.Spec.Storage.Volumes[0].PersistentVolumeClaim.RetainPolicy = "Retain"
+	if _, err := d.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Kubernetes.Get(ctx, key, claim)
+	if size := claim.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "5Gi" {
+		t.Errorf("claim size = %s, want 5Gi", size.String())
+	}
+	if metav1.IsControlledBy(claim, app) {
+		t.Errorf("retained claim is still owned by the application")
+	}
+
+	app.Spec.Storage.Volumes = nil
+	if err := d.Delete(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Kubernetes.Get(ctx, key, claim); err != nil {
+		t.Errorf("retained claim was deleted: %v", err)
+	}
+
+	volumes, mounts := storageProjection(newTestApplication())
+	if len(volumes) != 0 || len(mounts) != 0 {
+		t.Errorf("application without storage has volumes %v", volumes)
+	}
+}
+
+func TestReconcileReportsRejectedExpansion(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Storage.Volumes = []appv1alpha1.StorageVolume{
+		{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appv1alpha1.PersistentVolumeClaimSpec{Size: resource.MustParse("5Gi")}},
+	}
+	enabled := true
+	app.Spec.Scheduler.PodDisruptionBudgetSpec.Enabled = &enabled
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-data", Namespace: app.Namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+	b := newTestBase(t, app, claim)
+	b.Kubernetes = noExpansion{b.Kubernetes}
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	// The drivers after storage still run.
+	if err := a.Kubernetes.Get(ctx, key, &policyv1.PodDisruptionBudget{}); err != nil {
+		t.Errorf("pod disruption budget was not applied: %v", err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(claim), claim)
+	if size := claim.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "1Gi" || !metav1.IsControlledBy(claim, app) {
+		t.Errorf("claim = %s owned by %v, want 1Gi owned by the application", size.String(), claim.OwnerReferences)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, "StorageReady"); c == nil || c.Reason != "ExpansionRejected" {
+		t.Errorf("conditions = %v, want StorageReady ExpansionRejected", app.Status.Conditions)
+	}
+}
+
+// noExpansion rejects growing PersistentVolumeClaims like the API server
+// does when their storage class does not allow volume expansion.
+type noExpansion struct {
+	client.Client
+}
+
+func (c noExpansion) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if claim, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+		current := &corev1.PersistentVolumeClaim{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(claim), current); err == nil {
+			want, have := claim.Spec.Resources.Requests[corev1.ResourceStorage], current.Spec.Resources.Requests[corev1.ResourceStorage]
+			if want.Cmp(have) > 0 {
+				return errors.NewForbidden(corev1.Resource("persistentvolumeclaims"), claim.Name,
+					fmt.Errorf("only dynamically provisioned pvc can be resized and the storageclass that provisions the pvc must support resize"))
+			}
+		}
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestDeploymentDriverRejectsConflictingVolumes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config  *appv1alpha1.ConfigSpec
+		volumes []string
+	}{
+		"inline config":  {config: &appv1alpha1.ConfigSpec{Files: map[string]string{"app.yaml": ""}}, volumes: []string{"config"}},
+		"mounted source": {config: &appv1alpha1.ConfigSpec{From: []appv1alpha1.ConfigSource{{Secret: "tls", MountPath: "/tls"}}}, volumes: []string{"config-0"}},
+		"storage twice":  {volumes: []string{"data", "data"}},
+		"inline config map as source": {config: &appv1alpha1.ConfigSpec{
+			Files: map[string]string{"app.yaml": ""},
+			From:  []appv1alpha1.ConfigSource{{ConfigMap: "web"}},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.App.Config = tc.config
+			for _, v := range tc.volumes {
+				app.Spec.Storage.Volumes = append(app.Spec.Storage.Volumes, appv1alpha1.StorageVolume{Name: v, MountPath: "/" + v})
+			}
+			d := &DeploymentDriver{base: newTestBase(t, app)}
+			if _, err := d.Render(context.Background(), app); err == nil {
+				t.Error("conflicting volumes were rendered")
+			}
+		})
+	}
+}
+
+func TestStatefulSetDriverMigratesFromDeployment(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Storage.Volumes = []appv1alpha1.StorageVolume{
+		{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appv1alpha1.PersistentVolumeClaimSpec{Size: resource.MustParse("1Gi")}},
+	}
+	b := newTestBase(t, app)
+	deployments, statefulSets := &DeploymentDriver{base: b}, &StatefulSetDriver{base: b}
+	ctx := context.Background()
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+
+	app.Spec.WorkloadKind = WorkloadStatefulSet
+	if _, err := statefulSets.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	statefulSet := &v1.StatefulSet{}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), statefulSet); !errors.IsNotFound(err) {
+		t.Fatalf("stateful set created without allowMigration: %v", err)
+	}
+	status, _ := statefulSets.Status(ctx, app)
+	if status.Reason != "MigrationNotAllowed" {
+		t.Errorf("status reason = %s, want MigrationNotAllowed", status.Reason)
+	}
+
+	app.Spec.Workload.AllowMigration = true
+	if _, err := statefulSets.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if templates := statefulSet.Spec.VolumeClaimTemplates; len(templates) != 1 || templates[0].Name != "data" {
+		t.Errorf("volume claim templates = %v, want data", templates)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: "web-headless"}, &corev1.Service{}); err != nil {
+		t.Errorf("headless service: %v", err)
+	}
+
+	// The deployment stays until the stateful set is ready.
+	deployment := &v1.Deployment{}
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatalf("deployment deleted before its replacement was ready: %v", err)
+	}
+	// Ready replicas of the previous revision do not count.
+	statefulSet.Status = v1.StatefulSetStatus{ReadyReplicas: 2, UpdatedReplicas: 2, CurrentRevision: "web-1", UpdateRevision: "web-2"}
+	if err := b.Kubernetes.Status().Update(ctx, statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatalf("deployment deleted during the rollout of its replacement: %v", err)
+	}
+	statefulSet.Status.CurrentRevision = "web-2"
+	if err := b.Kubernetes.Status().Update(ctx, statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); !errors.IsNotFound(err) {
+		t.Errorf("deployment still exists after migration: %v", err)
+	}
+}
+
+func TestReconcileStatefulSetRepairsDrift(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.WorkloadKind = WorkloadStatefulSet
+	app.Spec.Storage.Volumes = []appv1alpha1.StorageVolume{
+		{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appv1alpha1.PersistentVolumeClaimSpec{Size: resource.MustParse("1Gi")}},
+	}
+	b := newTestBase(t, app)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	statefulSet := &v1.StatefulSet{}
+	if err := b.Kubernetes.Get(ctx, key, statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	statefulSet.Spec.PersistentVolumeClaimRetentionPolicy.WhenDeleted = v1.RetainPersistentVolumeClaimRetentionPolicyType
+	if err := b.Kubernetes.Update(ctx, statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = b.Kubernetes.Get(ctx, key, statefulSet)
+	if policy := statefulSet.Spec.PersistentVolumeClaimRetentionPolicy.WhenDeleted; policy != v1.DeletePersistentVolumeClaimRetentionPolicyType {
+		t.Errorf("claim retention when deleted = %s, want Delete", policy)
+	}
+}
+
+func TestWorkloadReadyWithPartition(t *testing.T) {
+	replicas, partition := int32(3), int32(2)
+	statefulSet := &v1.StatefulSet{
+		Spec: v1.StatefulSetSpec{
+			Replicas: &replicas,
+			UpdateStrategy: v1.StatefulSetUpdateStrategy{
+				Type:          v1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &v1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+			},
+		},
+		Status: v1.StatefulSetStatus{ReadyReplicas: 3, CurrentRevision: "web-1", UpdateRevision: "web-2"},
+	}
+	if workloadReady(statefulSet) {
+		t.Errorf("ready before the replicas above the partition were updated")
+	}
+	statefulSet.Status.UpdatedReplicas = 1
+	if !workloadReady(statefulSet) {
+		t.Errorf("not ready once the replicas above the partition were updated")
+	}
+}
+
+func TestWorkloadReadyWaitsForOldReplicas(t *testing.T) {
+	replicas := int32(2)
+	deployment := &v1.Deployment{
+		Spec:   v1.DeploymentSpec{Replicas: &replicas},
+		Status: v1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2},
+	}
+	if workloadReady(deployment) {
+		t.Error("a deployment with a pod of the old template left is ready")
+	}
+	deployment.Status.Replicas = 2
+	if !workloadReady(deployment) {
+		t.Error("a rolled out deployment is not ready")
+	}
+}
+
+func TestSidecarPresetsAndExposedPorts(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.InitContainers = []appv1alpha1.ContainerSpec{{Name: "migrate", Image: "migrate:1", Args: []string{"up"}}}
+	app.Spec.App.Sidecars = []appv1alpha1.SidecarSpec{
+		{ContainerSpec: appv1alpha1.ContainerSpec{
+			Name: "logs",
+			Env:  []corev1.EnvVar{{Name: "LEVEL", Value: "debug"}},
+		}, Preset: "log-shipper"},
+		{ContainerSpec: appv1alpha1.ContainerSpec{
+			Name:  "metrics",
+			Image: "exporter:1",
+			Ports: []appv1alpha1.ContainerPort{{Name: "metrics", ContainerPort: 9100, Expose: true}},
+		}},
+	}
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.SidecarPresets = map[string]config.SidecarPreset{
+		"log-shipper": {Container: corev1.Container{
+			Image: "fluent-bit:2",
+			Env:   []corev1.EnvVar{{Name: "LEVEL", Value: "info"}, {Name: "OUTPUT", Value: "stdout"}},
+			Ports: []corev1.ContainerPort{{Name: "admin", ContainerPort: 2020}},
+		}},
+	}
+	b.Config.Set(cfg)
+
+	desired, err := (&DeploymentDriver{base: b}).Render(context.Background(), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := desired.(*v1.Deployment).Spec.Template.Spec
+	if len(pod.InitContainers) != 1 || pod.InitContainers[0].Args[0] != "up" {
+		t.Errorf("init containers = %v", pod.InitContainers)
+	}
+	if len(pod.Containers) != 3 || pod.Containers[1].Name != "logs" || pod.Containers[1].Image != "fluent-bit:2" {
+		t.Errorf("containers = %v, want nginx, logs from the preset and metrics", pod.Containers)
+	}
+	if env := pod.Containers[1].Env; len(env) != 2 || env[0].Value != "debug" {
+		t.Errorf("sidecar env = %v, want the preset overridden by the spec", env)
+	}
+
+	service, err := (&ServiceDriver{base: b}).Render(context.Background(), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := service.(*corev1.Service).Spec.Ports
+	if len(ports) != 2 || ports[1].Name != "metrics" || ports[1].Port != 9100 {
+		t.Errorf("service ports = %v, want http and metrics", ports)
+	}
+
+	app.Spec.App.Sidecars[0].Preset = "missing"
+	if _, err := (&DeploymentDriver{base: b}).Render(context.Background(), app); err == nil {
+		t.Errorf("expected an error for an unknown preset")
+	}
+}
+
+func TestReconcileReportsInvalidContainers(t *testing.T) {
+	for name, sidecar := range map[string]appv1alpha1.ContainerSpec{
+		"main container name": {Name: "nginx", Image: "proxy:1"},
+		"http port":           {Name: "proxy", Image: "proxy:1", Ports: []appv1alpha1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApplication()
+			app.Spec.App.Sidecars = []appv1alpha1.SidecarSpec{{ContainerSpec: sidecar}}
+			b := newTestBase(t, app)
+			a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+			ctx := context.Background()
+			if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+				t.Fatal(err)
+			}
+			if c := meta.FindStatusCondition(app.Status.Conditions, invalidSpecCondition); c == nil || c.Status != metav1.ConditionTrue {
+				t.Errorf("invalid spec condition = %v", c)
+			}
+			if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{}); !errors.IsNotFound(err) {
+				t.Errorf("deployment of an invalid spec was created: %v", err)
+			}
+			if err := a.Validate(ctx, app); err == nil {
+				t.Error("Validate accepted an invalid spec")
+			}
+		})
+	}
+}
+
+func TestPreDeployHookGatesRollout(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Hooks = &appv1alpha1.HooksSpec{PreDeploy: &appv1alpha1.HookSpec{Command: []string{"migrate", "up"}}}
+	app.Spec.App.Annotations = map[string]string{"team": "a"}
+	b := newTestBase(t, app)
+	deployments, hooks := &DeploymentDriver{base: b}, &HookDriver{base: b}
+	ctx := context.Background()
+
+	finish := func(conditionType batchv1.JobConditionType) *batchv1.Job {
+		t.Helper()
+		jobs := &batchv1.JobList{}
+		if err := b.Kubernetes.List(ctx, jobs, client.MatchingLabels{hookLabel: preDeployHook}); err != nil {
+			t.Fatal(err)
+		}
+		for i := range jobs.Items {
+			job := &jobs.Items[i]
+			if len(job.Status.Conditions) > 0 {
+				continue
+			}
+			job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+			if err := b.Kubernetes.Status().Update(ctx, job); err != nil {
+				t.Fatal(err)
+			}
+			return job
+		}
+		t.Fatal("no running pre-deploy job")
+		return nil
+	}
+
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	deployment := &v1.Deployment{}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); !errors.IsNotFound(err) {
+		t.Fatalf("deployment created before the pre-deploy hook succeeded: %v", err)
+	}
+	job := finish(batchv1.JobComplete)
+	if command := job.Spec.Template.Spec.Containers[0].Command; len(command) != 2 || command[0] != "migrate" {
+		t.Errorf("hook command = %v, want migrate up", command)
+	}
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	// A removed annotation is a new template too.
+	app.Spec.App.Annotations = nil
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	_ = b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment)
+	if deployment.Spec.Template.Annotations["team"] != "a" {
+		t.Error("annotation removed before the pre-deploy hook succeeded")
+	}
+	finish(batchv1.JobComplete)
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+
+	app.Spec.App.Image = "nginx:1.26"
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	job = finish(batchv1.JobFailed)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-x", Namespace: app.Namespace, Labels: map[string]string{"job-name": job.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "relation users already exists\n"}},
+		}}},
+	}
+	if err := b.Kubernetes.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deployments.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	_ = b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment)
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.25" {
+		t.Errorf("image = %s, want nginx:1.25 while the pre-deploy hook fails", image)
+	}
+	status, err := hooks.Status(ctx, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Reason != "PreDeployFailed" || !strings.Contains(status.Message, "relation users already exists") {
+		t.Errorf("status = %s: %s, want PreDeployFailed with the hook logs", status.Reason, status.Message)
+	}
+
+	// Only the job of the current pod template is kept.
+	if _, err := hooks.Reconcile(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	jobs := &batchv1.JobList{}
+	_ = b.Kubernetes.List(ctx, jobs)
+	if len(jobs.Items) != 1 || jobs.Items[0].Name != job.Name {
+		t.Errorf("%d jobs left, want only %s", len(jobs.Items), job.Name)
+	}
+}
+
+func TestReconcileUnexposesHostsOutsideProjectDomains(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	app.Spec.Ingress.TLS = &appv1alpha1.IngressTLSSpec{}
+	proj := &appv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "team"},
+		Spec:       appv1alpha1.ProjectSpec{AllowedDomains: []string{"example.com"}},
+		Status:     appv1alpha1.ProjectStatus{Namespaces: []string{app.Namespace}},
+	}
+	b := newTestBase(t, app, proj)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config), Projects: true}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []client.Object{&networkingv1.Ingress{ObjectMeta: app.ObjectMeta}, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: "web-tls"}}} {
+		if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The Project stops allowing the host of the live Ingress.
+	proj.Spec.AllowedDomains = []string{"example.org"}
+	if err := a.Kubernetes.Update(ctx, proj); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, projectPolicyCondition); c == nil || c.Reason != "HostNotAllowed" {
+		t.Fatalf("conditions = %v, want HostNotAllowed", app.Status.Conditions)
+	}
+	if c := meta.FindStatusCondition(app.Status.Conditions, "IngressReady"); c != nil {
+		t.Errorf("ingress condition %v is kept for a deleted ingress", c)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &networkingv1.Ingress{}); !errors.IsNotFound(err) {
+		t.Errorf("ingress serving a disallowed host is still live: %v", err)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{}); err != nil {
+		t.Errorf("deployment was removed along with the ingress: %v", err)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: "web-tls"}, &corev1.Secret{}); !errors.IsNotFound(err) {
+		t.Errorf("certificate of the disallowed host is still live: %v", err)
+	}
+}
+
+func TestReconcileChecksPodSecurityLevel(t *testing.T) {
+	app := newTestApplication()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   app.Namespace,
+		Labels: map[string]string{"pod-security.kubernetes.io/enforce": "restricted"},
+	}}
+	b := newTestBase(t, app, ns)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, podSecurityCondition); c == nil || c.Reason != "Violation" {
+		t.Fatalf("conditions = %v, want a PodSecurity violation", app.Status.Conditions)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("deployment violating the namespace level was created: %v", err)
+	}
+
+	app.Spec.Security = &appv1alpha1.SecuritySpec{}
+	if err := a.Kubernetes.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if !meta.IsStatusConditionTrue(app.Status.Conditions, podSecurityCondition) {
+		t.Errorf("conditions = %v, want PodSecurity allowed", app.Status.Conditions)
+	}
+	deployment := &v1.Deployment{}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), deployment); err != nil {
+		t.Fatal(err)
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if sc := container.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("security context = %v, want a read-only root filesystem", sc)
+	}
+	if mounts := container.VolumeMounts; len(mounts) != 1 || mounts[0].MountPath != "/tmp" {
+		t.Errorf("mounts = %v, want a writable /tmp", mounts)
+	}
+}
+
+// forbiddenReader denies every read, like the API server does to an
+// operator without access to Namespaces.
+type forbiddenReader struct{ client.Reader }
+
+func (forbiddenReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return errors.NewForbidden(corev1.Resource("namespaces"), key.Name, fmt.Errorf("access denied"))
+}
+
+func TestReconcileChecksPodSecurityLevelOutsideTheCache(t *testing.T) {
+	app := newTestApplication()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   app.Namespace,
+		Labels: map[string]string{"pod-security.kubernetes.io/enforce": "restricted"},
+	}}
+	// A namespaced cache holds no Namespace: the level is only known to
+	// the API server.
+	b := newTestBase(t, app)
+	a := &ApplicationClient{
+		Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config),
+		Reader: fake.NewClientBuilder().WithScheme(b.Schema).WithObjects(ns).Build(),
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, podSecurityCondition); c == nil || c.Reason != "Violation" {
+		t.Fatalf("conditions = %v, want a PodSecurity violation", app.Status.Conditions)
+	}
+
+	// Without access to the Namespace the check is skipped.
+	a.Reader = forbiddenReader{}
+	if _, err := a.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, podSecurityCondition); c != nil {
+		t.Errorf("PodSecurity condition = %v without access to the namespace", c)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{}); err != nil {
+		t.Errorf("deployment: %v", err)
+	}
+}
+
+func TestReconcileReportsImagePolicyViolation(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Sidecars = []appv1alpha1.SidecarSpec{{ContainerSpec: appv1alpha1.ContainerSpec{Name: "proxy", Image: "quay.io/proxy:1"}}}
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.AllowedRegistries = []string{"docker.io"}
+	b.Config.Set(cfg)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	c := meta.FindStatusCondition(app.Status.Conditions, policyViolationCondition)
+	if c == nil || c.Status != metav1.ConditionTrue || !strings.Contains(c.Message, "quay.io/proxy:1") {
+		t.Errorf("conditions = %v, want a PolicyViolation for the sidecar image", app.Status.Conditions)
+	}
+	if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &v1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("deployment violating the image policy was created: %v", err)
+	}
+}
+
+func TestReconcileSelectsStrictNamespacesOutsideTheCache(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Image = "nginx:latest"
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   app.Namespace,
+		Labels: map[string]string{"environment": "production"},
+	}}
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.ImagePolicy.NamespaceSelector = "environment=production"
+	b.Config.Set(cfg)
+	a := &ApplicationClient{
+		Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config),
+		Reader: fake.NewClientBuilder().WithScheme(b.Schema).WithObjects(ns).Build(),
+	}
+	ctx := context.Background()
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), app)
+	if !meta.IsStatusConditionTrue(app.Status.Conditions, policyViolationCondition) {
+		t.Errorf("conditions = %v, want a PolicyViolation for the latest tag", app.Status.Conditions)
+	}
+}
+
+// fakeRegistry resolves images to digests and repositories to tags, and
+// serves manifests and blobs, from maps.
+type fakeRegistry struct {
+	digests   map[string]string
+	tags      map[string][]string
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+func (r *fakeRegistry) Resolve(_ context.Context, image string, _ registry.Credentials) (string, error) {
+	if digest, ok := r.digests[image]; ok {
+		return digest, nil
+	}
+	return "", fmt.Errorf("image %s not found", image)
+}
+
+func (r *fakeRegistry) Tags(_ context.Context, image string, _ registry.Credentials) ([]string, error) {
+	if tags, ok := r.tags[registry.ParseReference(image).Name()]; ok {
+		return tags, nil
+	}
+	return nil, fmt.Errorf("repository of %s not found", image)
+}
+
+func (r *fakeRegistry) Manifest(_ context.Context, image string, _ registry.Credentials) ([]byte, error) {
+	if m, ok := r.manifests[image]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("manifest %s not found", image)
+}
+
+func (r *fakeRegistry) Blob(_ context.Context, _, digest string, _ registry.Credentials) ([]byte, error) {
+	if b, ok := r.blobs[digest]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("blob %s not found", digest)
+}
+
+// sign stores a cosign-style signature of the digest of image made with
+// key.
+func (r *fakeRegistry) sign(t *testing.T, image, digest string, key *ecdsa.PrivateKey) {
+	payload, err := signature.NewPayload(image, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadDigest := fmt.Sprintf("sha256:%x", sum)
+	r.blobs[payloadDigest] = payload
+	r.manifests[registry.ParseReference(image).Name()+":"+signature.Tag(digest)] = []byte(fmt.Sprintf(
+		`{"layers": [{"mediaType": %q, "digest": %q, "annotations": {%q: %q}}]}`,
+		signature.PayloadType, payloadDigest, signature.Annotation, base64.StdEncoding.EncodeToString(sig)))
+}
+
+func TestReconcilePinsImageDigest(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Image = "nginx:1.25"
+	app.Spec.ImagePinning = &appv1alpha1.ImagePinningSpec{ResolveInterval: &metav1.Duration{Duration: 5 * time.Minute}}
+	b := newTestBase(t, app)
+	resolved := &fakeRegistry{digests: map[string]string{"nginx:1.25": "sha256:aaa"}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config),
+		Registry: resolved, now: func() time.Time { return now }}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	image := func() string {
+		deployment := &v1.Deployment{}
+		if err := a.Kubernetes.Get(ctx, key, deployment); err != nil {
+			t.Fatal(err)
+		}
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	result, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := image(); got != "nginx:1.25@sha256:aaa" {
+		t.Errorf("image = %s, want the pinned digest", got)
+	}
+	if result.RequeueAfter != 5*time.Minute {
+		t.Errorf("requeued after %v, want the resolve interval", result.RequeueAfter)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if app.Status.Image == nil || app.Status.Image.Digest != "sha256:aaa" {
+		t.Errorf("status image = %+v", app.Status.Image)
+	}
+
+	// The tag moves, but is only resolved again once the interval passed.
+	resolved.digests["nginx:1.25"] = "sha256:bbb"
+	now = now.Add(time.Minute)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if got := image(); got != "nginx:1.25@sha256:aaa" {
+		t.Errorf("image = %s, want the digest kept until the interval passed", got)
+	}
+	now = now.Add(5 * time.Minute)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if got := image(); got != "nginx:1.25@sha256:bbb" {
+		t.Errorf("image = %s, want the moved tag rolled out", got)
+	}
+
+	// A new tag that does not resolve holds the rollout back.
+	_ = a.Kubernetes.Get(ctx, key, app)
+	app.Spec.App.Image = "nginx:1.26"
+	if err := a.Kubernetes.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, imagePinnedCondition); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("conditions = %v, want ImagePinned False", app.Status.Conditions)
+	}
+	if got := image(); got != "nginx:1.25@sha256:bbb" {
+		t.Errorf("image = %s, want the unresolved tag held back", got)
+	}
+}
+
+func TestReconcileFollowsImageUpdates(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Image = "nginx:1.25.1"
+	app.Spec.ImageUpdate = &appv1alpha1.ImageUpdateSpec{Semver: "~1.25"}
+	b := newTestBase(t, app)
+	reg := &fakeRegistry{tags: map[string][]string{"nginx": {"1.24.0", "1.25.1", "1.25.3", "1.26.0", "latest"}}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config),
+		Registry: reg, now: func() time.Time { return now }}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	reconcileAt := func(at time.Time) {
+		now = at
+		if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+		_ = a.Kubernetes.Get(ctx, key, app)
+	}
+	image := func() string {
+		deployment := &v1.Deployment{}
+		if err := a.Kubernetes.Get(ctx, key, deployment); err != nil {
+			t.Fatal(err)
+		}
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	reconcileAt(now)
+	if got := image(); got != "nginx:1.25.3" {
+		t.Errorf("image = %s, want the newest tag in range", got)
+	}
+	if app.Spec.App.Image != "nginx:1.25.1" {
+		t.Errorf("spec image = %s, want it left alone without write-back", app.Spec.App.Image)
+	}
+
+	// A new release is only picked up by the next poll.
+	reg.tags["nginx"] = append(reg.tags["nginx"], "1.25.4")
+	reconcileAt(now.Add(time.Minute))
+	if got := image(); got != "nginx:1.25.3" {
+		t.Errorf("image = %s, want no update before the interval passed", got)
+	}
+	reconcileAt(now.Add(defaultImageUpdateInterval))
+	if got := image(); got != "nginx:1.25.4" {
+		t.Errorf("image = %s, want the new release", got)
+	}
+	history := app.Status.ImageUpdate.History
+	if len(history) != 2 || history[0].From != "nginx:1.25.3" || history[0].To != "nginx:1.25.4" || history[1].From != "nginx:1.25.1" {
+		t.Errorf("history = %+v", history)
+	}
+
+	app.Spec.ImageUpdate.WriteBack = true
+	if err := a.Kubernetes.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAt(now.Add(defaultImageUpdateInterval))
+	if app.Spec.App.Image != "nginx:1.25.4" {
+		t.Errorf("spec image = %s, want the selected tag written back", app.Spec.App.Image)
+	}
+	if got := image(); got != "nginx:1.25.4" {
+		t.Errorf("image = %s after write-back", got)
+	}
+	if c := meta.FindStatusCondition(app.Status.Conditions, imageUpdateCondition); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("conditions = %v, want ImageUpdate True", app.Status.Conditions)
+	}
+}
+
+func TestReconcileVerifiesImageSignature(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.App.Image = "nginx:1.25"
+	b := newTestBase(t, app)
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&signer.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.ImageSignatures.Keys = []config.SignatureKey{{Name: "release", PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}}
+	b.Config.Set(cfg)
+	reg := &fakeRegistry{digests: map[string]string{"nginx:1.25": "sha256:aaa"}, manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config), Registry: reg}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, signatureCondition); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("conditions = %v, want SignatureVerificationFailed for the unsigned image", app.Status.Conditions)
+	}
+	if err := a.Kubernetes.Get(ctx, key, &v1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("deployment of an unsigned image was created: %v", err)
+	}
+
+	reg.sign(t, "nginx:1.25", "sha256:aaa", signer)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, signatureCondition); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("conditions = %v, want the signature verified", app.Status.Conditions)
+	}
+	if s := app.Status.Signature; s == nil || s.Image != "nginx@sha256:aaa" || s.Key != "release" {
+		t.Errorf("status signature = %+v", s)
+	}
+	deployment := &v1.Deployment{}
+	if err := a.Kubernetes.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if got := deployment.Spec.Template.Spec.Containers[0].Image; got != "nginx:1.25@sha256:aaa" {
+		t.Errorf("image = %s, want the verified digest", got)
+	}
+
+	// A tag moved to an unsigned digest is not rolled out.
+	reg.digests["nginx:1.25"] = "sha256:bbb"
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, signatureCondition); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("conditions = %v, want the moved tag not verified", app.Status.Conditions)
+	}
+	_ = a.Kubernetes.Get(ctx, key, deployment)
+	if got := deployment.Spec.Template.Spec.Containers[0].Image; got != "nginx:1.25@sha256:aaa" {
+		t.Errorf("image = %s after the tag moved, want the verified digest", got)
+	}
+}
+
+func TestRegistryCredentialsAreSharedAndRefreshed(t *testing.T) {
+	web, api := newTestApplication(), newTestApplication()
+	api.Name, api.UID = "api", "uid-api"
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "regcred", Namespace: "cloud-club-operator-system"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)},
+	}
+	b := newTestBase(t, web, api, source)
+	cfg := config.Default()
+	cfg.RegistryCredentials = []config.RegistryCredential{{SecretRef: corev1.SecretReference{Namespace: source.Namespace, Name: source.Name}, Name: "cloudclub-registry"}}
+	b.Config.Set(cfg)
+	d := &RegistryCredentialDriver{base: b}
+	ctx := context.Background()
+	copyKey := client.ObjectKey{Namespace: "default", Name: "cloudclub-registry"}
+
+	for _, app := range []*appv1alpha1.Application{web, api} {
+		if _, err := d.Reconcile(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copied := &corev1.Secret{}
+	if err := d.Kubernetes.Get(ctx, copyKey, copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.Type != corev1.SecretTypeDockerConfigJson || len(copied.OwnerReferences) != 2 {
+		t.Errorf("copy = %+v, want the source type owned by both applications", copied)
+	}
+	template, err := d.podTemplate(ctx, web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets := template.Spec.ImagePullSecrets; len(secrets) != 1 || secrets[0].Name != "cloudclub-registry" {
+		t.Errorf("pull secrets = %v, want the copy", secrets)
+	}
+
+	source.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths": {"ghcr.io": {}}}`)
+	if err := d.Kubernetes.Update(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Reconcile(ctx, web); err != nil {
+		t.Fatal(err)
+	}
+	_ = d.Kubernetes.Get(ctx, copyKey, copied)
+	if string(copied.Data[corev1.DockerConfigJsonKey]) != `{"auths": {"ghcr.io": {}}}` {
+		t.Errorf("copy data = %s, want it refreshed from the source", copied.Data[corev1.DockerConfigJsonKey])
+	}
+
+	// The copy stays until the last application releases it.
+	if err := d.Delete(ctx, web); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Kubernetes.Get(ctx, copyKey, copied); err != nil || len(copied.OwnerReferences) != 1 {
+		t.Errorf("copy after releasing one owner = %v, %v", copied.OwnerReferences, err)
+	}
+	if err := d.Delete(ctx, api); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Kubernetes.Get(ctx, copyKey, copied); !errors.IsNotFound(err) {
+		t.Errorf("copy without owners was kept: %v", err)
+	}
+}
+
+func TestRegistryCredentialsAreReadOutsideTheCache(t *testing.T) {
+	web, api := newTestApplication(), newTestApplication()
+	api.Name, api.UID = "api", "uid-api"
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "regcred", Namespace: "cloud-club-operator-system"},
+		Type:       corev1.SecretTypeDockercfg,
+		Data:       map[string][]byte{corev1.DockerConfigKey: []byte(`{}`)},
+	}
+	// The operator namespace is not watched: only the reader holds the source.
+	b := newTestBase(t, web, api)
+	reader := fake.NewClientBuilder().WithScheme(b.Schema).WithObjects(source).Build()
+	b.Kubernetes, b.Reader = immutableSecretType{b.Kubernetes}, reader
+	cfg := config.Default()
+	cfg.RegistryCredentials = []config.RegistryCredential{{SecretRef: corev1.SecretReference{Namespace: source.Namespace, Name: source.Name}}}
+	b.Config.Set(cfg)
+	d := &RegistryCredentialDriver{base: b}
+	ctx := context.Background()
+	copyKey := client.ObjectKey{Namespace: "default", Name: "regcred"}
+
+	for _, app := range []*appv1alpha1.Application{web, api} {
+		if _, err := d.Reconcile(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copied := &corev1.Secret{}
+	if err := d.Kubernetes.Get(ctx, copyKey, copied); err != nil || copied.Type != corev1.SecretTypeDockercfg {
+		t.Fatalf("copy = %+v, %v, want one read from the reader", copied, err)
+	}
+
+	// The type of a Secret is immutable, so a new type recreates the copy.
+	source.Type = corev1.SecretTypeDockerConfigJson
+	source.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)}
+	if err := reader.Update(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Reconcile(ctx, web); err != nil {
+		t.Fatal(err)
+	}
+	recreated := &corev1.Secret{}
+	if err := d.Kubernetes.Get(ctx, copyKey, recreated); err != nil {
+		t.Fatal(err)
+	}
+	if recreated.Type != corev1.SecretTypeDockerConfigJson || len(recreated.OwnerReferences) != 2 || recreated.Labels[registryCredentialLabel] == "" {
+		t.Errorf("recreated copy = %+v, want the new type, both owners and the copy label", recreated)
+	}
+}
+
+// immutableSecretType rejects updates of the type of Secrets like the API
+// server does.
+type immutableSecretType struct {
+	client.Client
+}
+
+func (c immutableSecretType) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if secret, ok := obj.(*corev1.Secret); ok {
+		current := &corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(secret), current); err == nil && current.Type != secret.Type {
+			return errors.NewBadRequest("secret type is immutable")
+		}
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestReconcileIssuesAndRotatesIngressCertificate(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	app.Spec.Ingress.TLS = &appv1alpha1.IngressTLSSpec{}
+	b := newTestBase(t, app)
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	secretKey := client.ObjectKey{Namespace: "default", Name: "web-tls"}
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	ingress := &networkingv1.Ingress{}
+	if err := a.Kubernetes.Get(ctx, key, ingress); err != nil {
+		t.Fatal(err)
+	}
+	if tls := ingress.Spec.TLS; len(tls) != 1 || tls[0].SecretName != "web-tls" || len(tls[0].Hosts) != 1 || tls[0].Hosts[0] != "web.example.com" {
+		t.Errorf("ingress tls = %+v, want web-tls for web.example.com", tls)
+	}
+	issued := &corev1.Secret{}
+	if err := a.Kubernetes.Get(ctx, secretKey, issued); err != nil {
+		t.Fatal(err)
+	}
+	if issued.Type != corev1.SecretTypeTLS || !metav1.IsControlledBy(issued, app) {
+		t.Errorf("secret = %+v, want a TLS secret controlled by the application", issued)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, certificateCondition); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("conditions = %v, want CertificateReady", app.Status.Conditions)
+	}
+	cert := app.Status.Certificate
+	if cert == nil || cert.Issuer != IssuerLocal || cert.NotAfter.Time.Before(time.Now().Add(89*24*time.Hour)) {
+		t.Fatalf("status certificate = %+v, want a local certificate valid for 90 days", cert)
+	}
+
+	// A certificate outside its renewal window is kept.
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	kept := &corev1.Secret{}
+	_ = a.Kubernetes.Get(ctx, secretKey, kept)
+	if string(kept.Data[corev1.TLSCertKey]) != string(issued.Data[corev1.TLSCertKey]) {
+		t.Error("certificate was issued again before it was due")
+	}
+
+	cfg := config.Default()
+	cfg.Ingress.TLS.RenewBefore = metav1.Duration{Duration: 100 * 24 * time.Hour}
+	b.Config.Set(cfg)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	rotated := &corev1.Secret{}
+	_ = a.Kubernetes.Get(ctx, secretKey, rotated)
+	if string(rotated.Data[corev1.TLSCertKey]) == string(issued.Data[corev1.TLSCertKey]) {
+		t.Error("certificate due for renewal was not rotated")
+	}
+
+	// An existing secret is served as is, and the issued one is removed.
+	_ = a.Kubernetes.Get(ctx, key, app)
+	app.Spec.Ingress.TLS.SecretName = "wildcard-tls"
+	if err := a.Kubernetes.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Kubernetes.Get(ctx, secretKey, &corev1.Secret{}); !errors.IsNotFound(err) {
+		t.Errorf("issued secret was kept for an existing one: %v", err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, ingress)
+	if tls := ingress.Spec.TLS; len(tls) != 1 || tls[0].SecretName != "wildcard-tls" {
+		t.Errorf("ingress tls = %+v, want wildcard-tls", tls)
+	}
+}
+
+func TestReconcileRequeuesForCertificateRenewal(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	app.Spec.Ingress.TLS = &appv1alpha1.IngressTLSSpec{}
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.Reconcile.ResyncPeriod = metav1.Duration{}
+	b.Config.Set(cfg)
+	now := time.Now()
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config),
+		now: func() time.Time { return now }}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	secretKey := client.ObjectKey{Namespace: "default", Name: "web-tls"}
+
+	result, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Certificates are valid for 90 days and renewed 30 days ahead.
+	if want := 60 * 24 * time.Hour; result.RequeueAfter <= want-time.Minute || result.RequeueAfter > want {
+		t.Errorf("requeue after = %v, want the renewal in %v", result.RequeueAfter, want)
+	}
+	issued := &corev1.Secret{}
+	if err := a.Kubernetes.Get(ctx, secretKey, issued); err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is renewed by the clock of the client.
+	now = now.Add(result.RequeueAfter)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	rotated := &corev1.Secret{}
+	_ = a.Kubernetes.Get(ctx, secretKey, rotated)
+	if string(rotated.Data[corev1.TLSCertKey]) == string(issued.Data[corev1.TLSCertKey]) {
+		t.Error("certificate due for renewal was not rotated")
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if cert := app.Status.Certificate; cert == nil || cert.NotAfter.Time.Before(now.Add(89*24*time.Hour)) {
+		t.Errorf("status certificate = %+v, want one valid for 90 days from the renewal", cert)
+	}
+}
+
+func TestHTTPRouteDriverRendersGatewayRoutes(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	canary, stable := int32(10), int32(90)
+	app.Spec.Ingress.Rules.Paths = []appv1alpha1.IngressPath{
+		{Path: "/api", Headers: map[string]string{"X-Beta": "true"}},
+		{Backends: []appv1alpha1.WeightedBackend{{ServiceName: "web", Weight: &stable}, {ServiceName: "web-canary", Weight: &canary}}},
+	}
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.Ingress.Mode = ModeGateway
+	cfg.Ingress.Gateway = config.GatewayRef{Name: "public", Namespace: "gateways"}
+	b.Config.Set(cfg)
+	routes, ingresses := &HTTPRouteDriver{base: b}, &IngressDriver{base: b}
+	ctx := context.Background()
+	reconcile(t, ingresses, app)
+	reconcile(t, routes, app)
+
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &networkingv1.Ingress{}); !errors.IsNotFound(err) {
+		t.Errorf("ingress was created in gateway mode: %v", err)
+	}
+	route := routes.Object().(*unstructured.Unstructured)
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), route); err != nil {
+		t.Fatal(err)
+	}
+	if parent, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs"); len(parent) != 1 || parent[0].(map[string]interface{})["namespace"] != "gateways" {
+		t.Errorf("parentRefs = %v, want the gateway of the config", parent)
+	}
+	if hosts, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames"); len(hosts) != 1 || hosts[0] != "web.example.com" {
+		t.Errorf("hostnames = %v", hosts)
+	}
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	if len(rules) != 2 {
+		t.Fatalf("rules = %v, want one per path", rules)
+	}
+	headers, _, _ := unstructured.NestedSlice(rules[0].(map[string]interface{})["matches"].([]interface{})[0].(map[string]interface{}), "headers")
+	if len(headers) != 1 || headers[0].(map[string]interface{})["name"] != "X-Beta" {
+		t.Errorf("header matches = %v", headers)
+	}
+	backends, _, _ := unstructured.NestedSlice(rules[1].(map[string]interface{}), "backendRefs")
+	if len(backends) != 2 || backends[1].(map[string]interface{})["name"] != "web-canary" || backends[1].(map[string]interface{})["weight"] != int64(10) {
+		t.Errorf("backendRefs = %v, want the weighted canary", backends)
+	}
+
+	// Rendering the same route again writes nothing.
+	version := route.GetResourceVersion()
+	reconcile(t, routes, app)
+	_ = b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), route)
+	if route.GetResourceVersion() != version {
+		t.Error("unchanged route was updated")
+	}
+
+	// An Application choosing Ingress moves back from the gateway.
+	app.Spec.Ingress.Mode = ModeIngress
+	reconcile(t, ingresses, app)
+	reconcile(t, routes, app)
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), routes.Object()); !errors.IsNotFound(err) {
+		t.Errorf("route kept in ingress mode: %v", err)
+	}
+	if err := b.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), &networkingv1.Ingress{}); err != nil {
+		t.Errorf("ingress in ingress mode: %v", err)
+	}
+}
+
+func TestReconcileReportsMissingGatewayAPI(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.example.com"
+	app.Spec.Ingress.Mode = ModeGateway
+	enabled := true
+	app.Spec.Scheduler.PodDisruptionBudgetSpec.Enabled = &enabled
+	b := newTestBase(t, app)
+	cfg := config.Default()
+	cfg.Ingress.Gateway = config.GatewayRef{Name: "public", Namespace: "gateways"}
+	b.Config.Set(cfg)
+	b.Kubernetes = noGatewayAPI{b.Kubernetes}
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: Builtin(b.Kubernetes, nil, b.Schema, b.Config)}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	// The drivers after the route still run.
+	if err := a.Kubernetes.Get(ctx, key, &policyv1.PodDisruptionBudget{}); err != nil {
+		t.Errorf("pod disruption budget was not applied: %v", err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, httpRouteCondition); c == nil || c.Reason != "NotInstalled" {
+		t.Errorf("conditions = %v, want HTTPRouteReady NotInstalled", app.Status.Conditions)
+	}
+}
+
+// noGatewayAPI fails requests for Gateway API kinds like the API server
+// does when their CRDs are not installed.
+type noGatewayAPI struct {
+	client.Client
+}
+
+func (c noGatewayAPI) noMatch(obj runtime.Object) error {
+	if gvk := obj.GetObjectKind().GroupVersionKind(); gvk.Group == httpRoute.Group {
+		return &meta.NoKindMatchError{GroupKind: gvk.GroupKind(), SearchedVersions: []string{gvk.Version}}
+	}
+	return nil
+}
+
+func (c noGatewayAPI) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c noGatewayAPI) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.noMatch(obj); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcilePublishesDNSRecords(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.apps.example.com"
+	b := newTestBase(t, app)
+	zone := &dns.ZoneFile{Path: filepath.Join(t.TempDir(), "zone"), Origin: "apps.example.com"}
+	drivers := Builtin(b.Kubernetes, nil, b.Schema, b.Config)
+	for _, d := range drivers {
+		if d, ok := d.(*DNSDriver); ok {
+			d.Provider = zone
+		}
+	}
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: drivers}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if c := meta.FindStatusCondition(app.Status.Conditions, dnsCondition); c == nil || c.Reason != "Pending" {
+		t.Errorf("conditions = %v, want DNSRecordReady pending the load balancer", app.Status.Conditions)
+	}
+
+	ingress := &networkingv1.Ingress{}
+	_ = a.Kubernetes.Get(ctx, key, ingress)
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.7"}}
+	if err := a.Kubernetes.Status().Update(ctx, ingress); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := zone.Records(ctx, "web.apps.example.com")
+	if len(records) != 1 || records[0].Type != dns.TypeA || records[0].Targets[0] != "203.0.113.7" {
+		t.Errorf("records = %+v, want an A record of the load balancer", records)
+	}
+	_ = a.Kubernetes.Get(ctx, key, app)
+	if s := app.Status.DNS; s == nil || s.Name != "web.apps.example.com" || s.Type != dns.TypeA {
+		t.Errorf("status dns = %+v", s)
+	}
+	if c := meta.FindStatusCondition(app.Status.Conditions, dnsCondition); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("conditions = %v, want DNSRecordReady", app.Status.Conditions)
+	}
+
+	// A name published by someone else is left alone.
+	api := newTestApplication()
+	api.Name, api.UID = "api", "uid-api"
+	api.Spec.Ingress.Enabled = true
+	api.Spec.App.IngressHost = "www.apps.example.com"
+	if err := a.Kubernetes.Create(ctx, api); err != nil {
+		t.Fatal(err)
+	}
+	if err := zone.Replace(ctx, dns.Record{Name: "www.apps.example.com", Type: dns.TypeA, Targets: []string{"192.0.2.1"}, TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	apiKey := client.ObjectKeyFromObject(api)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: apiKey}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, apiKey, ingress)
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.8"}}
+	_ = a.Kubernetes.Status().Update(ctx, ingress)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: apiKey}); err != nil {
+		t.Fatal(err)
+	}
+	_ = a.Kubernetes.Get(ctx, apiKey, api)
+	if c := meta.FindStatusCondition(api.Status.Conditions, dnsCondition); c == nil || c.Reason != "NotOwned" {
+		t.Errorf("conditions = %v, want DNSRecordReady NotOwned", api.Status.Conditions)
+	}
+	if records, _ := zone.Records(ctx, "www.apps.example.com"); len(records) != 1 || records[0].Targets[0] != "192.0.2.1" {
+		t.Errorf("records of another owner = %+v, want them untouched", records)
+	}
+
+	// Deleting the Application removes its records before it goes.
+	if err := a.Kubernetes.Delete(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := zone.Records(ctx, "web.apps.example.com"); len(records) != 0 {
+		t.Errorf("records after deletion = %+v", records)
+	}
+	if err := a.Kubernetes.Get(ctx, key, app); !errors.IsNotFound(err) {
+		t.Errorf("application kept after its records were removed: %v", err)
+	}
+}
+
+func TestReconcileChecksPublishedDNSRecordsOnResync(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.Ingress.Enabled = true
+	app.Spec.App.IngressHost = "web.apps.example.com"
+	b := newTestBase(t, app)
+	zone := &countingProvider{Provider: &dns.ZoneFile{Path: filepath.Join(t.TempDir(), "zone"), Origin: "apps.example.com"}}
+	drivers := Builtin(b.Kubernetes, nil, b.Schema, b.Config)
+	for _, d := range drivers {
+		if d, ok := d.(*DNSDriver); ok {
+			d.Provider = zone
+		}
+	}
+	now := time.Now()
+	a := &ApplicationClient{Kubernetes: b.Kubernetes, Schema: b.Schema, Config: b.Config, Drivers: drivers, now: func() time.Time { return now }}
+	ctx := context.Background()
+	key := client.ObjectKeyFromObject(app)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	ingress := &networkingv1.Ingress{}
+	_ = a.Kubernetes.Get(ctx, key, ingress)
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "203.0.113.7"}}
+	if err := a.Kubernetes.Status().Update(ctx, ingress); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if zone.calls == 0 {
+		t.Fatal("record was published without asking the provider")
+	}
+
+	// The published record is not checked again before the resync.
+	zone.calls = 0
+	now = now.Add(time.Minute)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if zone.calls != 0 {
+		t.Errorf("provider was called %d times for a published record", zone.calls)
+	}
+
+	// A record removed behind the operator's back is restored on resync.
+	if err := zone.Delete(ctx, "web.apps.example.com", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(a.Config.Get().Reconcile.ResyncPeriod.Duration)
+	if _, err := a.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := zone.Records(ctx, "web.apps.example.com"); len(records) != 1 || records[0].Targets[0] != "203.0.113.7" {
+		t.Errorf("records after resync = %+v, want the A record restored", records)
+	}
+}
+
+func TestDNSDriverRejectsRFC2136WithoutZone(t *testing.T) {
+	b := newTestBase(t)
+	cfg := config.Default()
+	cfg.DNS.Provider = "rfc2136"
+	cfg.DNS.RFC2136.Server = "192.0.2.53:53"
+	b.Config.Set(cfg)
+	d := &DNSDriver{base: b}
+	if _, err := d.provider(context.Background()); err == nil {
+		t.Error("rfc2136 provider was built without a zone")
+	}
+}
+
+// countingProvider counts the calls to its Provider.
+type countingProvider struct {
+	dns.Provider
+	calls int
+}
+
+func (p *countingProvider) Records(ctx context.Context, name string) ([]dns.Record, error) {
+	p.calls++
+	return p.Provider.Records(ctx, name)
+}
+
+func (p *countingProvider) Replace(ctx context.Context, r dns.Record) error {
+	p.calls++
+	return p.Provider.Replace(ctx, r)
+}
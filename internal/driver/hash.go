@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SpecHashAnnotation records on each child the hash of the desired object it
+// was last written from. A reconcile whose rendered object hashes the same,
+// and whose live object has not drifted, makes no API writes.
+const SpecHashAnnotation = "app.cloudclub.com/spec-hash"
+
+// specHash hashes the rendered object before ownership and the hash
+// annotation itself are added.
+func specHash(obj client.Object) (string, error) {
+	return hashOf(obj)
+}
+
+// hashOf returns a short hex digest of the JSON encoding of v.
+func hashOf(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+type writesKey struct{}
+
+// WithWriteCounter returns a context in which drivers count the API writes
+// they make, and the counter they add to.
+func WithWriteCounter(ctx context.Context) (context.Context, *int64) {
+	var writes int64
+	return context.WithValue(ctx, writesKey{}, &writes), &writes
+}
+
+func recordWrite(ctx context.Context) {
+	if writes, ok := ctx.Value(writesKey{}).(*int64); ok {
+		atomic.AddInt64(writes, 1)
+	}
+}
@@ -0,0 +1,385 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/project"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	preDeployHook  = "pre-deploy"
+	postDeployHook = "post-deploy"
+	// hookLabel marks the Jobs of hooks and their pods with the hook.
+	hookLabel          = "app.cloudclub.com/hook"
+	hooksCondition     = "DeployHooks"
+	defaultHookTimeout = 10 * time.Minute
+	// maxHookLogs bounds the tail of the logs of a failed hook recorded in
+	// the status.
+	maxHookLogs = 1024
+)
+
+// HookDriver reports on the hook Jobs of Applications and deletes those
+// run for earlier pod templates. The Jobs are started by the workload
+// drivers, which hold back a rollout until its pre-deploy Job succeeded.
+type HookDriver struct {
+	base
+}
+
+func (d *HookDriver) Name() string {
+	return "hooks"
+}
+
+func (d *HookDriver) Object() client.Object {
+	return &batchv1.Job{}
+}
+
+// Render returns nil: the Jobs are started by the workload drivers.
+func (d *HookDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	return nil, nil
+}
+
+func (d *HookDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	if hookOf(app, preDeployHook) == nil && hookOf(app, postDeployHook) == nil {
+		return false, d.deleteJobs(ctx, app, nil)
+	}
+	wanted, err := d.currentJobs(ctx, app)
+	if err != nil {
+		return true, err
+	}
+	keep := map[string]bool{}
+	for _, name := range wanted {
+		keep[name] = true
+	}
+	return true, d.deleteJobs(ctx, app, keep)
+}
+
+// currentJobs returns the names of the hook Jobs of the current pod
+// template of app by hook.
+func (d *HookDriver) currentJobs(ctx context.Context, app *appv1alpha1.Application) (map[string]string, error) {
+	template, err := d.workloadTemplate(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, phase := range []string{preDeployHook, postDeployHook} {
+		if hook := hookOf(app, phase); hook != nil {
+			if names[phase], err = hookJobName(app, phase, hook, template); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return names, nil
+}
+
+// deleteJobs deletes the hook Jobs owned by app that are not in keep,
+// along with their pods.
+func (d *HookDriver) deleteJobs(ctx context.Context, app *appv1alpha1.Application, keep map[string]bool) error {
+	jobs := &batchv1.JobList{}
+	if err := d.Kubernetes.List(ctx, jobs, client.InNamespace(app.Namespace),
+		client.MatchingLabels{applicationLabel: app.Name}, client.HasLabels{hookLabel}); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if keep[job.Name] || !metav1.IsControlledBy(job, app) {
+			continue
+		}
+		log.Info(ctx, "deleting hook", zap.String("job", job.Name))
+		recordWrite(ctx)
+		if err := client.IgnoreNotFound(d.Kubernetes.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *HookDriver) Compare(desired, current client.Object) bool {
+	return true
+}
+
+func (d *HookDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	_, err := d.Reconcile(ctx, app)
+	return err
+}
+
+func (d *HookDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.deleteJobs(ctx, app, nil)
+}
+
+// Status reports the first hook of the current pod template that is
+// running or failed, with the tail of its logs on failure.
+func (d *HookDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	names, err := d.currentJobs(ctx, app)
+	if err != nil {
+		return condition(hooksCondition, false, "RenderFailed", err.Error()), err
+	}
+	ran := false
+	for _, phase := range []string{preDeployHook, postDeployHook} {
+		name, ok := names[phase]
+		if !ok {
+			continue
+		}
+		reason := hookReason(phase)
+		job := &batchv1.Job{}
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, job)
+		if errors.IsNotFound(err) {
+			if phase == postDeployHook && ran {
+				return condition(hooksCondition, false, reason+"Pending", "waiting for the rollout to finish"), nil
+			}
+			continue
+		}
+		if err != nil {
+			return condition(hooksCondition, false, reason+"Failed", err.Error()), err
+		}
+		ran = true
+		switch {
+		case jobFinished(job, batchv1.JobFailed):
+			logs, err := d.hookLogs(ctx, job)
+			if err != nil {
+				return condition(hooksCondition, false, reason+"Failed", err.Error()), err
+			}
+			return condition(hooksCondition, false, reason+"Failed", fmt.Sprintf("job %s failed: %s", job.Name, logs)), nil
+		case !jobFinished(job, batchv1.JobComplete):
+			return condition(hooksCondition, false, reason+"Running", fmt.Sprintf("job %s is running", job.Name)), nil
+		}
+	}
+	if !ran {
+		return condition(hooksCondition, true, "NoRollout", "no hook ran for the current pod template"), nil
+	}
+	return condition(hooksCondition, true, "Succeeded", "the hooks of the current pod template succeeded"), nil
+}
+
+func (d *HookDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*batchv1.Job), new.(*batchv1.Job)
+	return o.Status.Active != n.Status.Active ||
+		o.Status.Succeeded != n.Status.Succeeded ||
+		o.Status.Failed != n.Status.Failed
+}
+
+// hookLogs returns the tail of the logs of the last failed pod of job. The
+// hook container falls back to its logs for the termination message, which
+// spares the operator reading pod logs.
+func (d *HookDriver) hookLogs(ctx context.Context, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	if err := d.Kubernetes.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", err
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[j].CreationTimestamp.Before(&pods.Items[i].CreationTimestamp)
+	})
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if t := status.State.Terminated; t != nil && t.ExitCode != 0 {
+				logs := strings.TrimSpace(t.Message)
+				if len(logs) > maxHookLogs {
+					logs = "..." + logs[len(logs)-maxHookLogs:]
+				}
+				if logs == "" {
+					logs = fmt.Sprintf("exit code %d", t.ExitCode)
+				}
+				return logs, nil
+			}
+		}
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed {
+			return c.Message, nil
+		}
+	}
+	return "no logs", nil
+}
+
+func hookOf(app *appv1alpha1.Application, phase string) *appv1alpha1.HookSpec {
+	if app.Spec.Hooks == nil {
+		return nil
+	}
+	if phase == preDeployHook {
+		return app.Spec.Hooks.PreDeploy
+	}
+	return app.Spec.Hooks.PostDeploy
+}
+
+// hookReason is the condition reason prefix of the hook.
+func hookReason(phase string) string {
+	if phase == preDeployHook {
+		return "PreDeploy"
+	}
+	return "PostDeploy"
+}
+
+// hookJobName names the Job of hook for the pod template. A new Job runs
+// whenever either changes.
+func hookJobName(app *appv1alpha1.Application, phase string, hook *appv1alpha1.HookSpec, template corev1.PodTemplateSpec) (string, error) {
+	revision, err := hashOf(struct {
+		Template corev1.PodTemplateSpec
+		Hook     *appv1alpha1.HookSpec
+	}{template, hook})
+	if err != nil {
+		return "", err
+	}
+	// Job names end up in the job-name label of their pods.
+	name := app.Name
+	if max := 63 - len(phase) - len(revision) - 2; len(name) > max {
+		name = strings.TrimRight(name[:max], "-.")
+	}
+	return name + "-" + phase + "-" + revision, nil
+}
+
+func jobFinished(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// preDeploy runs the pre-deploy hook of app when desired, the workload
+// rendered by d, changes the pod template of the live workload. It reports
+// whether the rollout may proceed. A failed hook is not retried for the same
+// template and hook: the rollout is held back until either changes or the
+// Job is deleted.
+func (b *base) preDeploy(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver, desired client.Object) (bool, error) {
+	hook := hookOf(app, preDeployHook)
+	if hook == nil {
+		return true, nil
+	}
+	template := podTemplateOf(desired)
+	current := d.Object()
+	found, err := b.get(ctx, app, current)
+	if err != nil {
+		return false, err
+	}
+	if found && sameTemplate(desired, current) {
+		return true, nil
+	}
+	job, err := b.runHook(ctx, app, preDeployHook, hook, *template)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case jobFinished(job, batchv1.JobComplete):
+		return true, nil
+	case jobFinished(job, batchv1.JobFailed):
+		log.Debug(ctx, "pre-deploy hook failed, holding back the rollout", zap.String("job", job.Name))
+	default:
+		log.Debug(ctx, "waiting for the pre-deploy hook", zap.String("job", job.Name))
+	}
+	return false, nil
+}
+
+// postDeploy runs the post-deploy hook of app once the live workload has
+// rolled out the pod template of desired.
+func (b *base) postDeploy(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver, desired client.Object) error {
+	hook := hookOf(app, postDeployHook)
+	if hook == nil {
+		return nil
+	}
+	template := podTemplateOf(desired)
+	current := d.Object()
+	found, err := b.get(ctx, app, current)
+	if err != nil || !found || !workloadReady(current) || !sameTemplate(desired, current) {
+		return err
+	}
+	_, err = b.runHook(ctx, app, postDeployHook, hook, *template)
+	return err
+}
+
+// runHook returns the Job of hook for template, starting it when it does
+// not exist yet.
+func (b *base) runHook(ctx context.Context, app *appv1alpha1.Application, phase string, hook *appv1alpha1.HookSpec, template corev1.PodTemplateSpec) (*batchv1.Job, error) {
+	name, err := hookJobName(app, phase, hook, template)
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{}
+	err = b.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: name}, job)
+	if !errors.IsNotFound(err) {
+		return job, err
+	}
+	job = b.renderHookJob(ctx, app, phase, hook, template)
+	job.Name = name
+	if err := ctrl.SetControllerReference(app, job, b.Schema); err != nil {
+		return nil, err
+	}
+	log.Info(ctx, "starting "+phase+" hook", zap.String("job", name))
+	recordWrite(ctx)
+	return job, b.Kubernetes.Create(ctx, job)
+}
+
+// renderHookJob runs the application container of template with the
+// command of hook. Sidecars, init containers and probes are dropped, and
+// the pods are labelled apart from the application so no Service selects
+// them.
+func (b *base) renderHookJob(ctx context.Context, app *appv1alpha1.Application, phase string, hook *appv1alpha1.HookSpec, template corev1.PodTemplateSpec) *batchv1.Job {
+	cfg := b.Config.Get()
+	spec := template.Spec.DeepCopy()
+	container := spec.Containers[0]
+	if hook.Image != "" {
+		container.Image = hook.Image
+	}
+	if hook.Command != nil {
+		container.Command = hook.Command
+	}
+	if hook.Args != nil {
+		container.Args = hook.Args
+	}
+	if hook.Resources != nil {
+		container.Resources = *hook.Resources
+	}
+	container.Env = append(container.Env, hook.Env...)
+	container.Ports = nil
+	container.Lifecycle = nil
+	container.StartupProbe, container.LivenessProbe, container.ReadinessProbe = nil, nil, nil
+	container.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+	// Volume claim templates of a StatefulSet are not available to Jobs.
+	volumes := map[string]bool{}
+	for _, v := range spec.Volumes {
+		volumes[v.Name] = true
+	}
+	mounts := container.VolumeMounts[:0]
+	for _, m := range container.VolumeMounts {
+		if volumes[m.Name] {
+			mounts = append(mounts, m)
+		}
+	}
+	container.VolumeMounts = mounts
+	spec.Containers = []corev1.Container{container}
+	spec.InitContainers = nil
+	spec.RestartPolicy = corev1.RestartPolicyNever
+
+	timeout := defaultHookTimeout
+	if hook.Timeout != nil {
+		timeout = hook.Timeout.Duration
+	}
+	deadline := int64(timeout.Seconds())
+	labels := merge(cfg.DefaultLabels, project.DefaultLabels(ctx), map[string]string{applicationLabel: app.Name, hookLabel: phase})
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   app.Namespace,
+			Labels:      labels,
+			Annotations: merge(cfg.DefaultAnnotations),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          hook.BackoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: template.Annotations},
+				Spec:       *spec,
+			},
+		},
+	}
+}
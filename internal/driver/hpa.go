@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type HPADriver struct {
+	base
+}
+
+func (d *HPADriver) Name() string {
+	return "hpa"
+}
+
+func (d *HPADriver) Object() client.Object {
+	return &autoscalingv2.HorizontalPodAutoscaler{}
+}
+
+func (d *HPADriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	spec := app.Spec.Scheduler.HPA
+	if !spec.Enabled {
+		return nil, nil
+	}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       workloadKind(app),
+				Name:       app.Name,
+			},
+			MinReplicas: spec.MinReplicas,
+			MaxReplicas: spec.MaxReplicas,
+		},
+	}
+	if spec.TargetCPUUtilizationPercentage != nil {
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, utilizationMetric(corev1.ResourceCPU, *spec.TargetCPUUtilizationPercentage))
+	}
+	if spec.TargetMemoryUtilizationPercentage != nil {
+		hpa.Spec.Metrics = append(hpa.Spec.Metrics, utilizationMetric(corev1.ResourceMemory, *spec.TargetMemoryUtilizationPercentage))
+	}
+	return hpa, nil
+}
+
+func (d *HPADriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*autoscalingv2.HorizontalPodAutoscaler), current.(*autoscalingv2.HorizontalPodAutoscaler)
+	return equality.Semantic.DeepDerivative(want.Spec, have.Spec)
+}
+
+func (d *HPADriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*autoscalingv2.HorizontalPodAutoscaler), &autoscalingv2.HorizontalPodAutoscaler{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Spec = want.Spec
+	})
+}
+
+func (d *HPADriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *HPADriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	found, err := d.get(ctx, app, hpa)
+	if err != nil || !found {
+		return condition("AutoscalerReady", false, "NotFound", "horizontal pod autoscaler does not exist"), err
+	}
+	for _, c := range hpa.Status.Conditions {
+		if c.Type == autoscalingv2.ScalingActive && c.Status != corev1.ConditionTrue {
+			return condition("AutoscalerReady", false, c.Reason, c.Message), nil
+		}
+	}
+	return condition("AutoscalerReady", true, "ScalingActive",
+		fmt.Sprintf("%d current replicas", hpa.Status.CurrentReplicas)), nil
+}
+
+func utilizationMetric(name corev1.ResourceName, target int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &target,
+			},
+		},
+	}
+}
+
+func (d *HPADriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*autoscalingv2.HorizontalPodAutoscaler), new.(*autoscalingv2.HorizontalPodAutoscaler)
+	return o.Status.CurrentReplicas != n.Status.CurrentReplicas ||
+		!equality.Semantic.DeepEqual(scalingActive(o), scalingActive(n))
+}
+
+func scalingActive(hpa *autoscalingv2.HorizontalPodAutoscaler) *autoscalingv2.HorizontalPodAutoscalerCondition {
+	for i, c := range hpa.Status.Conditions {
+		if c.Type == autoscalingv2.ScalingActive {
+			return &hpa.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ModeIngress exposes Applications through an Ingress.
+	ModeIngress = "Ingress"
+	// ModeGateway exposes Applications through a Gateway API HTTPRoute.
+	ModeGateway = "Gateway"
+
+	httpRouteCondition = "HTTPRouteReady"
+)
+
+var (
+	httpRoute   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	gatewayKind = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+)
+
+// HTTPRouteDriver renders the ingress rules of Applications exposed through
+// a Gateway as an HTTPRoute. Gateway API is not vendored, so the route is
+// handled as an unstructured object.
+type HTTPRouteDriver struct {
+	base
+}
+
+func (d *HTTPRouteDriver) Name() string {
+	return "httproute"
+}
+
+func (d *HTTPRouteDriver) Object() client.Object {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRoute)
+	return route
+}
+
+func (d *HTTPRouteDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	if !app.Spec.Ingress.Enabled || exposureMode(app, d.Config.Get().Ingress.Mode) != ModeGateway {
+		return nil, nil
+	}
+	parent, err := d.parentRef(app)
+	if err != nil {
+		return nil, err
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parent},
+		"rules":      httpRouteRules(app),
+	}
+	if host := ingressHost(app, d.Config.Get().Ingress.Domain); host != "" {
+		spec["hostnames"] = []interface{}{host}
+	}
+	route := d.Object().(*unstructured.Unstructured)
+	objectMeta := d.objectMeta(ctx, app)
+	route.SetName(objectMeta.Name)
+	route.SetNamespace(objectMeta.Namespace)
+	route.SetLabels(objectMeta.Labels)
+	route.SetAnnotations(objectMeta.Annotations)
+	route.Object["spec"] = spec
+	return route, nil
+}
+
+// parentRef returns the Gateway the route of app attaches to.
+func (d *HTTPRouteDriver) parentRef(app *appv1alpha1.Application) (map[string]interface{}, error) {
+	gateway := gatewayOf(app, d.Config.Get().Ingress.Gateway)
+	if gateway.Name == "" {
+		return nil, fmt.Errorf("no gateway to attach the HTTPRoute to")
+	}
+	ref := map[string]interface{}{"name": gateway.Name}
+	if gateway.Namespace != "" {
+		ref["namespace"] = gateway.Namespace
+	}
+	if gateway.SectionName != "" {
+		ref["sectionName"] = gateway.SectionName
+	}
+	return ref, nil
+}
+
+// gatewayOf returns the Gateway app is exposed through, falling back to the
+// Gateway of the operator config.
+func gatewayOf(app *appv1alpha1.Application, cfg config.GatewayRef) appv1alpha1.GatewayReference {
+	if app.Spec.Ingress.Gateway != nil {
+		return *app.Spec.Ingress.Gateway
+	}
+	return appv1alpha1.GatewayReference{Name: cfg.Name, Namespace: cfg.Namespace, SectionName: cfg.SectionName}
+}
+
+// httpRouteRules renders a rule per ingress path of app. Numbers are int64,
+// as in live unstructured objects, so that Compare sees no drift.
+func httpRouteRules(app *appv1alpha1.Application) []interface{} {
+	paths := app.Spec.Ingress.Rules.Paths
+	if len(paths) == 0 {
+		paths = []appv1alpha1.IngressPath{{}}
+	}
+	rules := make([]interface{}, 0, len(paths))
+	for _, p := range paths {
+		path := "/"
+		if p.Path != "" {
+			path = p.Path
+		}
+		match := map[string]interface{}{
+			"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+		}
+		if len(p.Headers) > 0 {
+			names := make([]string, 0, len(p.Headers))
+			for name := range p.Headers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			headers := make([]interface{}, 0, len(names))
+			for _, name := range names {
+				headers = append(headers, map[string]interface{}{"type": "Exact", "name": name, "value": p.Headers[name]})
+			}
+			match["headers"] = headers
+		}
+		backends := p.Backends
+		if len(backends) == 0 {
+			backends = []appv1alpha1.WeightedBackend{{ServiceName: p.ServiceName, Port: p.Port}}
+		}
+		refs := make([]interface{}, 0, len(backends))
+		for _, b := range backends {
+			ref := map[string]interface{}{"name": app.Name, "port": int64(app.Spec.App.ContainerPort)}
+			if b.ServiceName != "" {
+				ref["name"] = b.ServiceName
+			}
+			if b.Port != nil {
+				ref["port"] = int64(*b.Port)
+			}
+			if b.Weight != nil {
+				ref["weight"] = int64(*b.Weight)
+			}
+			refs = append(refs, ref)
+		}
+		rules = append(rules, map[string]interface{}{
+			"matches":     []interface{}{match},
+			"backendRefs": refs,
+		})
+	}
+	return rules
+}
+
+// exposureMode returns how app is exposed, falling back to the mode of the
+// operator config.
+func exposureMode(app *appv1alpha1.Application, mode string) string {
+	if app.Spec.Ingress.Mode != "" {
+		return app.Spec.Ingress.Mode
+	}
+	if mode != "" {
+		return mode
+	}
+	return ModeIngress
+}
+
+func (d *HTTPRouteDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*unstructured.Unstructured), current.(*unstructured.Unstructured)
+	return equality.Semantic.DeepDerivative(want.Object["spec"], have.Object["spec"])
+}
+
+// Apply leaves the route out when Gateway API is not installed, which Status
+// reports, so that the drivers after it still run.
+func (d *HTTPRouteDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*unstructured.Unstructured), d.Object().(*unstructured.Unstructured)
+	err := d.apply(ctx, app, d, want, have, func() {
+		have.Object["spec"] = want.Object["spec"]
+	})
+	if meta.IsNoMatchError(err) {
+		log.Warn(ctx, "not applying httproute: Gateway API is not installed")
+		return nil
+	}
+	return err
+}
+
+func (d *HTTPRouteDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	err := d.delete(ctx, app, d)
+	if meta.IsNoMatchError(err) {
+		// Gateway API is not installed, so there is no route to delete.
+		return nil
+	}
+	return err
+}
+
+func (d *HTTPRouteDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	route := d.Object()
+	found, err := d.get(ctx, app, route)
+	if meta.IsNoMatchError(err) {
+		return condition(httpRouteCondition, false, "NotInstalled", "Gateway API is not installed"), nil
+	}
+	if err != nil || !found {
+		return condition(httpRouteCondition, false, "NotFound", "HTTPRoute does not exist"), err
+	}
+	parents, _, _ := unstructured.NestedSlice(route.(*unstructured.Unstructured).Object, "status", "parents")
+	if len(parents) == 0 {
+		return condition(httpRouteCondition, false, "Pending", "no gateway has accepted the route yet"), nil
+	}
+	for _, p := range parents {
+		parent, _ := p.(map[string]interface{})
+		conditions, _, _ := unstructured.NestedSlice(parent, "conditions")
+		for _, c := range conditions {
+			c, _ := c.(map[string]interface{})
+			if c["type"] != "Accepted" {
+				continue
+			}
+			if c["status"] != string(metav1.ConditionTrue) {
+				message, _ := c["message"].(string)
+				return condition(httpRouteCondition, false, "NotAccepted", message), nil
+			}
+		}
+	}
+	return condition(httpRouteCondition, true, "Accepted", "the route is accepted by its gateways"), nil
+}
+
+func (d *HTTPRouteDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*unstructured.Unstructured), new.(*unstructured.Unstructured)
+	return !equality.Semantic.DeepEqual(o.Object["status"], n.Object["status"])
+}
@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/imagepolicy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const policyViolationCondition = "PolicyViolation"
+
+// checkImagePolicy checks the images app would run, hooks included,
+// against the image policy of the operator config.
+func (a *ApplicationClient) checkImagePolicy(ctx context.Context, app *appv1alpha1.Application, drivers []ResourceDriver) (*metav1.Condition, error) {
+	cfg := a.Config.Get()
+	strict, err := a.strictImages(ctx, app.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	images := []string{appImage(app)}
+	for _, d := range drivers {
+		r, ok := d.(PodRenderer)
+		if !ok {
+			continue
+		}
+		desired, err := d.Render(ctx, app)
+		if err != nil {
+			return nil, err
+		}
+		if desired == nil {
+			continue
+		}
+		_, spec := r.Pods(desired)
+		for _, c := range spec.InitContainers {
+			images = append(images, c.Image)
+		}
+		for _, c := range spec.Containers {
+			images = append(images, c.Image)
+		}
+	}
+	for _, phase := range []string{preDeployHook, postDeployHook} {
+		if hook := hookOf(app, phase); hook != nil && hook.Image != "" {
+			images = append(images, hook.Image)
+		}
+	}
+
+	seen := map[string]bool{}
+	var violations []string
+	for _, image := range images {
+		if seen[image] {
+			continue
+		}
+		seen[image] = true
+		// A tag that is pinned is not run as it is; the digest it resolves
+		// to, which the tag rules allow, is.
+		unpinned := pinning(app) && image == specImage(app)
+		violations = append(violations, imagepolicy.Check(cfg, image, strict && !unpinned)...)
+	}
+	if len(violations) > 0 {
+		violation := condition(policyViolationCondition, true, "ImageNotAllowed", strings.Join(violations, "; "))
+		return &violation, nil
+	}
+	compliant := condition(policyViolationCondition, false, "Compliant", "images conform to the image policy")
+	return &compliant, nil
+}
+
+// strictImages reports whether the namespace is selected by the image
+// policy. Namespaces that cannot be read are not. Like the pod security
+// level, the labels are read past the cache.
+func (a *ApplicationClient) strictImages(ctx context.Context, namespace string) (bool, error) {
+	selector := a.Config.Get().ImagePolicy.NamespaceSelector
+	if selector == "" {
+		return false, nil
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return false, err
+	}
+	ns := &corev1.Namespace{}
+	if err := a.getUncached(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		if errors.IsForbidden(err) || errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return parsed.Matches(labels.Set(ns.Labels)), nil
+}
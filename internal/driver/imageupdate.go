@@ -0,0 +1,158 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/imageupdate"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	imageUpdateCondition = "ImageUpdate"
+	// defaultImageUpdateInterval is how often the registry is polled when
+	// the policy sets no interval.
+	defaultImageUpdateInterval = 5 * time.Minute
+	// imageUpdateHistory is how many updates the status keeps.
+	imageUpdateHistory = 10
+)
+
+// following reports whether the image of app follows new tags.
+func following(app *appv1alpha1.Application) bool {
+	return app.Spec.ImageUpdate != nil && registry.ParseReference(app.Spec.App.Image).Digest == ""
+}
+
+// specImage returns the image app runs before it is pinned: the tag
+// selected by the image update policy when it is not written back,
+// spec.app.image otherwise.
+func specImage(app *appv1alpha1.Application) string {
+	image := app.Spec.App.Image
+	if updated := app.Status.ImageUpdate; following(app) && updated != nil && updated.Base == image && updated.Image != "" {
+		return updated.Image
+	}
+	return image
+}
+
+// updateImage polls the tags of the repository of the image of app when
+// its image update policy is due, selects the newest one the policy allows
+// and records it in the status, or in the spec with write-back. The
+// returned condition reports the last poll and is nil when the image does
+// not follow new tags.
+func (a *ApplicationClient) updateImage(ctx context.Context, app *appv1alpha1.Application, now time.Time) (*metav1.Condition, error) {
+	image := app.Spec.App.Image
+	if !following(app) {
+		if app.Status.ImageUpdate == nil {
+			return nil, nil
+		}
+		app.Status.ImageUpdate = nil
+		recordWrite(ctx)
+		return nil, a.Kubernetes.Status().Update(ctx, app)
+	}
+	updated := app.Status.ImageUpdate
+	if updated != nil && updated.Base == image && now.Before(updated.LastPolled.Add(pollInterval(app))) {
+		if c := meta.FindStatusCondition(app.Status.Conditions, imageUpdateCondition); c != nil {
+			kept := *c
+			return &kept, nil
+		}
+	}
+
+	ref := registry.ParseReference(image)
+	policy := imageupdate.Policy{Semver: app.Spec.ImageUpdate.Semver, Pattern: app.Spec.ImageUpdate.Pattern}
+	if _, _, err := imageupdate.Latest(policy, "", nil); err != nil {
+		c := condition(imageUpdateCondition, false, "InvalidPolicy", err.Error())
+		return &c, nil
+	}
+	tags, err := a.tags(ctx, app, image)
+	if err != nil {
+		log.Warn(ctx, "failed to list image tags", zap.String("image", image), zap.Error(err))
+		c := condition(imageUpdateCondition, false, "PollFailed", fmt.Sprintf("listing tags of %s: %v", ref.Name(), err))
+		return &c, nil
+	}
+
+	status := &appv1alpha1.ImageUpdateStatus{Base: image, LastPolled: metav1.NewTime(now)}
+	if updated != nil {
+		status.History = updated.History
+		if updated.Base == image {
+			status.Image = updated.Image
+		}
+	}
+	var c metav1.Condition
+	current := image
+	if status.Image != "" {
+		current = status.Image
+	}
+	tag, ok, _ := imageupdate.Latest(policy, registry.ParseReference(current).Tag, tags)
+	if !ok {
+		c = condition(imageUpdateCondition, false, "NoMatchingTag", fmt.Sprintf("no tag of %s matches the policy", ref.Name()))
+	} else {
+		selected := ref.Name() + ":" + tag
+		c = condition(imageUpdateCondition, true, "Latest", fmt.Sprintf("%s is the newest matching tag", selected))
+		if selected != current {
+			log.Info(ctx, "updating image", zap.String("from", current), zap.String("to", selected))
+			if a.Events != nil {
+				a.Events.Eventf(app, corev1.EventTypeNormal, "ImageUpdated", "%s updated to %s", current, selected)
+			}
+			status.History = append([]appv1alpha1.ImageUpdateRecord{{From: current, To: selected, Time: metav1.NewTime(now)}}, status.History...)
+			if len(status.History) > imageUpdateHistory {
+				status.History = status.History[:imageUpdateHistory]
+			}
+		}
+		status.Image = selected
+		if app.Spec.ImageUpdate.WriteBack {
+			status.Base = selected
+		}
+	}
+
+	app.Status.ImageUpdate = status
+	recordWrite(ctx)
+	if err := a.Kubernetes.Status().Update(ctx, app); err != nil {
+		return nil, err
+	}
+	if app.Spec.ImageUpdate.WriteBack && status.Image != "" && status.Image != image {
+		app.Spec.App.Image = status.Image
+		recordWrite(ctx)
+		if err := a.Kubernetes.Update(ctx, app); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// tags lists the tags of the repository of image with the pull secrets of
+// app.
+func (a *ApplicationClient) tags(ctx context.Context, app *appv1alpha1.Application, image string) ([]string, error) {
+	creds, err := a.pullCredentials(ctx, app, image)
+	if err != nil {
+		return nil, err
+	}
+	return a.registry().Tags(ctx, image, creds)
+}
+
+func pollInterval(app *appv1alpha1.Application) time.Duration {
+	if interval := app.Spec.ImageUpdate.Interval; interval != nil && interval.Duration > 0 {
+		return interval.Duration
+	}
+	return defaultImageUpdateInterval
+}
+
+// nextPoll returns how long until the image update policy of app is due,
+// or zero when it is overdue because the registry failed, which the resync
+// retries.
+func nextPoll(app *appv1alpha1.Application, now time.Time) time.Duration {
+	updated := app.Status.ImageUpdate
+	if !following(app) || updated == nil {
+		return 0
+	}
+	next := updated.LastPolled.Add(pollInterval(app)).Sub(now)
+	if next < 0 {
+		return 0
+	}
+	return next
+}
@@ -0,0 +1,154 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type IngressDriver struct {
+	base
+}
+
+func (d *IngressDriver) Name() string {
+	return "ingress"
+}
+
+func (d *IngressDriver) Object() client.Object {
+	return &networkingv1.Ingress{}
+}
+
+func (d *IngressDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	cfg := d.Config.Get()
+	if !app.Spec.Ingress.Enabled || exposureMode(app, cfg.Ingress.Mode) != ModeIngress {
+		return nil, nil
+	}
+	host := ingressHost(app, cfg.Ingress.Domain)
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: app.Spec.Ingress.ClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: ingressPaths(app),
+						},
+					},
+				},
+			},
+		},
+	}
+	if ingressTLS(app) != nil {
+		tls := networkingv1.IngressTLS{SecretName: tlsSecretName(app)}
+		if host != "" {
+			tls.Hosts = []string{host}
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+	if ingress.Spec.IngressClassName == nil && cfg.Ingress.ClassName != "" {
+		ingress.Spec.IngressClassName = &cfg.Ingress.ClassName
+	}
+	ingress.Annotations = merge(ingress.Annotations, app.Spec.Ingress.Annotations)
+	return ingress, nil
+}
+
+func (d *IngressDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*networkingv1.Ingress), current.(*networkingv1.Ingress)
+	return equality.Semantic.DeepDerivative(want.Spec, have.Spec)
+}
+
+func (d *IngressDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*networkingv1.Ingress), &networkingv1.Ingress{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Spec = want.Spec
+	})
+}
+
+func (d *IngressDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *IngressDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	ingress := &networkingv1.Ingress{}
+	found, err := d.get(ctx, app, ingress)
+	if err != nil || !found {
+		return condition("IngressReady", false, "NotFound", "ingress does not exist"), err
+	}
+	addresses := loadBalancerAddresses(ingress)
+	if len(addresses) == 0 {
+		return condition("IngressReady", false, "Pending", "load balancer address is not assigned yet"), nil
+	}
+	return condition("IngressReady", true, "Assigned", strings.Join(addresses, ",")), nil
+}
+
+// loadBalancerAddresses returns the hostnames and IPs assigned to ingress.
+func loadBalancerAddresses(ingress *networkingv1.Ingress) []string {
+	var addresses []string
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if lb.Hostname != "" {
+			addresses = append(addresses, lb.Hostname)
+		} else if lb.IP != "" {
+			addresses = append(addresses, lb.IP)
+		}
+	}
+	return addresses
+}
+
+// ingressHost returns the host the Application asked for, falling back to
+// <name>.<namespace>.<domain> under the operator's default domain.
+func ingressHost(app *appv1alpha1.Application, domain string) string {
+	if app.Spec.Ingress.Rules.Host != "" {
+		return app.Spec.Ingress.Rules.Host
+	}
+	if app.Spec.App.IngressHost != "" || domain == "" {
+		return app.Spec.App.IngressHost
+	}
+	return fmt.Sprintf("%s.%s.%s", app.Name, app.Namespace, domain)
+}
+
+func ingressPaths(app *appv1alpha1.Application) []networkingv1.HTTPIngressPath {
+	pathType := networkingv1.PathTypePrefix
+	paths := app.Spec.Ingress.Rules.Paths
+	if len(paths) == 0 {
+		paths = []appv1alpha1.IngressPath{{}}
+	}
+	rendered := make([]networkingv1.HTTPIngressPath, 0, len(paths))
+	for _, p := range paths {
+		path := networkingv1.HTTPIngressPath{
+			Path:     "/",
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: app.Name,
+					Port: networkingv1.ServiceBackendPort{
+						Number: app.Spec.App.ContainerPort,
+					},
+				},
+			},
+		}
+		if p.Path != "" {
+			path.Path = p.Path
+		}
+		if p.ServiceName != "" {
+			path.Backend.Service.Name = p.ServiceName
+		}
+		if p.Port != nil {
+			path.Backend.Service.Port.Number = *p.Port
+		}
+		rendered = append(rendered, path)
+	}
+	return rendered
+}
+
+func (d *IngressDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*networkingv1.Ingress), new.(*networkingv1.Ingress)
+	return !equality.Semantic.DeepEqual(o.Status.LoadBalancer, n.Status.LoadBalancer)
+}
@@ -0,0 +1,295 @@
+package driver
+
+import (
+	"context"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceNameLabel is set by the API server on every Namespace.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// NetworkPolicyDriver isolates the pods of Applications that declare their
+// networking with a default-deny NetworkPolicy, named <name>-default-deny,
+// and allows the declared traffic with a second one named like the
+// Application.
+type NetworkPolicyDriver struct {
+	base
+}
+
+func (d *NetworkPolicyDriver) Name() string {
+	return "networkpolicy"
+}
+
+func (d *NetworkPolicyDriver) Object() client.Object {
+	return &networkingv1.NetworkPolicy{}
+}
+
+func (d *NetworkPolicyDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	networking := app.Spec.Networking
+	if networking == nil {
+		return nil, nil
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selectorLabels(app)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+
+	var ports []networkingv1.NetworkPolicyPort
+	if app.Spec.App.ContainerPort != 0 {
+		ports = []networkingv1.NetworkPolicyPort{tcpPort(app.Spec.App.ContainerPort)}
+	}
+	// Callers may also reach the ports sidecars expose on the Service.
+	callerPorts := append([]networkingv1.NetworkPolicyPort{}, ports...)
+	_, exposed, err := d.sidecars(app)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range exposed {
+		port, protocol := intstr.FromInt(int(p.Port)), p.Protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		callerPorts = append(callerPorts, networkingv1.NetworkPolicyPort{Port: &port, Protocol: &protocol})
+	}
+	var callers []networkingv1.NetworkPolicyPeer
+	for _, peer := range networking.AllowFrom {
+		apps, err := d.resolvePeer(ctx, app, peer)
+		if err != nil {
+			return nil, err
+		}
+		for i := range apps {
+			callers = append(callers, podsOf(&apps[i]))
+		}
+	}
+	// A rule without peers would allow every caller.
+	if len(callers) > 0 {
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{From: callers, Ports: callerPorts})
+	}
+	// Without a controller namespace no rule is added, since one selecting
+	// every namespace would open the pods to the whole cluster.
+	if ns := d.Config.Get().Ingress.ControllerNamespace; app.Spec.Ingress.Enabled && ns != "" {
+		controller := networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceNameLabel: ns},
+		}}
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{controller},
+			Ports: ports,
+		})
+	}
+
+	policy.Spec.Egress = append(policy.Spec.Egress, dnsEgress())
+	for _, peer := range networking.AllowTo {
+		apps, err := d.resolvePeer(ctx, app, peer)
+		if err != nil {
+			return nil, err
+		}
+		for i := range apps {
+			rule := networkingv1.NetworkPolicyEgressRule{To: []networkingv1.NetworkPolicyPeer{podsOf(&apps[i])}}
+			if port := apps[i].Spec.App.ContainerPort; port != 0 {
+				rule.Ports = []networkingv1.NetworkPolicyPort{tcpPort(port)}
+			}
+			policy.Spec.Egress = append(policy.Spec.Egress, rule)
+		}
+	}
+	for _, cidr := range networking.Egress {
+		rule := networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr.CIDR, Except: cidr.Except}}},
+		}
+		for _, p := range cidr.Ports {
+			port, protocol := intstr.FromInt(int(p.Port)), p.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Port: &port, Protocol: &protocol})
+		}
+		policy.Spec.Egress = append(policy.Spec.Egress, rule)
+	}
+	return policy, nil
+}
+
+// denyPolicy isolates the pods of app in both directions.
+func (d *NetworkPolicyDriver) denyPolicy(ctx context.Context, app *appv1alpha1.Application) *networkingv1.NetworkPolicy {
+	meta := d.objectMeta(ctx, app)
+	meta.Name = denyPolicyName(app)
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: meta,
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selectorLabels(app)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+}
+
+func denyPolicyName(app *appv1alpha1.Application) string {
+	return app.Name + "-default-deny"
+}
+
+func (d *NetworkPolicyDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*networkingv1.NetworkPolicy), current.(*networkingv1.NetworkPolicy)
+	return equality.Semantic.DeepDerivative(want.Spec, have.Spec)
+}
+
+func (d *NetworkPolicyDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	if err := d.applyPolicy(ctx, app, d.denyPolicy(ctx, app)); err != nil {
+		return err
+	}
+	return d.applyPolicy(ctx, app, desired.(*networkingv1.NetworkPolicy))
+}
+
+func (d *NetworkPolicyDriver) applyPolicy(ctx context.Context, app *appv1alpha1.Application, want *networkingv1.NetworkPolicy) error {
+	have := &networkingv1.NetworkPolicy{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Spec = want.Spec
+	})
+}
+
+func (d *NetworkPolicyDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	if err := d.delete(ctx, app, d); err != nil {
+		return err
+	}
+	return d.deleteNamed(ctx, app, d, denyPolicyName(app))
+}
+
+func (d *NetworkPolicyDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	found, err := d.get(ctx, app, &networkingv1.NetworkPolicy{})
+	if err == nil && found {
+		key := client.ObjectKey{Namespace: app.Namespace, Name: denyPolicyName(app)}
+		err = d.Kubernetes.Get(ctx, key, &networkingv1.NetworkPolicy{})
+		found = err == nil
+		if errors.IsNotFound(err) {
+			err = nil
+		}
+	}
+	if err != nil || !found {
+		return condition("NetworkPolicyReady", false, "NotFound", "network policies do not exist"), err
+	}
+	return condition("NetworkPolicyReady", true, "Created", "network policies exist"), nil
+}
+
+// resolvePeer returns the Applications peer selects, relative to app.
+// Applications that do not exist yet are left out until they are created.
+func (d *NetworkPolicyDriver) resolvePeer(ctx context.Context, app *appv1alpha1.Application, peer appv1alpha1.ApplicationPeer) ([]appv1alpha1.Application, error) {
+	namespace := peer.Namespace
+	if namespace == "" {
+		namespace = app.Namespace
+	}
+	if peer.Name != "" {
+		found := appv1alpha1.Application{}
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: namespace, Name: peer.Name}, &found)
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return []appv1alpha1.Application{found}, err
+	}
+	if peer.Selector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(peer.Selector)
+	if err != nil {
+		return nil, err
+	}
+	apps := &appv1alpha1.ApplicationList{}
+	if err := d.Kubernetes.List(ctx, apps, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	return apps.Items, nil
+}
+
+// PeerKeys returns the keys of the peers the networking of app refers to:
+// PeerKey(namespace, name) for peers given by name, and PeerKey(namespace,
+// "") for those selected by label. They index Applications so that the
+// referrers of a peer are found without listing every Application.
+func PeerKeys(app *appv1alpha1.Application) []string {
+	if app.Spec.Networking == nil {
+		return nil
+	}
+	var keys []string
+	for _, p := range append(append([]appv1alpha1.ApplicationPeer(nil), app.Spec.Networking.AllowFrom...), app.Spec.Networking.AllowTo...) {
+		namespace := p.Namespace
+		if namespace == "" {
+			namespace = app.Namespace
+		}
+		switch {
+		case p.Name != "":
+			keys = append(keys, PeerKey(namespace, p.Name))
+		case p.Selector != nil:
+			keys = append(keys, PeerKey(namespace, ""))
+		}
+	}
+	return keys
+}
+
+// PeerKey returns the key of the peer called name in namespace, or of the
+// peers selected by label in namespace when name is empty.
+func PeerKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// SelectsPeer reports whether the networking of app refers to peer, so that
+// app is reconciled again when peer is created, relabeled or deleted.
+func SelectsPeer(app, peer *appv1alpha1.Application) bool {
+	if app.Spec.Networking == nil {
+		return false
+	}
+	peers := append(append([]appv1alpha1.ApplicationPeer(nil), app.Spec.Networking.AllowFrom...), app.Spec.Networking.AllowTo...)
+	for _, p := range peers {
+		namespace := p.Namespace
+		if namespace == "" {
+			namespace = app.Namespace
+		}
+		if namespace != peer.Namespace {
+			continue
+		}
+		if p.Name != "" {
+			if p.Name == peer.Name {
+				return true
+			}
+			continue
+		}
+		if p.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(p.Selector)
+		if err == nil && selector.Matches(labels.Set(peer.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// podsOf selects the pods of app.
+func podsOf(app *appv1alpha1.Application) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		PodSelector:       &metav1.LabelSelector{MatchLabels: selectorLabels(app)},
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: app.Namespace}},
+	}
+}
+
+func tcpPort(port int32) networkingv1.NetworkPolicyPort {
+	protocol, p := corev1.ProtocolTCP, intstr.FromInt(int(port))
+	return networkingv1.NetworkPolicyPort{Port: &p, Protocol: &protocol}
+}
+
+// dnsEgress allows DNS lookups against any namespace.
+func dnsEgress() networkingv1.NetworkPolicyEgressRule {
+	udp, tcp, port := corev1.ProtocolUDP, corev1.ProtocolTCP, intstr.FromInt(53)
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Port: &port, Protocol: &udp},
+			{Port: &port, Protocol: &tcp},
+		},
+	}
+}
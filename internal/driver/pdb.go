@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type PDBDriver struct {
+	base
+}
+
+func (d *PDBDriver) Name() string {
+	return "pdb"
+}
+
+func (d *PDBDriver) Object() client.Object {
+	return &policyv1.PodDisruptionBudget{}
+}
+
+func (d *PDBDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	spec := app.Spec.Scheduler.PodDisruptionBudgetSpec
+	if spec.Enabled == nil || !*spec.Enabled {
+		return nil, nil
+	}
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorLabels(app),
+			},
+		},
+	}
+	switch {
+	case spec.MinAvailable != nil:
+		minAvailable := intstr.FromInt(int(*spec.MinAvailable))
+		pdb.Spec.MinAvailable = &minAvailable
+	case spec.MaxUnavailable != nil:
+		maxUnavailable := intstr.FromInt(int(*spec.MaxUnavailable))
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	default:
+		maxUnavailable := intstr.FromInt(1)
+		pdb.Spec.MaxUnavailable = &maxUnavailable
+	}
+	return pdb, nil
+}
+
+func (d *PDBDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*policyv1.PodDisruptionBudget), current.(*policyv1.PodDisruptionBudget)
+	return equality.Semantic.DeepEqual(want.Spec, have.Spec)
+}
+
+func (d *PDBDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*policyv1.PodDisruptionBudget), &policyv1.PodDisruptionBudget{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Spec = want.Spec
+	})
+}
+
+func (d *PDBDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *PDBDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	pdb := &policyv1.PodDisruptionBudget{}
+	found, err := d.get(ctx, app, pdb)
+	if err != nil || !found {
+		return condition("DisruptionBudgetReady", false, "NotFound", "pod disruption budget does not exist"), err
+	}
+	return condition("DisruptionBudgetReady", true, "Created",
+		fmt.Sprintf("%d disruptions allowed", pdb.Status.DisruptionsAllowed)), nil
+}
+
+func (d *PDBDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*policyv1.PodDisruptionBudget), new.(*policyv1.PodDisruptionBudget)
+	return o.Status.DisruptionsAllowed != n.Status.DisruptionsAllowed
+}
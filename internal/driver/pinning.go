@@ -0,0 +1,174 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const imagePinnedCondition = "ImagePinned"
+
+// pinning reports whether the image of app is to be pinned to the digest
+// of its tag.
+func pinning(app *appv1alpha1.Application) bool {
+	return app.Spec.ImagePinning != nil && registry.ParseReference(specImage(app)).Digest == ""
+}
+
+// appImage returns the image of the application container: the digest
+// whose signature was verified when it was verified for pinnedImage, so
+// that a tag moved since is not run, and pinnedImage otherwise.
+func appImage(app *appv1alpha1.Application) string {
+	image := pinnedImage(app)
+	if s := app.Status.Signature; s != nil && s.Reference == image {
+		return registry.ParseReference(image).Pinned(registry.ParseReference(s.Image).Digest)
+	}
+	return image
+}
+
+// pinnedImage returns the digest recorded in the status when the image of
+// app is pinned, its specImage otherwise.
+func pinnedImage(app *appv1alpha1.Application) string {
+	image := specImage(app)
+	if pinned := app.Status.Image; pinning(app) && pinned != nil && pinned.Image == image {
+		return registry.ParseReference(image).Pinned(pinned.Digest)
+	}
+	return image
+}
+
+// pinImage resolves the tag of the image of app when it is pinned and the
+// tag has not been resolved yet, or was last resolved over a resolve
+// interval ago. The digest is written to the status before any driver
+// renders it. The returned condition is False when the image cannot run
+// because it never resolved, and nil when the image is not pinned.
+func (a *ApplicationClient) pinImage(ctx context.Context, app *appv1alpha1.Application, now time.Time) (*metav1.Condition, error) {
+	image := specImage(app)
+	if !pinning(app) {
+		if app.Status.Image == nil {
+			return nil, nil
+		}
+		app.Status.Image = nil
+		recordWrite(ctx)
+		return nil, a.Kubernetes.Status().Update(ctx, app)
+	}
+	pinned := app.Status.Image
+	if pinned != nil && pinned.Image != image {
+		pinned = nil
+	}
+	if pinned != nil && !resolveDue(app, now) {
+		c := condition(imagePinnedCondition, true, "Resolved", fmt.Sprintf("%s is pinned to %s", image, pinned.Digest))
+		return &c, nil
+	}
+
+	digest, err := a.resolveImage(ctx, app, image)
+	if err != nil {
+		log.Warn(ctx, "failed to resolve image digest", zap.String("image", image), zap.Error(err))
+		if pinned != nil {
+			c := condition(imagePinnedCondition, true, "ResolveFailed",
+				fmt.Sprintf("%s stays pinned to %s: %v", image, pinned.Digest, err))
+			return &c, nil
+		}
+		c := condition(imagePinnedCondition, false, "ResolveFailed", fmt.Sprintf("resolving %s: %v", image, err))
+		return &c, nil
+	}
+	if pinned != nil && pinned.Digest != digest {
+		log.Info(ctx, "image tag moved", zap.String("image", image), zap.String("from", pinned.Digest), zap.String("to", digest))
+		if a.Events != nil {
+			a.Events.Eventf(app, corev1.EventTypeNormal, "ImageUpdated", "%s moved from %s to %s", image, pinned.Digest, digest)
+		}
+	}
+	app.Status.Image = &appv1alpha1.ImageStatus{Image: image, Digest: digest, ResolvedAt: metav1.NewTime(now)}
+	recordWrite(ctx)
+	if err := a.Kubernetes.Status().Update(ctx, app); err != nil {
+		return nil, err
+	}
+	c := condition(imagePinnedCondition, true, "Resolved", fmt.Sprintf("%s is pinned to %s", image, digest))
+	return &c, nil
+}
+
+// resolveDue reports whether the pinned tag of app is due to be resolved
+// again.
+func resolveDue(app *appv1alpha1.Application, now time.Time) bool {
+	interval := app.Spec.ImagePinning.ResolveInterval
+	if interval == nil || interval.Duration <= 0 {
+		return false
+	}
+	return !now.Before(app.Status.Image.ResolvedAt.Add(interval.Duration))
+}
+
+// nextResolve returns how long until the pinned tag of app is due to be
+// resolved again. It is zero when the tag is not resolved on a schedule, or
+// is overdue because the registry failed, which the resync retries.
+func nextResolve(app *appv1alpha1.Application, now time.Time) time.Duration {
+	if !pinning(app) || app.Status.Image == nil || app.Spec.ImagePinning.ResolveInterval == nil {
+		return 0
+	}
+	next := app.Status.Image.ResolvedAt.Add(app.Spec.ImagePinning.ResolveInterval.Duration).Sub(now)
+	if next < 0 {
+		return 0
+	}
+	return next
+}
+
+// resolveImage asks the registry for the digest of image with the pull
+// secrets of app.
+func (a *ApplicationClient) resolveImage(ctx context.Context, app *appv1alpha1.Application, image string) (string, error) {
+	creds, err := a.pullCredentials(ctx, app, image)
+	if err != nil {
+		return "", err
+	}
+	return a.registry().Resolve(ctx, image, creds)
+}
+
+// pullCredentials returns the credentials for the registry of image held
+// by the first pull secret of app that has any, falling back to the
+// registry credentials of the operator config.
+func (a *ApplicationClient) pullCredentials(ctx context.Context, app *appv1alpha1.Application, image string) (registry.Credentials, error) {
+	host := registry.ParseReference(image).Registry
+	var secrets []client.ObjectKey
+	for _, ref := range app.Spec.App.ImagePullSecrets {
+		secrets = append(secrets, client.ObjectKey{Namespace: app.Namespace, Name: ref.Name})
+	}
+	// The pull secrets of app are cached with its namespace, while the
+	// sources of the registry credentials may live outside the watched
+	// namespaces.
+	own := len(secrets)
+	if cfg := a.Config.Get(); cfg.DriverEnabled(registryCredentialsDriver) {
+		for _, cred := range cfg.RegistryCredentials {
+			secrets = append(secrets, client.ObjectKey{Namespace: cred.SecretRef.Namespace, Name: cred.SecretRef.Name})
+		}
+	}
+	for i, key := range secrets {
+		secret := &corev1.Secret{}
+		var err error
+		if i < own {
+			err = a.Kubernetes.Get(ctx, key, secret)
+		} else {
+			err = a.getUncached(ctx, key, secret)
+		}
+		if err != nil {
+			return registry.Credentials{}, fmt.Errorf("reading pull secret %s: %w", key.Name, err)
+		}
+		creds, ok, err := registry.FromSecret(secret, host)
+		if err != nil || ok {
+			return creds, err
+		}
+	}
+	return registry.Credentials{}, nil
+}
+
+// registry returns the client of the registries of images, whose requests
+// are bounded by registry.DefaultTimeout unless one is set.
+func (a *ApplicationClient) registry() registry.Client {
+	if a.Registry != nil {
+		return a.Registry
+	}
+	return &registry.HTTPClient{Insecure: a.Config.Get().InsecureRegistries}
+}
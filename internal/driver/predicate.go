@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// Predicate filters the events on objects managed by d down to those that
+// can change what a reconcile does: creations, deletions, changes to the
+// spec, labels or annotations, and status changes d reports on. Spec drift
+// on kinds whose generation the API server does not track, like Services, is
+// corrected by the periodic resync.
+func Predicate(d ResourceDriver) predicate.Predicate {
+	changed := predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+	)
+	watcher, ok := d.(StatusWatcher)
+	if !ok {
+		return changed
+	}
+	return predicate.Or(changed, predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			return watcher.StatusChanged(e.ObjectOld, e.ObjectNew)
+		},
+	})
+}
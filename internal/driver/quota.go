@@ -0,0 +1,113 @@
+package driver
+
+import (
+	"context"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/quota"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const quotaExceededCondition = "QuotaExceeded"
+
+// checkQuota adds up the pods app would run and those of the other
+// Applications in its namespace, and checks them against the ResourceQuotas
+// of the namespace. It also returns what app requests, to be recorded in its
+// status. It returns nil when no quota applies.
+func (a *ApplicationClient) checkQuota(ctx context.Context, app *appv1alpha1.Application, drivers []ResourceDriver) (*metav1.Condition, corev1.ResourceList, error) {
+	budget, err := quota.Load(ctx, a.Kubernetes, app.Namespace)
+	if err != nil || budget == nil {
+		return nil, nil, err
+	}
+	requested, err := a.render(ctx, budget, app, drivers)
+	if err != nil {
+		return nil, nil, err
+	}
+	apps := &appv1alpha1.ApplicationList{}
+	if err := a.Kubernetes.List(ctx, apps, client.InNamespace(app.Namespace)); err != nil {
+		return nil, nil, err
+	}
+	total := []corev1.ResourceList{requested}
+	for i := range apps.Items {
+		other := &apps.Items[i]
+		if other.Name == app.Name || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		used, err := a.usage(ctx, budget, drivers, other)
+		if err != nil {
+			return nil, nil, err
+		}
+		total = append(total, used)
+	}
+	if err := budget.Check(quota.Sum(total...)); err != nil {
+		exceeded := condition(quotaExceededCondition, true, "QuotaExceeded", err.Error())
+		return &exceeded, requested, nil
+	}
+	within := condition(quotaExceededCondition, false, "WithinQuota", "application fits the namespace quota")
+	return &within, requested, nil
+}
+
+// render returns what the pods rendered for app count against budget.
+func (a *ApplicationClient) render(ctx context.Context, budget *quota.Budget, app *appv1alpha1.Application, drivers []ResourceDriver) (corev1.ResourceList, error) {
+	var requested []corev1.ResourceList
+	for _, d := range drivers {
+		r, ok := d.(PodRenderer)
+		if !ok {
+			continue
+		}
+		desired, err := d.Render(ctx, app)
+		if err != nil {
+			return nil, err
+		}
+		if desired != nil {
+			requested = append(requested, charge(budget, app, r, desired))
+		}
+	}
+	return quota.Sum(requested...), nil
+}
+
+// usage returns what another Application counts against the quota: what it
+// recorded in its status, or its rendered pods when it recorded nothing yet.
+// Its live pods are counted when it is itself over quota or cannot be
+// rendered, so that a rejected Application does not hold back the others.
+func (a *ApplicationClient) usage(ctx context.Context, budget *quota.Budget, drivers []ResourceDriver, app *appv1alpha1.Application) (corev1.ResourceList, error) {
+	if !meta.IsStatusConditionTrue(app.Status.Conditions, quotaExceededCondition) {
+		if app.Status.QuotaRequested != nil {
+			return app.Status.QuotaRequested, nil
+		}
+		if requested, err := a.render(ctx, budget, app, drivers); err == nil {
+			return requested, nil
+		}
+	}
+	var used []corev1.ResourceList
+	for _, d := range drivers {
+		r, ok := d.(PodRenderer)
+		if !ok {
+			continue
+		}
+		live := d.Object()
+		if err := a.Kubernetes.Get(ctx, client.ObjectKeyFromObject(app), live); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+		if metav1.IsControlledBy(live, app) {
+			used = append(used, charge(budget, app, r, live))
+		}
+	}
+	return quota.Sum(used...), nil
+}
+
+// charge returns what the pods of obj count against budget. An autoscaled
+// Application is charged the most replicas its autoscaler may scale to.
+func charge(budget *quota.Budget, app *appv1alpha1.Application, r PodRenderer, obj client.Object) corev1.ResourceList {
+	replicas, spec := r.Pods(obj)
+	if hpa := app.Spec.Scheduler.HPA; hpa.Enabled && hpa.MaxReplicas > replicas {
+		replicas = hpa.MaxReplicas
+	}
+	return budget.Requested(replicas, spec)
+}
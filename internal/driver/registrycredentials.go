@@ -0,0 +1,228 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	registryCredentialsDriver    = "registrycredentials"
+	registryCredentialsCondition = "RegistryCredentialsReady"
+	// registryCredentialLabel marks the copies of registry credentials with
+	// the name of their source, as namespace.name.
+	registryCredentialLabel = "app.cloudclub.com/registry-credential"
+)
+
+// RegistryCredentialDriver copies the registry credentials of the operator
+// config into the namespace of each Application. A copy is shared by the
+// Applications of its namespace, each of which owns it without controlling
+// it, so that it is garbage collected with the last of them.
+type RegistryCredentialDriver struct {
+	base
+}
+
+func (d *RegistryCredentialDriver) Name() string {
+	return registryCredentialsDriver
+}
+
+func (d *RegistryCredentialDriver) Object() client.Object {
+	return &corev1.Secret{}
+}
+
+// Render returns nil: the copies are shared and written by Reconcile.
+func (d *RegistryCredentialDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	return nil, nil
+}
+
+func (d *RegistryCredentialDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	credentials := d.Config.Get().RegistryCredentials
+	keep := map[string]bool{}
+	for _, cred := range credentials {
+		if err := d.copyCredential(ctx, app, cred); err != nil {
+			return true, err
+		}
+		keep[copyName(cred)] = true
+	}
+	return len(credentials) > 0, d.release(ctx, app, keep)
+}
+
+// copyCredential writes the copy of cred in the namespace of app and adds
+// app to its owners.
+func (d *RegistryCredentialDriver) copyCredential(ctx context.Context, app *appv1alpha1.Application, cred config.RegistryCredential) error {
+	name := copyName(cred)
+	if cred.SecretRef.Namespace == app.Namespace && cred.SecretRef.Name == name {
+		// The source itself serves the namespace it lives in.
+		return nil
+	}
+	source := &corev1.Secret{}
+	if err := d.getUncached(ctx, client.ObjectKey{Namespace: cred.SecretRef.Namespace, Name: cred.SecretRef.Name}, source); err != nil {
+		return fmt.Errorf("reading registry credential %s/%s: %w", cred.SecretRef.Namespace, cred.SecretRef.Name, err)
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: name}}
+	if recreated, err := d.recreate(ctx, app, cred, secret, source); recreated || err != nil {
+		return err
+	}
+	op, err := controllerutil.CreateOrUpdate(ctx, d.Kubernetes, secret, func() error {
+		if !secret.CreationTimestamp.IsZero() && secret.Labels[registryCredentialLabel] == "" {
+			return fmt.Errorf("secret %s exists and is not a registry credential copy", name)
+		}
+		secret.Labels = merge(secret.Labels, d.Config.Get().DefaultLabels,
+			map[string]string{registryCredentialLabel: cred.SecretRef.Namespace + "." + cred.SecretRef.Name})
+		secret.Type = source.Type
+		secret.Data = source.Data
+		return controllerutil.SetOwnerReference(app, secret, d.Schema)
+	})
+	if err != nil {
+		return err
+	}
+	if op != controllerutil.OperationResultNone {
+		log.Info(ctx, "copied registry credential", zap.String("secret", name), zap.String("operation", string(op)))
+		recordWrite(ctx)
+	}
+	return nil
+}
+
+// recreate replaces the copy of cred in secret when the type of source
+// changed, which cannot be updated, keeping its owners. It reports whether
+// it did.
+func (d *RegistryCredentialDriver) recreate(ctx context.Context, app *appv1alpha1.Application, cred config.RegistryCredential, secret, source *corev1.Secret) (bool, error) {
+	if err := d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if secret.Type == source.Type || secret.Labels[registryCredentialLabel] == "" {
+		return false, nil
+	}
+	log.Info(ctx, "recreating registry credential", zap.String("secret", secret.Name), zap.String("type", string(source.Type)))
+	recordWrite(ctx)
+	uid := secret.UID
+	if err := d.Kubernetes.Delete(ctx, secret, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	copied := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       secret.Namespace,
+			Name:            secret.Name,
+			Labels:          merge(secret.Labels, d.Config.Get().DefaultLabels, map[string]string{registryCredentialLabel: cred.SecretRef.Namespace + "." + cred.SecretRef.Name}),
+			OwnerReferences: secret.OwnerReferences,
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+	if err := controllerutil.SetOwnerReference(app, copied, d.Schema); err != nil {
+		return false, err
+	}
+	return true, d.Kubernetes.Create(ctx, copied)
+}
+
+// release removes app from the owners of the copies it no longer uses,
+// deleting those left without owners.
+func (d *RegistryCredentialDriver) release(ctx context.Context, app *appv1alpha1.Application, keep map[string]bool) error {
+	secrets := &corev1.SecretList{}
+	if err := d.Kubernetes.List(ctx, secrets, client.InNamespace(app.Namespace), client.HasLabels{registryCredentialLabel}); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if keep[secret.Name] || !ownedBy(secret, app) {
+			continue
+		}
+		owners := secret.OwnerReferences[:0]
+		for _, ref := range secret.OwnerReferences {
+			if ref.UID != app.UID {
+				owners = append(owners, ref)
+			}
+		}
+		secret.OwnerReferences = owners
+		recordWrite(ctx)
+		var err error
+		if len(owners) == 0 {
+			log.Info(ctx, "deleting registry credential", zap.String("secret", secret.Name))
+			err = d.Kubernetes.Delete(ctx, secret)
+		} else {
+			err = d.Kubernetes.Update(ctx, secret)
+		}
+		if err := client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ownedBy(obj metav1.Object, owner metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *RegistryCredentialDriver) Compare(desired, current client.Object) bool {
+	return true
+}
+
+func (d *RegistryCredentialDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	_, err := d.Reconcile(ctx, app)
+	return err
+}
+
+func (d *RegistryCredentialDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.release(ctx, app, nil)
+}
+
+func (d *RegistryCredentialDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	for _, cred := range d.Config.Get().RegistryCredentials {
+		secret := &corev1.Secret{}
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: copyName(cred)}, secret)
+		if errors.IsNotFound(err) {
+			return condition(registryCredentialsCondition, false, "NotCopied", fmt.Sprintf("secret %s is missing", copyName(cred))), nil
+		}
+		if err != nil {
+			return metav1.Condition{}, err
+		}
+	}
+	return condition(registryCredentialsCondition, true, "Copied", "registry credentials are in the namespace"), nil
+}
+
+// copyName returns the name of the copies of cred.
+func copyName(cred config.RegistryCredential) string {
+	if cred.Name != "" {
+		return cred.Name
+	}
+	return cred.SecretRef.Name
+}
+
+// registryCredentialSecrets returns the copies of the registry credentials
+// of the operator config, which are added to the pull secrets of every pod.
+func (b *base) registryCredentialSecrets() []corev1.LocalObjectReference {
+	cfg := b.Config.Get()
+	if !cfg.DriverEnabled(registryCredentialsDriver) {
+		return nil
+	}
+	var refs []corev1.LocalObjectReference
+	for _, cred := range cfg.RegistryCredentials {
+		refs = append(refs, corev1.LocalObjectReference{Name: copyName(cred)})
+	}
+	return refs
+}
+
+// IsRegistryCredential reports whether secret is the source of one of the
+// registry credentials of cfg.
+func IsRegistryCredential(cfg *config.OperatorConfig, secret client.Object) bool {
+	for _, cred := range cfg.RegistryCredentials {
+		if cred.SecretRef.Namespace == secret.GetNamespace() && cred.SecretRef.Name == secret.GetName() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/podsecurity"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	profileBaseline      = "baseline"
+	profileCustom        = "custom"
+	podSecurityCondition = "PodSecurity"
+)
+
+// applySecurity renders the security contexts of the profile of app onto
+// spec. Containers that already have a security context, like some sidecar
+// presets, keep theirs.
+func applySecurity(app *appv1alpha1.Application, spec *corev1.PodSpec) {
+	security := app.Spec.Security
+	if security == nil {
+		return
+	}
+	var (
+		pod       *corev1.PodSecurityContext
+		container *corev1.SecurityContext
+		readOnly  bool
+	)
+	runtimeDefault := &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	noEscalation, nonRoot := false, true
+	switch security.Profile {
+	case profileCustom:
+		pod, container = security.PodSecurityContext.DeepCopy(), security.ContainerSecurityContext
+		readOnly = container != nil && container.ReadOnlyRootFilesystem != nil && *container.ReadOnlyRootFilesystem
+	case profileBaseline:
+		pod = &corev1.PodSecurityContext{SeccompProfile: runtimeDefault}
+		container = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &noEscalation,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"NET_RAW"}},
+		}
+	default:
+		pod = &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, SeccompProfile: runtimeDefault}
+		container = &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &noEscalation,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		}
+		readOnly = true
+	}
+	if security.Profile != profileCustom {
+		pod.RunAsUser, pod.FSGroup = security.RunAsUser, security.FSGroup
+		if security.ReadOnlyRootFilesystem != nil {
+			readOnly = *security.ReadOnlyRootFilesystem
+		}
+		container.ReadOnlyRootFilesystem = &readOnly
+	}
+	spec.SecurityContext = pod
+
+	var mounts []corev1.VolumeMount
+	if readOnly {
+		paths := security.WritablePaths
+		if len(paths) == 0 {
+			paths = []string{"/tmp"}
+		}
+		for i, path := range paths {
+			name := fmt.Sprintf("writable-%d", i)
+			spec.Volumes = append(spec.Volumes, corev1.Volume{
+				Name:         name,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			})
+			mounts = append(mounts, corev1.VolumeMount{Name: name, MountPath: path})
+		}
+	}
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if containers[i].SecurityContext == nil && container != nil {
+				containers[i].SecurityContext = container.DeepCopy()
+			}
+			containers[i].VolumeMounts = append(containers[i].VolumeMounts, mounts...)
+		}
+	}
+}
+
+// checkPodSecurity checks the pods app would run against the level Pod
+// Security Admission enforces on its namespace. It returns nil when the
+// namespace enforces no level or cannot be read. The Namespace is read past
+// the cache, which only holds the watched namespaces' objects when the
+// operator is not cluster-wide.
+func (a *ApplicationClient) checkPodSecurity(ctx context.Context, app *appv1alpha1.Application, drivers []ResourceDriver) (*metav1.Condition, error) {
+	ns := &corev1.Namespace{}
+	if err := a.getUncached(ctx, client.ObjectKey{Name: app.Namespace}, ns); err != nil {
+		if errors.IsForbidden(err) {
+			log.Debug(ctx, "cannot read the pod security level of the namespace", zap.Error(err))
+			return nil, nil
+		}
+		return nil, client.IgnoreNotFound(err)
+	}
+	level := podsecurity.Level(ns)
+	if level == podsecurity.Privileged {
+		return nil, nil
+	}
+	var violations []string
+	for _, d := range drivers {
+		r, ok := d.(PodRenderer)
+		if !ok {
+			continue
+		}
+		desired, err := d.Render(ctx, app)
+		if err != nil {
+			return nil, err
+		}
+		if desired == nil {
+			continue
+		}
+		_, spec := r.Pods(desired)
+		violations = append(violations, podsecurity.Check(level, spec)...)
+	}
+	if len(violations) > 0 {
+		violation := condition(podSecurityCondition, false, "Violation",
+			fmt.Sprintf("pods violate the %s level enforced on namespace %s: %s", level, app.Namespace, strings.Join(violations, "; ")))
+		return &violation, nil
+	}
+	allowed := condition(podSecurityCondition, true, "Allowed",
+		fmt.Sprintf("pods meet the %s level enforced on namespace %s", level, app.Namespace))
+	return &allowed, nil
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type ServiceDriver struct {
+	base
+}
+
+func (d *ServiceDriver) Name() string {
+	return "service"
+}
+
+func (d *ServiceDriver) Object() client.Object {
+	return &corev1.Service{}
+}
+
+func (d *ServiceDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	if app.Spec.Service.Enabled != nil && !*app.Spec.Service.Enabled {
+		return nil, nil
+	}
+	service := &corev1.Service{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Port:       app.Spec.App.ContainerPort,
+					TargetPort: intstr.FromInt(int(app.Spec.App.ContainerPort)),
+				},
+			},
+			Selector: selectorLabels(app),
+		},
+	}
+	_, exposed, err := d.sidecars(app)
+	if err != nil {
+		return nil, err
+	}
+	service.Spec.Ports = append(service.Spec.Ports, exposed...)
+	service.Annotations = merge(service.Annotations, app.Spec.Service.Annotations)
+	return service, nil
+}
+
+func (d *ServiceDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*corev1.Service), current.(*corev1.Service)
+	return equality.Semantic.DeepDerivative(want.Spec.Ports, have.Spec.Ports) &&
+		equality.Semantic.DeepEqual(want.Spec.Selector, have.Spec.Selector)
+}
+
+func (d *ServiceDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	want, have := desired.(*corev1.Service), &corev1.Service{}
+	return d.apply(ctx, app, d, want, have, func() {
+		have.Spec.Ports = want.Spec.Ports
+		have.Spec.Selector = want.Spec.Selector
+	})
+}
+
+func (d *ServiceDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.delete(ctx, app, d)
+}
+
+func (d *ServiceDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	found, err := d.get(ctx, app, &corev1.Service{})
+	if err != nil || !found {
+		return condition("ServiceReady", false, "NotFound", "service does not exist"), err
+	}
+	return condition("ServiceReady", true, "Created", "service exists"), nil
+}
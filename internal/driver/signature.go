@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/project"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+	"github.com/cloud-club/cloudclub-operator/internal/signature"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const signatureCondition = "SignatureVerificationFailed"
+
+// signatureKeys returns the keys trusted to sign the image of app: those of
+// the operator config and of the Project of app.
+func (a *ApplicationClient) signatureKeys(ctx context.Context) ([]signature.Key, error) {
+	var keys []signature.Key
+	for _, k := range a.Config.Get().ImageSignatures.Keys {
+		key, err := signature.ParseKey(k.Name, k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if proj := project.FromContext(ctx); proj != nil {
+		for _, k := range proj.Spec.SignatureKeys {
+			key, err := signature.ParseKey(k.Name, k.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %w", proj.Name, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature checks that the digest app is about to run is signed by a
+// trusted key, before any driver rolls it out. A verified digest is
+// recorded in the status, rendered in place of the image it resolved from,
+// and not verified again while the key that signed it stays trusted. The
+// returned condition is True when the image must not run and nil when no
+// key is trusted.
+func (a *ApplicationClient) verifySignature(ctx context.Context, app *appv1alpha1.Application, now time.Time) (*metav1.Condition, error) {
+	keys, err := a.signatureKeys(ctx)
+	if err != nil {
+		c := condition(signatureCondition, true, "InvalidKey", err.Error())
+		return &c, nil
+	}
+	if len(keys) == 0 {
+		if app.Status.Signature == nil {
+			return nil, nil
+		}
+		app.Status.Signature = nil
+		recordWrite(ctx)
+		return nil, a.Kubernetes.Status().Update(ctx, app)
+	}
+
+	image := pinnedImage(app)
+	ref := registry.ParseReference(image)
+	digest := ref.Digest
+	if digest == "" {
+		if digest, err = a.resolveImage(ctx, app, image); err != nil {
+			return a.signatureFailed(ctx, image, err), nil
+		}
+	}
+	verified := ref.Name() + "@" + digest
+	if s := app.Status.Signature; s != nil && s.Image == verified && s.Reference == image {
+		for _, key := range keys {
+			if key.Name == s.Key {
+				c := condition(signatureCondition, false, "Verified", fmt.Sprintf("%s is signed by %s", verified, s.Key))
+				return &c, nil
+			}
+		}
+	}
+
+	creds, err := a.pullCredentials(ctx, app, image)
+	if err != nil {
+		return a.signatureFailed(ctx, image, err), nil
+	}
+	key, err := signature.Verify(ctx, a.registry(), image, digest, creds, keys)
+	if err != nil {
+		return a.signatureFailed(ctx, image, err), nil
+	}
+	app.Status.Signature = &appv1alpha1.SignatureStatus{Image: verified, Reference: image, Key: key, VerifiedAt: metav1.NewTime(now)}
+	recordWrite(ctx)
+	if err := a.Kubernetes.Status().Update(ctx, app); err != nil {
+		return nil, err
+	}
+	c := condition(signatureCondition, false, "Verified", fmt.Sprintf("%s is signed by %s", verified, key))
+	return &c, nil
+}
+
+func (a *ApplicationClient) signatureFailed(ctx context.Context, image string, err error) *metav1.Condition {
+	log.Warn(ctx, "image signature verification failed", zap.String("image", image), zap.Error(err))
+	c := condition(signatureCondition, true, "NotVerified", err.Error())
+	return &c
+}
@@ -0,0 +1,202 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StatefulSetDriver runs the pods of Applications with workloadKind
+// StatefulSet, together with the headless Service named <name>-headless
+// that gives each replica a stable network identity.
+type StatefulSetDriver struct {
+	base
+}
+
+func (d *StatefulSetDriver) Name() string {
+	return "statefulset"
+}
+
+func (d *StatefulSetDriver) Object() client.Object {
+	return &v1.StatefulSet{}
+}
+
+func (d *StatefulSetDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	if workloadKind(app) != WorkloadStatefulSet {
+		return nil, nil
+	}
+	template, err := d.workloadTemplate(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+	workload := app.Spec.Workload
+	statefulSet := &v1.StatefulSet{
+		ObjectMeta: d.objectMeta(ctx, app),
+		Spec: v1.StatefulSetSpec{
+			Replicas: app.Spec.App.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorLabels(app),
+			},
+			Template:            template,
+			ServiceName:         headlessServiceName(app),
+			PodManagementPolicy: v1.OrderedReadyPodManagement,
+			UpdateStrategy: v1.StatefulSetUpdateStrategy{
+				Type:          v1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &v1.RollingUpdateStatefulSetStrategy{Partition: workload.Partition},
+			},
+		},
+	}
+	if workload.PodManagementPolicy != "" {
+		statefulSet.Spec.PodManagementPolicy = v1.PodManagementPolicyType(workload.PodManagementPolicy)
+	}
+	retention := v1.DeletePersistentVolumeClaimRetentionPolicyType
+	for _, volume := range app.Spec.Storage.Volumes {
+		spec := volume.PersistentVolumeClaim
+		if spec == nil {
+			continue
+		}
+		if spec.RetainPolicy == retainPolicy {
+			retention = v1.RetainPersistentVolumeClaimRetentionPolicyType
+		}
+		accessModes := spec.AccessModes
+		if len(accessModes) == 0 {
+			accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+		}
+		statefulSet.Spec.VolumeClaimTemplates = append(statefulSet.Spec.VolumeClaimTemplates, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: volume.Name, Labels: selectorLabels(app)},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      accessModes,
+				StorageClassName: spec.StorageClassName,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: spec.Size},
+				},
+			},
+		})
+	}
+	if len(statefulSet.Spec.VolumeClaimTemplates) > 0 {
+		statefulSet.Spec.PersistentVolumeClaimRetentionPolicy = &v1.StatefulSetPersistentVolumeClaimRetentionPolicy{
+			WhenDeleted: retention,
+			WhenScaled:  v1.RetainPersistentVolumeClaimRetentionPolicyType,
+		}
+	}
+	if app.Spec.Scheduler.HPA.Enabled {
+		statefulSet.Annotations = map[string]string{hpaAnnotation: "true"}
+	}
+	return statefulSet, annotateTemplateHash(statefulSet)
+}
+
+func headlessServiceName(app *appv1alpha1.Application) string {
+	return app.Name + "-headless"
+}
+
+// headlessService publishes a DNS record per replica of app.
+func (d *StatefulSetDriver) headlessService(ctx context.Context, app *appv1alpha1.Application) *corev1.Service {
+	meta := d.objectMeta(ctx, app)
+	meta.Name = headlessServiceName(app)
+	service := &corev1.Service{
+		ObjectMeta: meta,
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 selectorLabels(app),
+			PublishNotReadyAddresses: true,
+		},
+	}
+	if app.Spec.App.ContainerPort != 0 {
+		service.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: app.Spec.App.ContainerPort}}
+	}
+	return service
+}
+
+// Compare ignores the pod management policy and the volume claim templates,
+// which cannot be changed once the StatefulSet exists.
+func (d *StatefulSetDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*v1.StatefulSet), current.(*v1.StatefulSet)
+	if hpaEnabled(want) != hpaEnabled(have) {
+		return false
+	}
+	if !hpaEnabled(want) && want.Spec.Replicas != nil &&
+		(have.Spec.Replicas == nil || *want.Spec.Replicas != *have.Spec.Replicas) {
+		return false
+	}
+	return equality.Semantic.DeepDerivative(want.Spec.UpdateStrategy, have.Spec.UpdateStrategy) &&
+		equality.Semantic.DeepDerivative(want.Spec.PersistentVolumeClaimRetentionPolicy, have.Spec.PersistentVolumeClaimRetentionPolicy) &&
+		equality.Semantic.DeepDerivative(want.Spec.Template, have.Spec.Template)
+}
+
+func (d *StatefulSetDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	wantService, haveService := d.headlessService(ctx, app), &corev1.Service{}
+	err := d.apply(ctx, app, &ServiceDriver{base: d.base}, wantService, haveService, func() {
+		haveService.Spec.Ports = wantService.Spec.Ports
+		haveService.Spec.Selector = wantService.Spec.Selector
+		haveService.Spec.PublishNotReadyAddresses = wantService.Spec.PublishNotReadyAddresses
+	})
+	if err != nil {
+		return err
+	}
+	want, have := desired.(*v1.StatefulSet), &v1.StatefulSet{}
+	return d.apply(ctx, app, d, want, have, func() {
+		if hpaEnabled(want) {
+			metav1.SetMetaDataAnnotation(&have.ObjectMeta, hpaAnnotation, "true")
+		} else {
+			delete(have.Annotations, hpaAnnotation)
+			have.Spec.Replicas = want.Spec.Replicas
+		}
+		have.Spec.UpdateStrategy = want.Spec.UpdateStrategy
+		have.Spec.PersistentVolumeClaimRetentionPolicy = want.Spec.PersistentVolumeClaimRetentionPolicy
+		have.Spec.Template = want.Spec.Template
+	})
+}
+
+func (d *StatefulSetDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	if err := d.delete(ctx, app, d); err != nil {
+		return err
+	}
+	return d.deleteNamed(ctx, app, &ServiceDriver{base: d.base}, headlessServiceName(app))
+}
+
+func (d *StatefulSetDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	return d.reconcileWorkload(ctx, app, d, WorkloadStatefulSet, &v1.Deployment{})
+}
+
+func (d *StatefulSetDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	if blocked, err := d.migrationBlocked(ctx, app, &v1.Deployment{}); err != nil || blocked {
+		return migrationCondition("StatefulSetReady"), err
+	}
+	statefulSet := &v1.StatefulSet{}
+	found, err := d.get(ctx, app, statefulSet)
+	if err != nil || !found {
+		return condition("StatefulSetReady", false, "NotFound", "stateful set does not exist"), err
+	}
+	want := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		want = *statefulSet.Spec.Replicas
+	}
+	message := fmt.Sprintf("%d/%d replicas ready, %d updated", statefulSet.Status.ReadyReplicas, want, statefulSet.Status.UpdatedReplicas)
+	if !workloadReady(statefulSet) {
+		return condition("StatefulSetReady", false, "Progressing", message), nil
+	}
+	return condition("StatefulSetReady", true, "Ready", message), nil
+}
+
+func (d *StatefulSetDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*v1.StatefulSet), new.(*v1.StatefulSet)
+	return o.Status.ObservedGeneration != n.Status.ObservedGeneration ||
+		o.Status.ReadyReplicas != n.Status.ReadyReplicas ||
+		o.Status.UpdatedReplicas != n.Status.UpdatedReplicas ||
+		o.Status.CurrentRevision != n.Status.CurrentRevision
+}
+
+func (d *StatefulSetDriver) Pods(obj client.Object) (int32, corev1.PodSpec) {
+	statefulSet := obj.(*v1.StatefulSet)
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	return replicas, statefulSet.Spec.Template.Spec
+}
@@ -0,0 +1,274 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// applicationLabel marks the claims created for an Application, which
+	// outlive it when they are retained.
+	applicationLabel = "app.cloudclub.com/application"
+	retainPolicy     = "Retain"
+)
+
+// StorageDriver creates a PersistentVolumeClaim named <name>-<volume> for
+// each claim volume of an Application and expands it when its size grows.
+// Claims are owned by the Application unless they are retained.
+type StorageDriver struct {
+	base
+}
+
+func (d *StorageDriver) Name() string {
+	return "storage"
+}
+
+func (d *StorageDriver) Object() client.Object {
+	return &corev1.PersistentVolumeClaim{}
+}
+
+// Render returns nil: the claims are rendered by Reconcile.
+func (d *StorageDriver) Render(ctx context.Context, app *appv1alpha1.Application) (client.Object, error) {
+	return nil, nil
+}
+
+func (d *StorageDriver) Reconcile(ctx context.Context, app *appv1alpha1.Application) (bool, error) {
+	wanted := map[string]bool{}
+	for _, volume := range app.Spec.Storage.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim := d.renderClaim(ctx, app, volume)
+		wanted[claim.Name] = true
+		// A StatefulSet creates one claim per replica from its templates.
+		// Claims created before switching to it are kept, not applied.
+		if workloadKind(app) == WorkloadStatefulSet {
+			continue
+		}
+		if err := d.applyClaim(ctx, app, claim, volume.PersistentVolumeClaim.RetainPolicy == retainPolicy); err != nil {
+			return true, err
+		}
+	}
+	return len(wanted) > 0, d.deleteClaims(ctx, app, wanted)
+}
+
+func (d *StorageDriver) renderClaim(ctx context.Context, app *appv1alpha1.Application, volume appv1alpha1.StorageVolume) *corev1.PersistentVolumeClaim {
+	spec := volume.PersistentVolumeClaim
+	accessModes := spec.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	meta := d.objectMeta(ctx, app)
+	meta.Name = claimName(app, volume.Name)
+	meta.Labels = merge(meta.Labels, map[string]string{applicationLabel: app.Name})
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: meta,
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      accessModes,
+			StorageClassName: spec.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: spec.Size},
+			},
+		},
+	}
+}
+
+func claimName(app *appv1alpha1.Application, volume string) string {
+	return app.Name + "-" + volume
+}
+
+// applyClaim creates want, or expands the existing claim to its size and
+// moves it in or out of the ownership of app according to retain. The
+// other fields of a claim are immutable once created. An expansion the
+// API server rejects is not an error; Status reports it instead.
+func (d *StorageDriver) applyClaim(ctx context.Context, app *appv1alpha1.Application, want *corev1.PersistentVolumeClaim, retain bool) error {
+	hash, err := specHash(want)
+	if err != nil {
+		return err
+	}
+	want.Annotations = merge(want.Annotations, map[string]string{SpecHashAnnotation: hash})
+	have := &corev1.PersistentVolumeClaim{}
+	err = d.Kubernetes.Get(ctx, client.ObjectKeyFromObject(want), have)
+	if errors.IsNotFound(err) {
+		if !retain {
+			if err := ctrl.SetControllerReference(app, want, d.Schema); err != nil {
+				return err
+			}
+		}
+		log.Info(ctx, "creating storage", zap.String("claim", want.Name))
+		recordWrite(ctx)
+		return d.Kubernetes.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := have.Annotations[SpecHashAnnotation] != hash
+	owned := metav1.IsControlledBy(have, app)
+	switch {
+	case retain && owned:
+		refs := have.OwnerReferences[:0]
+		for _, ref := range have.OwnerReferences {
+			if ref.UID != app.UID {
+				refs = append(refs, ref)
+			}
+		}
+		have.OwnerReferences = refs
+		changed = true
+	case !retain && !owned:
+		if err := ctrl.SetControllerReference(app, have, d.Schema); err != nil {
+			return err
+		}
+		changed = true
+	}
+	moved := changed
+	wantSize, haveSize := want.Spec.Resources.Requests[corev1.ResourceStorage], have.Spec.Resources.Requests[corev1.ResourceStorage]
+	expand := wantSize.Cmp(haveSize) > 0
+	if expand {
+		log.Info(ctx, "expanding storage", zap.String("claim", want.Name),
+			zap.String("from", haveSize.String()), zap.String("to", wantSize.String()))
+		have.Spec.Resources.Requests[corev1.ResourceStorage] = wantSize
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	have.Labels = merge(have.Labels, want.Labels)
+	have.Annotations = merge(have.Annotations, want.Annotations)
+	recordWrite(ctx)
+	err = d.Kubernetes.Update(ctx, have)
+	if expand && (errors.IsForbidden(err) || errors.IsInvalid(err)) {
+		// The storage class does not allow expansion. Status reports it and
+		// the other drivers keep reconciling; the expansion is retried.
+		log.Warn(ctx, "not expanding storage: rejected by the API server", zap.String("claim", want.Name), zap.Error(err))
+		if !moved {
+			return nil
+		}
+		have.Spec.Resources.Requests[corev1.ResourceStorage] = haveSize
+		recordWrite(ctx)
+		return d.Kubernetes.Update(ctx, have)
+	}
+	return err
+}
+
+// deleteClaims deletes the claims owned by app that are not in wanted.
+// Retained claims are not owned by app and are left alone.
+func (d *StorageDriver) deleteClaims(ctx context.Context, app *appv1alpha1.Application, wanted map[string]bool) error {
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := d.Kubernetes.List(ctx, claims, client.InNamespace(app.Namespace), client.MatchingLabels{applicationLabel: app.Name}); err != nil {
+		return err
+	}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if wanted[claim.Name] || !metav1.IsControlledBy(claim, app) {
+			continue
+		}
+		log.Info(ctx, "deleting storage", zap.String("claim", claim.Name))
+		recordWrite(ctx)
+		if err := client.IgnoreNotFound(d.Kubernetes.Delete(ctx, claim)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *StorageDriver) Compare(desired, current client.Object) bool {
+	want, have := desired.(*corev1.PersistentVolumeClaim), current.(*corev1.PersistentVolumeClaim)
+	wantSize, haveSize := want.Spec.Resources.Requests[corev1.ResourceStorage], have.Spec.Resources.Requests[corev1.ResourceStorage]
+	return wantSize.Cmp(haveSize) <= 0
+}
+
+func (d *StorageDriver) Apply(ctx context.Context, app *appv1alpha1.Application, desired client.Object) error {
+	_, err := d.Reconcile(ctx, app)
+	return err
+}
+
+func (d *StorageDriver) Delete(ctx context.Context, app *appv1alpha1.Application) error {
+	return d.deleteClaims(ctx, app, nil)
+}
+
+func (d *StorageDriver) Status(ctx context.Context, app *appv1alpha1.Application) (metav1.Condition, error) {
+	if workloadKind(app) == WorkloadStatefulSet {
+		return condition("StorageReady", true, "VolumeClaimTemplates", "claims are created by the stateful set"), nil
+	}
+	var pending, shrunk, rejected []string
+	for _, volume := range app.Spec.Storage.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim := &corev1.PersistentVolumeClaim{}
+		err := d.Kubernetes.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: claimName(app, volume.Name)}, claim)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return condition("StorageReady", false, "NotFound", fmt.Sprintf("claim for volume %s does not exist", volume.Name)), nil
+			}
+			return condition("StorageReady", false, "NotFound", err.Error()), err
+		}
+		if claim.Status.Phase != corev1.ClaimBound {
+			pending = append(pending, claim.Name)
+		}
+		requested := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch volume.PersistentVolumeClaim.Size.Cmp(requested) {
+		case -1:
+			shrunk = append(shrunk, claim.Name)
+		case 1:
+			rejected = append(rejected, claim.Name)
+		}
+	}
+	if len(rejected) > 0 {
+		return condition("StorageReady", false, "ExpansionRejected",
+			"the storage class does not allow expanding claims: "+strings.Join(rejected, ",")), nil
+	}
+	if len(shrunk) > 0 {
+		return condition("StorageReady", false, "ShrinkNotSupported",
+			"claims cannot be shrunk: "+strings.Join(shrunk, ",")), nil
+	}
+	if len(pending) > 0 {
+		return condition("StorageReady", false, "Pending", "claims are not bound: "+strings.Join(pending, ",")), nil
+	}
+	return condition("StorageReady", true, "Bound", "all claims are bound"), nil
+}
+
+func (d *StorageDriver) StatusChanged(old, new client.Object) bool {
+	o, n := old.(*corev1.PersistentVolumeClaim), new.(*corev1.PersistentVolumeClaim)
+	return o.Status.Phase != n.Status.Phase
+}
+
+// storageProjection returns the volumes and mounts of the storage of app.
+func storageProjection(app *appv1alpha1.Application) ([]corev1.Volume, []corev1.VolumeMount) {
+	var (
+		volumes []corev1.Volume
+		mounts  []corev1.VolumeMount
+	)
+	for _, v := range app.Spec.Storage.Volumes {
+		volume := corev1.Volume{Name: v.Name}
+		switch {
+		case v.PersistentVolumeClaim != nil && workloadKind(app) == WorkloadStatefulSet:
+			// Provided by the volume claim template of the same name.
+			mounts = append(mounts, corev1.VolumeMount{Name: v.Name, MountPath: v.MountPath, ReadOnly: v.ReadOnly})
+			continue
+		case v.PersistentVolumeClaim != nil:
+			volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName(app, v.Name),
+				ReadOnly:  v.ReadOnly,
+			}
+		case v.EmptyDir != nil:
+			volume.EmptyDir = v.EmptyDir
+		default:
+			volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		}
+		volumes = append(volumes, volume)
+		mounts = append(mounts, corev1.VolumeMount{Name: v.Name, MountPath: v.MountPath, ReadOnly: v.ReadOnly})
+	}
+	return volumes, mounts
+}
@@ -0,0 +1,155 @@
+package driver
+
+import (
+	"context"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// templateHashAnnotation on a workload holds the hash of the pod template
+// it was rendered with. The live template is defaulted by the API server,
+// so the hooks compare this hash rather than the templates themselves.
+const templateHashAnnotation = "app.cloudclub.com/template-hash"
+
+// Workload kinds an Application can run as.
+const (
+	WorkloadDeployment  = "Deployment"
+	WorkloadStatefulSet = "StatefulSet"
+)
+
+func workloadKind(app *appv1alpha1.Application) string {
+	if app.Spec.WorkloadKind == "" {
+		return WorkloadDeployment
+	}
+	return app.Spec.WorkloadKind
+}
+
+// workloadTemplate renders the pod template of the workload of app,
+// annotated with the hash of its config.
+func (b *base) workloadTemplate(ctx context.Context, app *appv1alpha1.Application) (corev1.PodTemplateSpec, error) {
+	template, err := b.podTemplate(ctx, app)
+	if err != nil {
+		return template, err
+	}
+	return template, b.annotateConfigHash(ctx, app, &template)
+}
+
+// annotateTemplateHash sets the hash of the pod template of the rendered
+// workload on it.
+func annotateTemplateHash(workload client.Object) error {
+	hash, err := hashOf(*podTemplateOf(workload))
+	if err != nil {
+		return err
+	}
+	workload.SetAnnotations(merge(workload.GetAnnotations(), map[string]string{templateHashAnnotation: hash}))
+	return nil
+}
+
+// sameTemplate reports whether the live workload current was last written
+// with the pod template of desired.
+func sameTemplate(desired, current client.Object) bool {
+	hash := current.GetAnnotations()[templateHashAnnotation]
+	return hash != "" && hash == desired.GetAnnotations()[templateHashAnnotation]
+}
+
+// podTemplateOf returns the pod template of a Deployment or StatefulSet.
+func podTemplateOf(obj client.Object) *corev1.PodTemplateSpec {
+	switch w := obj.(type) {
+	case *v1.Deployment:
+		return &w.Spec.Template
+	case *v1.StatefulSet:
+		return &w.Spec.Template
+	}
+	return nil
+}
+
+// reconcileWorkload applies the workload d renders when app runs as kind,
+// gated by its pre-deploy hook, and retires it when app runs as another
+// kind. Without spec.workload.allowMigration an existing workload of the
+// other kind, other, is left untouched and the new one is not created; with
+// it the old workload is only deleted once its replacement is ready.
+func (b *base) reconcileWorkload(ctx context.Context, app *appv1alpha1.Application, d ResourceDriver, kind string, other client.Object) (bool, error) {
+	if workloadKind(app) == kind {
+		blocked, err := b.migrationBlocked(ctx, app, other)
+		if err != nil || blocked {
+			return true, err
+		}
+		desired, err := d.Render(ctx, app)
+		if err != nil {
+			return true, err
+		}
+		if done, err := b.preDeploy(ctx, app, d, desired); err != nil || !done {
+			return true, err
+		}
+		if err := d.Apply(ctx, app, desired); err != nil {
+			return true, err
+		}
+		return true, b.postDeploy(ctx, app, d, desired)
+	}
+
+	current := d.Object()
+	found, err := b.get(ctx, app, current)
+	if err != nil || !found || !metav1.IsControlledBy(current, app) || !app.Spec.Workload.AllowMigration {
+		return false, err
+	}
+	replacement := other.DeepCopyObject().(client.Object)
+	found, err = b.get(ctx, app, replacement)
+	if err != nil || !found || !workloadReady(replacement) {
+		log.Info(ctx, "waiting for the replacement workload before deleting the old one")
+		return false, err
+	}
+	return false, d.Delete(ctx, app)
+}
+
+// migrationBlocked reports whether app switched to another workload kind
+// without allowing migration while its workload of kind other still exists.
+func (b *base) migrationBlocked(ctx context.Context, app *appv1alpha1.Application, other client.Object) (bool, error) {
+	if app.Spec.Workload.AllowMigration {
+		return false, nil
+	}
+	current := other.DeepCopyObject().(client.Object)
+	found, err := b.get(ctx, app, current)
+	if err != nil || !found {
+		return false, err
+	}
+	return metav1.IsControlledBy(current, app), nil
+}
+
+func migrationCondition(conditionType string) metav1.Condition {
+	return condition(conditionType, false, "MigrationNotAllowed",
+		"the workload kind changed; set spec.workload.allowMigration to replace the existing workload")
+}
+
+// workloadReady reports whether every replica of a Deployment or
+// StatefulSet runs its current template and no replica of an older one is
+// left. A partitioned StatefulSet only updates the replicas at or above its
+// partition, so it is ready once those do.
+func workloadReady(obj client.Object) bool {
+	switch w := obj.(type) {
+	case *v1.Deployment:
+		want := int32(1)
+		if w.Spec.Replicas != nil {
+			want = *w.Spec.Replicas
+		}
+		return w.Status.ObservedGeneration >= w.Generation && w.Status.Replicas == w.Status.UpdatedReplicas &&
+			w.Status.UpdatedReplicas == want && w.Status.AvailableReplicas == want
+	case *v1.StatefulSet:
+		want := int32(1)
+		if w.Spec.Replicas != nil {
+			want = *w.Spec.Replicas
+		}
+		if w.Status.ObservedGeneration < w.Generation || w.Status.ReadyReplicas != want {
+			return false
+		}
+		if r := w.Spec.UpdateStrategy.RollingUpdate; r != nil && r.Partition != nil && *r.Partition > 0 {
+			return w.Status.UpdatedReplicas >= want-*r.Partition
+		}
+		return w.Status.CurrentRevision == w.Status.UpdateRevision && w.Status.UpdatedReplicas == want
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Package imagepolicy checks image references against the image policy of
+// the operator config.
+package imagepolicy
+
+import (
+	"fmt"
+
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+)
+
+// Check returns the ways image violates cfg. The tag rules of the image
+// policy only apply when strict, that is in the namespaces it selects.
+func Check(cfg *config.OperatorConfig, image string, strict bool) []string {
+	var violations []string
+	ref := registry.ParseReference(image)
+	if allowed := cfg.AllowedRegistries; len(allowed) > 0 && !contains(allowed, ref.Registry) {
+		violations = append(violations, fmt.Sprintf("image %s is not from an allowed registry %v", image, allowed))
+	}
+	if !strict {
+		return violations
+	}
+	policy := cfg.ImagePolicy
+	if policy.RequireDigest && ref.Digest == "" {
+		violations = append(violations, fmt.Sprintf("image %s must be pinned by digest", image))
+	}
+	if ref.Digest == "" {
+		tag := ref.Tag
+		if tag == "" {
+			tag = "latest"
+		}
+		mutable := policy.MutableTags
+		if len(mutable) == 0 {
+			mutable = []string{"latest"}
+		}
+		if contains(mutable, tag) {
+			violations = append(violations, fmt.Sprintf("image %s uses the mutable tag %s", image, tag))
+		}
+	}
+	return violations
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package imagepolicy
+
+import (
+	"testing"
+
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	cfg := config.Default()
+	cfg.AllowedRegistries = []string{"ghcr.io", "docker.io"}
+	if v := Check(cfg, "quay.io/app:1", false); len(v) != 1 {
+		t.Errorf("violations = %v, want the registry", v)
+	}
+	if v := Check(cfg, "nginx", false); len(v) != 0 {
+		t.Errorf("violations = %v outside strict namespaces, want none", v)
+	}
+	if v := Check(cfg, "nginx", true); len(v) != 1 {
+		t.Errorf("violations = %v, want the implied latest tag", v)
+	}
+	if v := Check(cfg, "nginx:latest@sha256:abc", true); len(v) != 0 {
+		t.Errorf("violations = %v, want none for a digest", v)
+	}
+
+	cfg.ImagePolicy.RequireDigest = true
+	if v := Check(cfg, "ghcr.io/org/app:1.2", true); len(v) != 1 {
+		t.Errorf("violations = %v, want the missing digest", v)
+	}
+}
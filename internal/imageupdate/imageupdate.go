@@ -0,0 +1,104 @@
+// Package imageupdate selects the newest release of an image among the tags
+// of its repository.
+package imageupdate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Policy selects the tags an image may be updated to. A tag must satisfy
+// every filter that is set, and at least one must be.
+type Policy struct {
+	// Semver is a range like ^1.2 or >=1.0 <2.0. Prereleases only satisfy
+	// ranges that mention one.
+	Semver string
+	// Pattern is a regular expression matched against whole tags.
+	Pattern string
+}
+
+// Latest returns the newest tag allowed by p that is not older than
+// current, and false when no tag is. current, the tag the image runs, is a
+// candidate itself when p allows it, so that tags removed from the
+// repository never roll the image back; it may be empty.
+// Tags are ordered by semantic version. With only a pattern, tags that are
+// not versions are ordered lexically, before all versions.
+func Latest(p Policy, current string, tags []string) (string, bool, error) {
+	if p.Semver == "" && p.Pattern == "" {
+		return "", false, fmt.Errorf("image update policy needs a semver range or a pattern")
+	}
+	var constraint *semver.Constraints
+	if p.Semver != "" {
+		c, err := semver.NewConstraint(p.Semver)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid semver range %q: %w", p.Semver, err)
+		}
+		constraint = c
+	}
+	var pattern *regexp.Regexp
+	if p.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return "", false, fmt.Errorf("invalid pattern %q: %w", p.Pattern, err)
+		}
+		pattern = re
+	}
+
+	if current != "" {
+		tags = append([]string{current}, tags...)
+	}
+	var candidates []candidate
+	for _, tag := range tags {
+		if pattern != nil && !pattern.MatchString(tag) {
+			continue
+		}
+		c := newCandidate(tag)
+		if constraint != nil && (c.version == nil || !constraint.Check(c.version)) {
+			continue
+		}
+		if current != "" && c.less(newCandidate(current)) {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return "", false, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].less(candidates[j])
+	})
+	return candidates[len(candidates)-1].tag, true, nil
+}
+
+// candidate is a tag along with its version, nil when it is not one.
+type candidate struct {
+	tag     string
+	version *semver.Version
+}
+
+func newCandidate(tag string) candidate {
+	version, err := semver.NewVersion(tag)
+	if err != nil {
+		version = nil
+	}
+	return candidate{tag: tag, version: version}
+}
+
+// less orders tags that are not versions lexically, before all versions.
+func (a candidate) less(b candidate) bool {
+	switch {
+	case a.version == nil && b.version == nil:
+		return a.tag < b.tag
+	case a.version == nil || b.version == nil:
+		return a.version == nil
+	case a.version.Equal(b.version):
+		// v1.2.0 and 1.2.0 are the same version; prefer either
+		// consistently.
+		return a.tag < b.tag
+	default:
+		return a.version.LessThan(b.version)
+	}
+}
@@ -0,0 +1,51 @@
+package imageupdate
+
+import "testing"
+
+func TestLatest(t *testing.T) {
+	tags := []string{"1.24.0", "1.25.3", "1.25.10", "1.26.0-rc.1", "v1.26.0", "2.0.0", "latest", "main-0042", "main-0107", "stable"}
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{name: "range", policy: Policy{Semver: "~1.25"}, want: "1.25.10"},
+		{name: "major", policy: Policy{Semver: "^1.0"}, want: "v1.26.0"},
+		{name: "prerelease", policy: Policy{Semver: ">=1.26.0-0 <2.0.0"}, want: "v1.26.0"},
+		{name: "pattern", policy: Policy{Pattern: `main-\d+`}, want: "main-0107"},
+		{name: "both", policy: Policy{Semver: "^1.0", Pattern: `1\.25\..*`}, want: "1.25.10"},
+		{name: "versions first", policy: Policy{Pattern: ".*"}, want: "2.0.0"},
+		{name: "none", policy: Policy{Semver: "^3.0"}},
+	} {
+		got, ok, err := Latest(tc.policy, "", tags)
+		if err != nil || got != tc.want || ok != (tc.want != "") {
+			t.Errorf("%s: Latest = %q, %v, %v, want %q", tc.name, got, ok, err, tc.want)
+		}
+	}
+	for _, p := range []Policy{{}, {Semver: "not a range"}, {Pattern: "("}} {
+		if _, _, err := Latest(p, "", tags); err == nil {
+			t.Errorf("Latest(%+v) accepted an invalid policy", p)
+		}
+	}
+}
+
+func TestLatestNeverDowngrades(t *testing.T) {
+	policy := Policy{Semver: "^1.0"}
+	for _, tc := range []struct {
+		name    string
+		current string
+		tags    []string
+		want    string
+	}{
+		{name: "newer tag", current: "1.1.0", tags: []string{"1.0.0", "1.1.0", "1.2.0"}, want: "1.2.0"},
+		{name: "current tag deleted", current: "1.2.0", tags: []string{"1.0.0", "1.1.0"}, want: "1.2.0"},
+		{name: "no tags left", current: "1.2.0", want: "1.2.0"},
+		{name: "current outside the range", current: "2.0.0", tags: []string{"1.0.0", "1.1.0"}},
+		{name: "current not a version", current: "latest", tags: []string{"1.0.0"}, want: "1.0.0"},
+	} {
+		got, ok, err := Latest(policy, tc.current, tc.tags)
+		if err != nil || got != tc.want || ok != (tc.want != "") {
+			t.Errorf("%s: Latest = %q, %v, %v, want %q", tc.name, got, ok, err, tc.want)
+		}
+	}
+}
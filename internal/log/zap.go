@@ -1,63 +1,108 @@
 package log
 
 import (
-	"os"
+	"context"
+	"net/http"
 
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
-var logger *zap.Logger
-
-func init() {
-	encoderCfg := zap.NewProductionEncoderConfig()
-	encoderCfg.TimeKey = "timestamp"
-	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	config := zap.Config{
-		Level:             zap.NewAtomicLevelAt(zap.DebugLevel),
-		Development:       false,
-		DisableCaller:     true,
-		DisableStacktrace: true,
-		Sampling:          nil,
-		Encoding:          "json",
-		EncoderConfig:     encoderCfg,
-		OutputPaths: []string{
-			"stdout",
-		},
-		ErrorOutputPaths: []string{
-			"stderr",
-		},
+var (
+	level  = zap.NewAtomicLevelAt(zap.InfoLevel)
+	logger = zap.NewNop()
+)
+
+type contextKey struct{}
+
+// New builds the process-wide logger from the manager's --zap-* flags and
+// returns it as a logr.Logger so controller-runtime shares the same
+// configuration. The level is kept in an atomic handle which LevelHandler
+// exposes for runtime changes.
+func New(opts *crzap.Options) logr.Logger {
+	switch l := opts.Level.(type) {
+	case zap.AtomicLevel:
+		level = l
+	case *zap.AtomicLevel:
+		level = *l
+	default:
+		if opts.Development {
+			level = zap.NewAtomicLevelAt(zap.DebugLevel)
+		}
 	}
-	logger = zap.Must(config.Build(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
-		encoderConfig := zap.NewProductionEncoderConfig()
-		encoderConfig.TimeKey = "timestamp"
-		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		encoder := zapcore.NewJSONEncoder(encoderConfig)
-		stdout := zapcore.AddSync(os.Stdout)
-		core := zapcore.NewTee(
-			zapcore.NewCore(encoder, stdout, zapcore.DebugLevel),
-		)
-		return core
-	})))
+	opts.Level = level
+	opts.EncoderConfigOptions = append(opts.EncoderConfigOptions, func(c *zapcore.EncoderConfig) {
+		c.TimeKey = "timestamp"
+	})
+	logger = crzap.NewRaw(crzap.UseFlagOptions(opts))
+	return zapr.NewLogger(logger)
+}
 
+// LevelHandler serves the current log level on GET and changes it on PUT,
+// e.g. `curl -X PUT -d '{"level":"debug"}' .../log-level`.
+func LevelHandler() http.Handler {
+	return level
+}
+
+// FromContext returns the logger carried by ctx. Inside a reconcile this is
+// the controller-runtime logger, which already holds the controller name,
+// the request namespace/name and the reconcileID.
+func FromContext(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return l
+	}
+	if l, err := logr.FromContext(ctx); err == nil {
+		if u, ok := l.GetSink().(zapr.Underlier); ok {
+			return u.GetUnderlying()
+		}
+	}
+	return logger
+}
+
+// IntoContext returns a copy of ctx carrying l.
+func IntoContext(ctx context.Context, l *zap.Logger) context.Context {
+	return ctrllog.IntoContext(context.WithValue(ctx, contextKey{}, l), zapr.NewLogger(l))
+}
+
+// WithValues returns a copy of ctx whose logger has the given fields attached.
+func WithValues(ctx context.Context, fields ...zap.Field) context.Context {
+	return IntoContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// WithObject attaches the namespace, name and generation of obj to every
+// line logged through the returned context.
+func WithObject(ctx context.Context, obj client.Object) context.Context {
+	fields := []zap.Field{zap.Int64("generation", obj.GetGeneration())}
+	if _, err := logr.FromContext(ctx); err != nil {
+		fields = append(fields,
+			zap.String("namespace", obj.GetNamespace()),
+			zap.String("name", obj.GetName()),
+		)
+	}
+	return WithValues(ctx, fields...)
 }
 
-func Info(msg string, fields ...zap.Field) {
-	logger.Info(msg, fields...)
+func Info(ctx context.Context, msg string, fields ...zap.Field) {
+	FromContext(ctx).Info(msg, fields...)
 }
 
-func Error(msg string, fields ...zap.Field) {
-	logger.Error(msg, fields...)
+func Error(ctx context.Context, msg string, fields ...zap.Field) {
+	FromContext(ctx).Error(msg, fields...)
 }
 
-func Errorf(err error, fields ...zap.Field) {
-	logger.Error(err.Error(), fields...)
+func Errorf(ctx context.Context, err error, fields ...zap.Field) {
+	FromContext(ctx).Error(err.Error(), fields...)
 }
 
-func Debug(msg string, fields ...zap.Field) {
-	logger.Debug(msg, fields...)
+func Debug(ctx context.Context, msg string, fields ...zap.Field) {
+	FromContext(ctx).Debug(msg, fields...)
 }
 
-func Warn(msg string, fields ...zap.Field) {
-	logger.Warn(msg, fields...)
+func Warn(ctx context.Context, msg string, fields ...zap.Field) {
+	FromContext(ctx).Warn(msg, fields...)
 }
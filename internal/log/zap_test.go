@@ -0,0 +1,92 @@
+package log
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestLevelHandlerChangesLevel(t *testing.T) {
+	New(&crzap.Options{})
+	handler := LevelHandler()
+
+	put := httptest.NewRecorder()
+	handler.ServeHTTP(put, httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(`{"level":"debug"}`)))
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", put.Code, put.Body)
+	}
+	if !level.Enabled(zapcore.DebugLevel) {
+		t.Error("debug is not enabled after PUT")
+	}
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/log-level", nil))
+	if !strings.Contains(get.Body.String(), `"debug"`) {
+		t.Errorf("GET = %s, want debug", get.Body)
+	}
+}
+
+func TestNewUsesDevelopmentLevel(t *testing.T) {
+	New(&crzap.Options{Development: true})
+	if !level.Enabled(zapcore.DebugLevel) {
+		t.Error("development logger does not log debug")
+	}
+	atomic := zap.NewAtomicLevelAt(zapcore.WarnLevel)
+	New(&crzap.Options{Development: true, Level: atomic})
+	if level.Enabled(zapcore.InfoLevel) {
+		t.Error("--zap-log-level is ignored")
+	}
+}
+
+func TestContextCarriesFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := IntoContext(context.Background(), zap.New(core))
+	ctx = WithValues(ctx, zap.String("driver", "deployment"))
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Generation: 3}}
+	Info(WithObject(ctx, pod), "reconciled")
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	for key, want := range map[string]interface{}{"driver": "deployment", "generation": int64(3)} {
+		if fields[key] != want {
+			t.Errorf("%s = %v, want %v", key, fields[key], want)
+		}
+	}
+	// IntoContext also sets the logr logger, which controller-runtime
+	// already fills with the request, so it is not repeated.
+	if _, ok := fields["name"]; ok {
+		t.Errorf("name is logged twice: %v", fields)
+	}
+	if _, err := logr.FromContext(ctx); err != nil {
+		t.Errorf("logr.FromContext = %v", err)
+	}
+}
+
+func TestFromContextFallsBackToProcessLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger = zap.New(core)
+	t.Cleanup(func() { logger = zap.NewNop() })
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	Warn(WithObject(context.Background(), pod), "orphaned")
+	if logs.Len() != 1 {
+		t.Fatalf("logged %d entries, want 1", logs.Len())
+	}
+	if fields := logs.All()[0].ContextMap(); fields["namespace"] != "default" || fields["name"] != "web" {
+		t.Errorf("fields = %v, want the object key", fields)
+	}
+}
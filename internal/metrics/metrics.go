@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ResultSkipped counts reconciles that found every child up to date and
+	// made no API writes.
+	ResultSkipped = "skipped"
+	// ResultExecuted counts reconciles that created, updated or deleted a
+	// child or updated the Application status.
+	ResultExecuted = "executed"
+)
+
+// ApplicationReconciles counts successful Application reconciles by result.
+var ApplicationReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "cloudclub_application_reconciles_total",
+	Help: "Number of successful Application reconciles, by whether they wrote to the API server.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(ApplicationReconciles)
+}
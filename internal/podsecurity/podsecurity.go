@@ -0,0 +1,177 @@
+// Package podsecurity checks pod specs against the Pod Security Standards
+// that Pod Security Admission enforces on namespaces, so that Applications
+// are rejected before their pods are.
+package podsecurity
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EnforceLabel sets the level Pod Security Admission enforces on the
+	// pods of a namespace.
+	EnforceLabel = "pod-security.kubernetes.io/enforce"
+
+	Privileged = "privileged"
+	Baseline   = "baseline"
+	Restricted = "restricted"
+)
+
+// Level returns the level enforced on ns, privileged when none is.
+func Level(ns *corev1.Namespace) string {
+	switch level := ns.Labels[EnforceLabel]; level {
+	case Baseline, Restricted:
+		return level
+	default:
+		return Privileged
+	}
+}
+
+var (
+	baselineCapabilities = set("AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT")
+	safeSysctls = set("kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.ip_unprivileged_port_start",
+		"net.ipv4.tcp_syncookies", "net.ipv4.ping_group_range")
+	seLinuxTypes = set("", "container_t", "container_init_t", "container_kvm_t")
+)
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
+
+// Check returns the ways spec violates level. The checks are those of the
+// Pod Security Standards that apply to the pod spec itself.
+func Check(level string, spec corev1.PodSpec) []string {
+	if level != Baseline && level != Restricted {
+		return nil
+	}
+	violations := baseline(spec)
+	if level == Restricted {
+		violations = append(violations, restricted(spec)...)
+	}
+	return violations
+}
+
+func containers(spec corev1.PodSpec) []corev1.Container {
+	return append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+}
+
+func baseline(spec corev1.PodSpec) []string {
+	var violations []string
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		violations = append(violations, "pod must not use the host network, PID or IPC namespaces")
+	}
+	for _, v := range spec.Volumes {
+		if v.HostPath != nil {
+			violations = append(violations, fmt.Sprintf("volume %q must not be a hostPath", v.Name))
+		}
+	}
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	if pod.SeccompProfile != nil && pod.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		violations = append(violations, "pod must not set seccompProfile.type=Unconfined")
+	}
+	if o := pod.SELinuxOptions; o != nil && (!seLinuxTypes[o.Type] || o.User != "" || o.Role != "") {
+		violations = append(violations, "pod must not set custom seLinuxOptions")
+	}
+	for _, s := range pod.Sysctls {
+		if !safeSysctls[s.Name] {
+			violations = append(violations, fmt.Sprintf("pod must not set the unsafe sysctl %s", s.Name))
+		}
+	}
+	for _, c := range containers(spec) {
+		for _, p := range c.Ports {
+			if p.HostPort != 0 {
+				violations = append(violations, fmt.Sprintf("container %q must not use hostPort %d", c.Name, p.HostPort))
+			}
+		}
+		sc := c.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			violations = append(violations, fmt.Sprintf("container %q must not be privileged", c.Name))
+		}
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				if !baselineCapabilities[string(capability)] {
+					violations = append(violations, fmt.Sprintf("container %q must not add capability %s", c.Name, capability))
+				}
+			}
+		}
+		if sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			violations = append(violations, fmt.Sprintf("container %q must not set seccompProfile.type=Unconfined", c.Name))
+		}
+		if o := sc.SELinuxOptions; o != nil && (!seLinuxTypes[o.Type] || o.User != "" || o.Role != "") {
+			violations = append(violations, fmt.Sprintf("container %q must not set custom seLinuxOptions", c.Name))
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			violations = append(violations, fmt.Sprintf("container %q must use the default procMount", c.Name))
+		}
+	}
+	return violations
+}
+
+func restricted(spec corev1.PodSpec) []string {
+	var violations []string
+	for _, v := range spec.Volumes {
+		switch {
+		case v.ConfigMap != nil, v.CSI != nil, v.DownwardAPI != nil, v.EmptyDir != nil, v.Ephemeral != nil,
+			v.PersistentVolumeClaim != nil, v.Projected != nil, v.Secret != nil:
+		case v.HostPath != nil:
+			// Reported by the baseline checks.
+		default:
+			violations = append(violations, fmt.Sprintf("volume %q has a type not allowed by the restricted level", v.Name))
+		}
+	}
+	pod := spec.SecurityContext
+	if pod == nil {
+		pod = &corev1.PodSecurityContext{}
+	}
+	if pod.RunAsUser != nil && *pod.RunAsUser == 0 {
+		violations = append(violations, "pod must not set runAsUser=0")
+	}
+	podNonRoot := pod.RunAsNonRoot != nil && *pod.RunAsNonRoot
+	podSeccomp := pod.SeccompProfile != nil && pod.SeccompProfile.Type != corev1.SeccompProfileTypeUnconfined
+	for _, c := range containers(spec) {
+		sc := c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			violations = append(violations, fmt.Sprintf("container %q must set securityContext.allowPrivilegeEscalation=false", c.Name))
+		}
+		if nonRoot := sc.RunAsNonRoot; (nonRoot == nil && !podNonRoot) || (nonRoot != nil && !*nonRoot) {
+			violations = append(violations, fmt.Sprintf("container %q must set securityContext.runAsNonRoot=true", c.Name))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			violations = append(violations, fmt.Sprintf("container %q must not set runAsUser=0", c.Name))
+		}
+		if sc.SeccompProfile == nil && !podSeccomp {
+			violations = append(violations, fmt.Sprintf("container %q must set securityContext.seccompProfile.type to RuntimeDefault or Localhost", c.Name))
+		}
+		dropsAll := false
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Drop {
+				dropsAll = dropsAll || capability == "ALL"
+			}
+			for _, capability := range sc.Capabilities.Add {
+				if capability != "NET_BIND_SERVICE" {
+					violations = append(violations, fmt.Sprintf("container %q may only add capability NET_BIND_SERVICE", c.Name))
+				}
+			}
+		}
+		if !dropsAll {
+			violations = append(violations, fmt.Sprintf("container %q must set securityContext.capabilities.drop=[\"ALL\"]", c.Name))
+		}
+	}
+	return violations
+}
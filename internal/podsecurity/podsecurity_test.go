@@ -0,0 +1,56 @@
+package podsecurity
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckRestricted(t *testing.T) {
+	nonRoot, escalation := true, false
+	spec := corev1.PodSpec{
+		SecurityContext: &corev1.PodSecurityContext{
+			RunAsNonRoot:   &nonRoot,
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		Containers: []corev1.Container{{
+			Name: "app",
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &escalation,
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			},
+		}},
+		Volumes: []corev1.Volume{{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+	}
+	if violations := Check(Restricted, spec); len(violations) != 0 {
+		t.Errorf("hardened pod violates restricted: %v", violations)
+	}
+
+	spec.Containers = append(spec.Containers, corev1.Container{Name: "sidecar"})
+	violations := Check(Restricted, spec)
+	if len(violations) != 2 || !strings.Contains(violations[0], `"sidecar"`) {
+		t.Errorf("violations = %v, want privilege escalation and capabilities of the sidecar", violations)
+	}
+	if violations := Check(Baseline, spec); len(violations) != 0 {
+		t.Errorf("baseline violations = %v, want none", violations)
+	}
+}
+
+func TestCheckBaseline(t *testing.T) {
+	privileged := true
+	spec := corev1.PodSpec{
+		HostNetwork: true,
+		Containers: []corev1.Container{{
+			Name:            "app",
+			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+		}},
+		Volumes: []corev1.Volume{{Name: "docker", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run/docker.sock"}}}},
+	}
+	if violations := Check(Baseline, spec); len(violations) != 3 {
+		t.Errorf("violations = %v, want host namespaces, hostPath and privileged", violations)
+	}
+	if violations := Check(Privileged, spec); len(violations) != 0 {
+		t.Errorf("privileged level reported %v", violations)
+	}
+}
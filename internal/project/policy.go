@@ -0,0 +1,67 @@
+package project
+
+import (
+	"context"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ForNamespace returns the Project namespace is bound to, or nil when it
+// belongs to none.
+func ForNamespace(ctx context.Context, kube client.Reader, namespace string) (*appv1alpha1.Project, error) {
+	projects := &appv1alpha1.ProjectList{}
+	if err := kube.List(ctx, projects); err != nil {
+		return nil, err
+	}
+	for i := range projects.Items {
+		for _, ns := range projects.Items[i].Status.Namespaces {
+			if ns == namespace {
+				return &projects.Items[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AllowsHost reports whether host is one of the allowed domains of p or a
+// subdomain of one. Entries may be written as "*.example.com".
+func AllowsHost(p *appv1alpha1.Project, host string) bool {
+	if len(p.Spec.AllowedDomains) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, domain := range p.Spec.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// IntoContext stores the Project of the Application being reconciled.
+func IntoContext(ctx context.Context, p *appv1alpha1.Project) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the Project stored by IntoContext, or nil.
+func FromContext(ctx context.Context) *appv1alpha1.Project {
+	p, _ := ctx.Value(contextKey{}).(*appv1alpha1.Project)
+	return p
+}
+
+// DefaultLabels returns the labels the Project in ctx adds to managed
+// objects.
+func DefaultLabels(ctx context.Context) map[string]string {
+	if p := FromContext(ctx); p != nil {
+		return p.Spec.DefaultLabels
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/quota"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// Label marks the namespaces bound to a Project with its name.
+	Label = "app.cloudclub.com/project"
+	// AllowAnnotation names the Project an existing namespace may be bound
+	// to. Namespaces created for a Project carry it from the start.
+	AllowAnnotation = "app.cloudclub.com/allowed-project"
+
+	quotaName         = "cloudclub-project"
+	roleBindingPrefix = "cloudclub-project-"
+)
+
+// Roles are the ClusterRoles members can be granted, in binding order.
+var Roles = []string{"admin", "edit", "view"}
+
+// Client binds namespaces to Projects and maintains the RoleBindings and
+// ResourceQuotas generated from them.
+type Client struct {
+	Kubernetes client.Client
+	Schema     *runtime.Scheme
+}
+
+func (c *Client) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	p := &appv1alpha1.Project{}
+	if err := c.Kubernetes.Get(ctx, req.NamespacedName, p); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	ctx = log.WithObject(ctx, p)
+
+	var bound, conflicts, refused []string
+	for _, namespace := range p.Spec.Namespaces {
+		reason, err := c.bind(ctx, p, namespace)
+		if err != nil {
+			log.Errorf(ctx, err)
+			return ctrl.Result{}, err
+		}
+		switch reason {
+		case "":
+			bound = append(bound, namespace)
+		case namespaceConflict:
+			conflicts = append(conflicts, namespace)
+		default:
+			refused = append(refused, namespace)
+		}
+	}
+	if err := c.unbindRemoved(ctx, p, bound); err != nil {
+		log.Errorf(ctx, err)
+		return ctrl.Result{}, err
+	}
+
+	status := p.Status.DeepCopy()
+	status.ObservedGeneration = p.Generation
+	sort.Strings(bound)
+	status.Namespaces = bound
+	ready := metav1.Condition{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Bound", ObservedGeneration: p.Generation,
+		Message: fmt.Sprintf("%d namespaces bound", len(bound))}
+	if len(refused) > 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = namespaceRefused
+		ready.Message = "system namespaces, or existing namespaces not annotated " + AllowAnnotation + "=" + p.Name + ": " + strings.Join(refused, ",")
+	}
+	if len(conflicts) > 0 {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = namespaceConflict
+		ready.Message = "namespaces bound to another project: " + strings.Join(conflicts, ",")
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+	if equality.Semantic.DeepEqual(status, &p.Status) {
+		return ctrl.Result{}, nil
+	}
+	p.Status = *status
+	return ctrl.Result{}, c.Kubernetes.Status().Update(ctx, p)
+}
+
+const (
+	// namespaceConflict reports namespaces bound to another Project.
+	namespaceConflict = "NamespaceConflict"
+	// namespaceRefused reports namespaces a Project may not bind.
+	namespaceRefused = "NamespaceRefused"
+)
+
+// bind labels namespace as belonging to p, creating it when missing, and
+// applies the members and quota of p to it. Existing namespaces are only
+// bound when annotated for p, and system namespaces never are. It returns
+// the reason when namespace is not bound.
+func (c *Client) bind(ctx context.Context, p *appv1alpha1.Project, namespace string) (string, error) {
+	if systemNamespace(namespace) {
+		return namespaceRefused, nil
+	}
+	ns := &corev1.Namespace{}
+	err := c.Kubernetes.Get(ctx, client.ObjectKey{Name: namespace}, ns)
+	switch {
+	case errors.IsNotFound(err):
+		ns = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Labels:      map[string]string{Label: p.Name},
+			Annotations: map[string]string{AllowAnnotation: p.Name},
+		}}
+		log.Info(ctx, "creating namespace", zap.String("namespace", namespace))
+		if err := c.Kubernetes.Create(ctx, ns); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	case ns.Labels[Label] != p.Name:
+		if owner := ns.Labels[Label]; owner != "" {
+			held, err := c.claims(ctx, owner, namespace)
+			if err != nil {
+				return "", err
+			}
+			if held {
+				return namespaceConflict, nil
+			}
+		}
+		if ns.Annotations[AllowAnnotation] != p.Name {
+			log.Warn(ctx, "not binding namespace without opt-in", zap.String("namespace", namespace))
+			return namespaceRefused, nil
+		}
+		if ns.Labels == nil {
+			ns.Labels = map[string]string{}
+		}
+		ns.Labels[Label] = p.Name
+		log.Info(ctx, "binding namespace", zap.String("namespace", namespace))
+		if err := c.Kubernetes.Update(ctx, ns); err != nil {
+			return "", err
+		}
+	case ns.Annotations[AllowAnnotation] != p.Name:
+		// The opt-in was withdrawn.
+		return namespaceRefused, nil
+	}
+	if err := c.roleBindings(ctx, p, namespace); err != nil {
+		return "", err
+	}
+	return "", quota.Apply(ctx, c.Kubernetes, c.Schema, p, namespace, quotaName, map[string]string{Label: p.Name}, p.Spec.Quota)
+}
+
+// systemNamespace reports whether namespace is shared by the cluster, and
+// so never handed to a Project.
+func systemNamespace(namespace string) bool {
+	return namespace == metav1.NamespaceDefault || strings.HasPrefix(namespace, "kube-")
+}
+
+// claims reports whether the Project called name still lists namespace. A
+// namespace whose label points to a deleted Project, or one that no longer
+// lists it, can be bound to another Project.
+func (c *Client) claims(ctx context.Context, name, namespace string) (bool, error) {
+	p := &appv1alpha1.Project{}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Name: name}, p); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	for _, ns := range p.Spec.Namespaces {
+		if ns == namespace {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// unbindRemoved releases the namespaces still labeled for p other than
+// bound, those it no longer lists or may no longer bind, removing what p
+// generated in them. The namespaces are kept.
+func (c *Client) unbindRemoved(ctx context.Context, p *appv1alpha1.Project, bound []string) error {
+	namespaces := &corev1.NamespaceList{}
+	if err := c.Kubernetes.List(ctx, namespaces, client.MatchingLabels{Label: p.Name}); err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, ns := range bound {
+		keep[ns] = true
+	}
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if keep[ns.Name] {
+			continue
+		}
+		log.Info(ctx, "unbinding namespace", zap.String("namespace", ns.Name))
+		for _, role := range Roles {
+			if err := c.deleteOwned(ctx, p, &rbacv1.RoleBinding{}, ns.Name, roleBindingPrefix+role); err != nil {
+				return err
+			}
+		}
+		if err := quota.Delete(ctx, c.Kubernetes, p, ns.Name, quotaName); err != nil {
+			return err
+		}
+		delete(ns.Labels, Label)
+		if err := c.Kubernetes.Update(ctx, ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleBindings binds each role to the members granted it, in namespace.
+func (c *Client) roleBindings(ctx context.Context, p *appv1alpha1.Project, namespace string) error {
+	for _, role := range Roles {
+		subjects := subjectsFor(p, role)
+		name := roleBindingPrefix + role
+		if len(subjects) == 0 {
+			if err := c.deleteOwned(ctx, p, &rbacv1.RoleBinding{}, namespace, name); err != nil {
+				return err
+			}
+			continue
+		}
+		binding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		_, err := controllerutil.CreateOrUpdate(ctx, c.Kubernetes, binding, func() error {
+			binding.Labels = merge(binding.Labels, map[string]string{Label: p.Name})
+			binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role}
+			binding.Subjects = subjects
+			return ctrl.SetControllerReference(p, binding, c.Schema)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subjectsFor(p *appv1alpha1.Project, role string) []rbacv1.Subject {
+	var subjects []rbacv1.Subject
+	for _, m := range p.Spec.Members {
+		if m.Role != role {
+			continue
+		}
+		subject := rbacv1.Subject{Kind: m.Kind, Name: m.Name}
+		if m.Kind == rbacv1.ServiceAccountKind {
+			subject.Namespace = m.Namespace
+		} else {
+			subject.APIGroup = rbacv1.GroupName
+		}
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// deleteOwned deletes the object called name in namespace when p controls it.
+func (c *Client) deleteOwned(ctx context.Context, p *appv1alpha1.Project, obj client.Object, namespace, name string) error {
+	err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, p) {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Kubernetes.Delete(ctx, obj))
+}
+
+func merge(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,179 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestClient(t *testing.T, objs ...client.Object) *Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &Client{
+		Kubernetes: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Schema:     scheme,
+	}
+}
+
+func reconcile(t *testing.T, c *Client, name string) *appv1alpha1.Project {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := c.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: name}}); err != nil {
+		t.Fatal(err)
+	}
+	p := &appv1alpha1.Project{}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Name: name}, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReconcileBindsNamespaces(t *testing.T) {
+	p := &appv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", UID: "uid-a"},
+		Spec: appv1alpha1.ProjectSpec{
+			Namespaces: []string{"team-a"},
+			Members: []appv1alpha1.ProjectMember{
+				{Kind: "User", Name: "alice", Role: "admin"},
+				{Kind: "ServiceAccount", Name: "ci", Namespace: "ci", Role: "edit"},
+			},
+			Quota: &appv1alpha1.QuotaPolicy{
+				Hard:           corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+				DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			},
+		},
+	}
+	c := newTestClient(t, p)
+	ctx := context.Background()
+	p = reconcile(t, c, "team-a")
+
+	ns := &corev1.Namespace{}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Name: "team-a"}, ns); err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels[Label] != "team-a" {
+		t.Errorf("namespace label = %q, want team-a", ns.Labels[Label])
+	}
+	admin := &rbacv1.RoleBinding{}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: roleBindingPrefix + "admin"}, admin); err != nil {
+		t.Fatal(err)
+	}
+	if len(admin.Subjects) != 1 || admin.Subjects[0].Name != "alice" || admin.RoleRef.Name != "admin" {
+		t.Errorf("admin binding = %+v", admin)
+	}
+	quota := &corev1.ResourceQuota{}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: quotaName}, quota); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: quotaName}, &corev1.LimitRange{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Status.Namespaces) != 1 || p.Status.Namespaces[0] != "team-a" {
+		t.Errorf("bound namespaces = %v, want [team-a]", p.Status.Namespaces)
+	}
+
+	p.Spec.Namespaces = nil
+	if err := c.Kubernetes.Update(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	reconcile(t, c, "team-a")
+	_ = c.Kubernetes.Get(ctx, client.ObjectKey{Name: "team-a"}, ns)
+	if _, ok := ns.Labels[Label]; ok {
+		t.Errorf("namespace is still labeled after it was removed from the project")
+	}
+	err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: "team-a", Name: quotaName}, quota)
+	if !errors.IsNotFound(err) {
+		t.Errorf("quota still exists after the namespace was removed: %v", err)
+	}
+}
+
+func TestReconcileRefusesNamespaceOfOtherProject(t *testing.T) {
+	a := &appv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", UID: "uid-a"},
+		Spec:       appv1alpha1.ProjectSpec{Namespaces: []string{"shared"}},
+	}
+	b := &appv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-b", UID: "uid-b"},
+		Spec:       appv1alpha1.ProjectSpec{Namespaces: []string{"shared"}},
+	}
+	c := newTestClient(t, a, b)
+	reconcile(t, c, "team-a")
+	b = reconcile(t, c, "team-b")
+	if len(b.Status.Namespaces) != 0 {
+		t.Errorf("team-b bound %v, which belongs to team-a", b.Status.Namespaces)
+	}
+	if ready := b.Status.Conditions[0]; ready.Reason != "NamespaceConflict" {
+		t.Errorf("ready reason = %s, want NamespaceConflict", ready.Reason)
+	}
+}
+
+func TestReconcileOnlyBindsOptedInNamespaces(t *testing.T) {
+	p := &appv1alpha1.Project{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", UID: "uid-a"},
+		Spec: appv1alpha1.ProjectSpec{
+			Namespaces: []string{"kube-system", "other-team", "opted-in"},
+			Members:    []appv1alpha1.ProjectMember{{Kind: "User", Name: "alice", Role: "admin"}},
+		},
+	}
+	system := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system",
+		Labels: map[string]string{Label: "team-a"}, Annotations: map[string]string{AllowAnnotation: "team-a"}}}
+	other := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other-team"}}
+	opted := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-in", Annotations: map[string]string{AllowAnnotation: "team-a"}}}
+	c := newTestClient(t, p, system, other, opted)
+	ctx := context.Background()
+	p = reconcile(t, c, "team-a")
+
+	if len(p.Status.Namespaces) != 1 || p.Status.Namespaces[0] != "opted-in" {
+		t.Errorf("bound namespaces = %v, want [opted-in]", p.Status.Namespaces)
+	}
+	if ready := p.Status.Conditions[0]; ready.Reason != "NamespaceRefused" {
+		t.Errorf("ready reason = %s, want NamespaceRefused", ready.Reason)
+	}
+	for _, name := range []string{"kube-system", "other-team"} {
+		err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: name, Name: roleBindingPrefix + "admin"}, &rbacv1.RoleBinding{})
+		if !errors.IsNotFound(err) {
+			t.Errorf("members were granted admin in %s: %v", name, err)
+		}
+		ns := &corev1.Namespace{}
+		_ = c.Kubernetes.Get(ctx, client.ObjectKey{Name: name}, ns)
+		if _, ok := ns.Labels[Label]; ok {
+			t.Errorf("%s is labeled for the project", name)
+		}
+	}
+	if err := c.Kubernetes.Get(ctx, client.ObjectKey{Namespace: "opted-in", Name: roleBindingPrefix + "admin"}, &rbacv1.RoleBinding{}); err != nil {
+		t.Errorf("opted-in namespace was not bound: %v", err)
+	}
+}
+
+func TestAllowsHost(t *testing.T) {
+	p := &appv1alpha1.Project{Spec: appv1alpha1.ProjectSpec{AllowedDomains: []string{"*.team-a.example.com", "team-a.io"}}}
+	for host, want := range map[string]bool{
+		"web.team-a.example.com": true,
+		"team-a.io":              true,
+		"api.team-a.io.":         true,
+		"team-b.io":              false,
+		"evilteam-a.io":          false,
+		"":                       false,
+	} {
+		if got := AllowsHost(p, host); got != want {
+			t.Errorf("AllowsHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
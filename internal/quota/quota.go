@@ -0,0 +1,234 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appv1alpha1 "github.com/cloud-club/cloudclub-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Apply maintains the ResourceQuota and LimitRange called name in namespace
+// from policy, owned by owner. Either is deleted when policy no longer sets
+// it.
+func Apply(ctx context.Context, kube client.Client, scheme *runtime.Scheme, owner client.Object, namespace, name string, labels map[string]string, policy *appv1alpha1.QuotaPolicy) error {
+	if policy == nil {
+		policy = &appv1alpha1.QuotaPolicy{}
+	}
+	quota := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if len(policy.Hard) == 0 {
+		if err := deleteOwned(ctx, kube, owner, quota); err != nil {
+			return err
+		}
+	} else if _, err := controllerutil.CreateOrUpdate(ctx, kube, quota, func() error {
+		quota.Labels = merge(quota.Labels, labels)
+		quota.Spec.Hard = policy.Hard
+		return ctrl.SetControllerReference(owner, quota, scheme)
+	}); err != nil {
+		return err
+	}
+
+	limits := &corev1.LimitRange{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if len(policy.DefaultRequest) == 0 && len(policy.Default) == 0 && len(policy.Max) == 0 {
+		return deleteOwned(ctx, kube, owner, limits)
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, kube, limits, func() error {
+		limits.Labels = merge(limits.Labels, labels)
+		limits.Spec.Limits = []corev1.LimitRangeItem{{
+			Type:           corev1.LimitTypeContainer,
+			DefaultRequest: policy.DefaultRequest,
+			Default:        policy.Default,
+			Max:            policy.Max,
+		}}
+		return ctrl.SetControllerReference(owner, limits, scheme)
+	})
+	return err
+}
+
+// Delete removes the ResourceQuota and LimitRange called name in namespace
+// when owner controls them.
+func Delete(ctx context.Context, kube client.Client, owner client.Object, namespace, name string) error {
+	meta := metav1.ObjectMeta{Name: name, Namespace: namespace}
+	if err := deleteOwned(ctx, kube, owner, &corev1.ResourceQuota{ObjectMeta: meta}); err != nil {
+		return err
+	}
+	return deleteOwned(ctx, kube, owner, &corev1.LimitRange{ObjectMeta: meta})
+}
+
+func deleteOwned(ctx context.Context, kube client.Client, owner, obj client.Object) error {
+	if err := kube.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, owner) {
+		return nil
+	}
+	return client.IgnoreNotFound(kube.Delete(ctx, obj))
+}
+
+// Budget is what the ResourceQuotas and LimitRanges of a namespace allow.
+type Budget struct {
+	// Hard is the tightest limit on each resource across all ResourceQuotas.
+	Hard corev1.ResourceList
+	// DefaultRequest and Default are filled in by the LimitRanges for
+	// containers that do not set their own requests and limits.
+	DefaultRequest corev1.ResourceList
+	Default        corev1.ResourceList
+}
+
+// Load reads the budget of namespace. It returns nil when no ResourceQuota
+// limits the namespace.
+func Load(ctx context.Context, kube client.Reader, namespace string) (*Budget, error) {
+	quotas := &corev1.ResourceQuotaList{}
+	if err := kube.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(quotas.Items) == 0 {
+		return nil, nil
+	}
+	b := &Budget{Hard: corev1.ResourceList{}, DefaultRequest: corev1.ResourceList{}, Default: corev1.ResourceList{}}
+	for _, q := range quotas.Items {
+		for name, hard := range q.Spec.Hard {
+			if current, ok := b.Hard[name]; !ok || hard.Cmp(current) < 0 {
+				b.Hard[name] = hard
+			}
+		}
+	}
+	limitRanges := &corev1.LimitRangeList{}
+	if err := kube.List(ctx, limitRanges, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, lr := range limitRanges.Items {
+		for _, item := range lr.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for name, q := range item.DefaultRequest {
+				b.DefaultRequest[name] = q
+			}
+			for name, q := range item.Default {
+				b.Default[name] = q
+			}
+		}
+	}
+	return b, nil
+}
+
+// Requested returns the quota usage of replicas pods running spec, with the
+// defaults of b filled in for containers without requests or limits. Like
+// the API server, a pod is charged the larger of the sum of its containers
+// and its largest init container.
+func (b *Budget) Requested(replicas int32, spec corev1.PodSpec) corev1.ResourceList {
+	pod := corev1.ResourceList{}
+	for _, c := range spec.Containers {
+		for name, q := range b.container(c) {
+			add(pod, name, q, 1)
+		}
+	}
+	for _, c := range spec.InitContainers {
+		for name, q := range b.container(c) {
+			if current, ok := pod[name]; !ok || q.Cmp(current) > 0 {
+				pod[name] = q
+			}
+		}
+	}
+	used := corev1.ResourceList{corev1.ResourcePods: *resource.NewQuantity(int64(replicas), resource.DecimalSI)}
+	for name, q := range pod {
+		add(used, name, q, replicas)
+	}
+	return used
+}
+
+// container returns the quota usage of a single container.
+func (b *Budget) container(c corev1.Container) corev1.ResourceList {
+	used := corev1.ResourceList{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		limit, hasLimit := c.Resources.Limits[name]
+		if !hasLimit {
+			limit, hasLimit = b.Default[name]
+		}
+		request, hasRequest := c.Resources.Requests[name]
+		if !hasRequest {
+			request, hasRequest = b.DefaultRequest[name]
+		}
+		if !hasRequest && hasLimit {
+			request, hasRequest = limit, true
+		}
+		if hasRequest {
+			used[corev1.ResourceName("requests."+name)] = request
+			used[name] = request
+		}
+		if hasLimit {
+			used[corev1.ResourceName("limits."+name)] = limit
+		}
+	}
+	return used
+}
+
+func add(list corev1.ResourceList, name corev1.ResourceName, q resource.Quantity, times int32) {
+	total := list[name]
+	total.Add(*resource.NewMilliQuantity(q.MilliValue()*int64(times), q.Format))
+	list[name] = total
+}
+
+// Sum adds up lists.
+func Sum(lists ...corev1.ResourceList) corev1.ResourceList {
+	total := corev1.ResourceList{}
+	for _, list := range lists {
+		for name, q := range list {
+			sum := total[name]
+			sum.Add(q)
+			total[name] = sum
+		}
+	}
+	return total
+}
+
+// ExceededError reports the resources whose hard limit a set of
+// Applications would exceed.
+type ExceededError struct {
+	Hard      corev1.ResourceList
+	Requested corev1.ResourceList
+	Exceeded  []corev1.ResourceName
+}
+
+func (e *ExceededError) Error() string {
+	parts := make([]string, 0, len(e.Exceeded))
+	for _, name := range e.Exceeded {
+		requested, hard := e.Requested[name], e.Hard[name]
+		parts = append(parts, fmt.Sprintf("%s: requested %s, limited to %s", name, requested.String(), hard.String()))
+	}
+	return "exceeded quota: " + strings.Join(parts, ", ")
+}
+
+// Check returns an *ExceededError when requested exceeds the budget.
+func (b *Budget) Check(requested corev1.ResourceList) error {
+	var exceeded []corev1.ResourceName
+	for name, hard := range b.Hard {
+		if q, ok := requested[name]; ok && q.Cmp(hard) > 0 {
+			exceeded = append(exceeded, name)
+		}
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	sort.Slice(exceeded, func(i, j int) bool { return exceeded[i] < exceeded[j] })
+	return &ExceededError{Hard: b.Hard, Requested: requested, Exceeded: exceeded}
+}
+
+func merge(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
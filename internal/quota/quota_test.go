@@ -0,0 +1,75 @@
+package quota
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRequestedFillsLimitRangeDefaults(t *testing.T) {
+	b := &Budget{
+		DefaultRequest: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+		Default:        corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+	}
+	spec := corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app"},
+		{Name: "sidecar", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+		}},
+	}}
+	used := b.Requested(3, spec)
+	for name, want := range map[corev1.ResourceName]string{
+		corev1.ResourcePods:           "3",
+		corev1.ResourceRequestsCPU:    "450m",
+		corev1.ResourceRequestsMemory: "1536Mi",
+		corev1.ResourceLimitsMemory:   "1536Mi",
+	} {
+		got := used[name]
+		if got.Cmp(resource.MustParse(want)) != 0 {
+			t.Errorf("%s = %s, want %s", name, got.String(), want)
+		}
+	}
+}
+
+func TestRequestedChargesLargestInitContainer(t *testing.T) {
+	b := &Budget{}
+	cpu := func(q string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(q)}}
+	}
+	spec := corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "migrate", Resources: cpu("1")}, {Name: "wait", Resources: cpu("10m")}},
+		Containers:     []corev1.Container{{Name: "app", Resources: cpu("200m")}, {Name: "proxy", Resources: cpu("100m")}},
+	}
+	used := b.Requested(2, spec)
+	if got := used[corev1.ResourceRequestsCPU]; got.Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("requests.cpu = %s, want 2", got.String())
+	}
+
+	spec.InitContainers[0].Resources = cpu("100m")
+	used = b.Requested(2, spec)
+	if got := used[corev1.ResourceRequestsCPU]; got.Cmp(resource.MustParse("600m")) != 0 {
+		t.Errorf("requests.cpu = %s, want 600m", got.String())
+	}
+}
+
+func TestCheckReportsExceededResources(t *testing.T) {
+	b := &Budget{Hard: corev1.ResourceList{
+		corev1.ResourcePods:        resource.MustParse("10"),
+		corev1.ResourceRequestsCPU: resource.MustParse("1"),
+	}}
+	err := b.Check(corev1.ResourceList{
+		corev1.ResourcePods:        resource.MustParse("4"),
+		corev1.ResourceRequestsCPU: resource.MustParse("1500m"),
+	})
+	exceeded, ok := err.(*ExceededError)
+	if !ok {
+		t.Fatalf("err = %v, want *ExceededError", err)
+	}
+	if len(exceeded.Exceeded) != 1 || exceeded.Exceeded[0] != corev1.ResourceRequestsCPU {
+		t.Errorf("exceeded = %v, want [requests.cpu]", exceeded.Exceeded)
+	}
+	if err := b.Check(corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")}); err != nil {
+		t.Errorf("usage at the limit was rejected: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// dockerHubAliases are the names Docker Hub credentials are stored under.
+var dockerHubAliases = []string{DockerHub, "index.docker.io", "registry-1.docker.io"}
+
+type dockerAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// FromSecret returns the credentials an image pull secret holds for
+// registry, and whether it holds any.
+func FromSecret(secret *corev1.Secret, registry string) (Credentials, bool, error) {
+	var auths map[string]dockerAuth
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		var config struct {
+			Auths map[string]dockerAuth `json:"auths"`
+		}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return Credentials{}, false, fmt.Errorf("secret %s: %w", secret.Name, err)
+		}
+		auths = config.Auths
+	case corev1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &auths); err != nil {
+			return Credentials{}, false, fmt.Errorf("secret %s: %w", secret.Name, err)
+		}
+	default:
+		return Credentials{}, false, fmt.Errorf("secret %s is not an image pull secret", secret.Name)
+	}
+
+	names := []string{registry}
+	if registry == DockerHub {
+		names = dockerHubAliases
+	}
+	for key, auth := range auths {
+		host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		host, _, _ = strings.Cut(host, "/")
+		if !contains(names, host) {
+			continue
+		}
+		if auth.Username == "" && auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return Credentials{}, false, fmt.Errorf("secret %s: auth of %s: %w", secret.Name, key, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return Credentials{Username: auth.Username, Password: auth.Password}, true, nil
+	}
+	return Credentials{}, false, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
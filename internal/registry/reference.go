@@ -0,0 +1,53 @@
+package registry
+
+import "strings"
+
+// DockerHub is the registry of short image names like nginx.
+const DockerHub = "docker.io"
+
+// Reference is a parsed image reference such as
+// ghcr.io/org/app:1.2@sha256:abc.
+type Reference struct {
+	// Registry is docker.io for Docker Hub short names like nginx.
+	Registry   string
+	Repository string
+	// Tag is empty when the image has none, which means latest.
+	Tag    string
+	Digest string
+}
+
+// ParseReference splits image into its parts. It does not validate them.
+func ParseReference(image string) Reference {
+	var ref Reference
+	if i := strings.IndexRune(image, '@'); i >= 0 {
+		image, ref.Digest = image[:i], image[i+1:]
+	}
+	if i := strings.LastIndexByte(image, ':'); i > strings.LastIndexByte(image, '/') {
+		image, ref.Tag = image[:i], image[i+1:]
+	}
+	ref.Registry, ref.Repository = DockerHub, image
+	if i := strings.IndexRune(image, '/'); i >= 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref.Registry, ref.Repository = host, image[i+1:]
+		}
+	}
+	return ref
+}
+
+// Name returns the image without its tag and digest, as written.
+func (r Reference) Name() string {
+	if r.Registry == DockerHub {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// Pinned returns the image with its tag, for readability, and digest.
+func (r Reference) Pinned(digest string) string {
+	name := r.Name()
+	if r.Tag != "" {
+		name += ":" + r.Tag
+	}
+	return name + "@" + digest
+}
@@ -0,0 +1,370 @@
+// Package registry resolves image tags to digests and lists tags through
+// the OCI distribution API.
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// manifestTypes are the manifests a tag may point at. Indexes come first so
+// that a multi-platform image resolves to the digest of its index rather
+// than of one platform.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Credentials authenticate to a registry. The zero value is anonymous.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Client reads image repositories from their registry.
+type Client interface {
+	// Resolve returns the digest of the manifest image refers to. An image
+	// that already carries a digest resolves to it without asking the
+	// registry.
+	Resolve(ctx context.Context, image string, creds Credentials) (string, error)
+	// Tags lists the tags of the repository of image.
+	Tags(ctx context.Context, image string, creds Credentials) ([]string, error)
+	// Manifest returns the manifest image refers to by tag or digest.
+	Manifest(ctx context.Context, image string, creds Credentials) ([]byte, error)
+	// Blob returns the blob with digest in the repository of image.
+	Blob(ctx context.Context, image, digest string, creds Credentials) ([]byte, error)
+}
+
+// DefaultTimeout bounds each request to a registry, so that one that stalls
+// does not hold back the reconcile of Applications.
+const DefaultTimeout = 30 * time.Second
+
+// HTTPClient is a Client speaking the OCI distribution API.
+type HTTPClient struct {
+	// HTTP sends the requests; a client bounding each of them by Timeout
+	// when nil.
+	HTTP *http.Client
+	// Timeout bounds the requests sent without HTTP; DefaultTimeout when
+	// zero.
+	Timeout time.Duration
+	// Insecure lists the registries spoken to over plain HTTP.
+	Insecure []string
+}
+
+var _ Client = &HTTPClient{}
+
+// Resolve asks the registry for the manifest of the tag of image.
+func (c *HTTPClient) Resolve(ctx context.Context, image string, creds Credentials) (string, error) {
+	ref := ParseReference(image)
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	tag := ref.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	s := c.session(ref, creds)
+	manifest := s.base + "/manifests/" + tag
+	accept := strings.Join(manifestTypes, ", ")
+	resp, err := s.do(ctx, http.MethodHead, manifest, accept)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") == "" {
+		// Registries need not send the digest on HEAD; hash the manifest
+		// instead.
+		if resp, err = s.do(ctx, http.MethodGet, manifest, accept); err != nil {
+			return "", err
+		}
+	}
+	return digestOf(resp, image)
+}
+
+// Manifest reads the manifest of image.
+func (c *HTTPClient) Manifest(ctx context.Context, image string, creds Credentials) ([]byte, error) {
+	ref := ParseReference(image)
+	reference := ref.Digest
+	if reference == "" {
+		reference = ref.Tag
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+	s := c.session(ref, creds)
+	resp, err := s.do(ctx, http.MethodGet, s.base+"/manifests/"+reference, strings.Join(manifestTypes, ", "))
+	if err != nil {
+		return nil, err
+	}
+	return read(resp, "manifest "+image)
+}
+
+// Blob reads a blob of the repository of image and checks it against its
+// digest.
+func (c *HTTPClient) Blob(ctx context.Context, image, digest string, creds Credentials) ([]byte, error) {
+	s := c.session(ParseReference(image), creds)
+	resp, err := s.do(ctx, http.MethodGet, s.base+"/blobs/"+digest, "*/*")
+	if err != nil {
+		return nil, err
+	}
+	data, err := read(resp, "blob "+digest)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(digest, "sha256:") {
+		if sum := sha256.Sum256(data); digest != "sha256:"+hex.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("blob %s does not match its digest", digest)
+		}
+	}
+	return data, nil
+}
+
+// read returns the body of a successful response.
+func read(resp *http.Response, what string) ([]byte, error) {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%s not found", what)
+	default:
+		return nil, fmt.Errorf("reading %s: registry responded %s", what, resp.Status)
+	}
+}
+
+// Tags pages through the tag list of the repository of image.
+func (c *HTTPClient) Tags(ctx context.Context, image string, creds Credentials) ([]string, error) {
+	s := c.session(ParseReference(image), creds)
+	var tags []string
+	for next := s.base + "/tags/list"; next != ""; {
+		resp, err := s.do(ctx, http.MethodGet, next, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("listing tags of %s: registry responded %s", image, resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s: %w", image, err)
+		}
+		tags = append(tags, page.Tags...)
+		if next, err = nextPage(resp); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage returns the URL of the next page of a paginated list response,
+// or "" on the last page.
+func nextPage(resp *http.Response) (string, error) {
+	for _, link := range resp.Header.Values("Link") {
+		target, params, _ := strings.Cut(link, ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		next, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return "", fmt.Errorf("invalid Link header %q: %w", link, err)
+		}
+		return next.String(), nil
+	}
+	return "", nil
+}
+
+// session talks to the repository of one image, reusing the authorization
+// it answered the challenge of the registry with.
+type session struct {
+	client        *HTTPClient
+	registry      string
+	repository    string
+	base          string
+	creds         Credentials
+	authorization string
+}
+
+func (c *HTTPClient) session(ref Reference, creds Credentials) *session {
+	host, repository := ref.Registry, ref.Repository
+	if host == DockerHub {
+		host = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	scheme := "https"
+	for _, insecure := range c.Insecure {
+		if insecure == ref.Registry {
+			scheme = "http"
+		}
+	}
+	return &session{
+		client:     c,
+		registry:   ref.Registry,
+		repository: repository,
+		base:       fmt.Sprintf("%s://%s/v2/%s", scheme, host, repository),
+		creds:      creds,
+	}
+}
+
+// do sends a request, logging in with the credentials of the session and
+// retrying once when the registry challenges it. The body of HEAD
+// responses is closed.
+func (s *session) do(ctx context.Context, method, url, accept string) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		if s.authorization != "" {
+			req.Header.Set("Authorization", s.authorization)
+		}
+		resp, err := s.client.http().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || retried {
+			if method == http.MethodHead {
+				resp.Body.Close()
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+		if s.authorization, err = s.client.authorize(ctx, resp.Header.Get("WWW-Authenticate"), s.repository, s.creds); err != nil {
+			return nil, fmt.Errorf("logging in to %s: %w", s.registry, err)
+		}
+	}
+}
+
+// digestOf reads the digest from a manifest response, hashing the body of a
+// GET when the registry sent no Docker-Content-Digest header.
+func digestOf(resp *http.Response, image string) (string, error) {
+	if resp.Request.Method == http.MethodGet {
+		defer resp.Body.Close()
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("image %s not found", image)
+	default:
+		return "", fmt.Errorf("resolving %s: registry responded %s", image, resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	if resp.Request.Method != http.MethodGet {
+		return "", fmt.Errorf("resolving %s: registry sent no digest", image)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// authorize answers the WWW-Authenticate challenge of a registry with the
+// value of the Authorization header to retry with.
+func (c *HTTPClient) authorize(ctx context.Context, challenge, repository string, creds Credentials) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds.Username == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported challenge %q", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := c.http().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service responded %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token service returned no token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+func (c *HTTPClient) http() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry" into its
+// scheme and parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseReference(t *testing.T) {
+	for image, want := range map[string]Reference{
+		"nginx":                               {Registry: "docker.io", Repository: "nginx"},
+		"nginx:1.25":                          {Registry: "docker.io", Repository: "nginx", Tag: "1.25"},
+		"bitnami/redis:7":                     {Registry: "docker.io", Repository: "bitnami/redis", Tag: "7"},
+		"localhost:5000/app":                  {Registry: "localhost:5000", Repository: "app"},
+		"ghcr.io/org/app:v1@sha256:0123abcd":  {Registry: "ghcr.io", Repository: "org/app", Tag: "v1", Digest: "sha256:0123abcd"},
+		"registry.example.com/app@sha256:abc": {Registry: "registry.example.com", Repository: "app", Digest: "sha256:abc"},
+	} {
+		if got := ParseReference(image); got != want {
+			t.Errorf("ParseReference(%s) = %+v, want %+v", image, got, want)
+		}
+	}
+	if got := ParseReference("nginx:1.25").Pinned("sha256:abc"); got != "nginx:1.25@sha256:abc" {
+		t.Errorf("Pinned = %s", got)
+	}
+}
+
+// testRegistry serves the manifests of tags of one repository behind token
+// authentication, like most hosted registries.
+type testRegistry struct {
+	*httptest.Server
+	manifests map[string]string
+	// noDigestOnHead leaves Docker-Content-Digest out of HEAD responses.
+	noDigestOnHead bool
+}
+
+func newTestRegistry(t *testing.T, repository string, manifests map[string]string) *testRegistry {
+	r := &testRegistry{manifests: manifests}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != "robot" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if scope := req.URL.Query().Get("scope"); scope != "repository:"+repository+":pull" {
+			t.Errorf("token requested for scope %q", scope)
+		}
+		fmt.Fprint(w, `{"token": "t0ken"}`)
+	})
+	mux.HandleFunc("/v2/"+repository+"/tags/list", func(w http.ResponseWriter, req *http.Request) {
+		if !r.authorized(w, req) {
+			return
+		}
+		// Two tags per page, in order, continuing after the last one.
+		var names []string
+		for tag := range r.manifests {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+		last := req.URL.Query().Get("last")
+		page := []string{}
+		for _, tag := range names {
+			if tag > last && len(page) < 2 {
+				page = append(page, tag)
+			}
+		}
+		if len(page) == 2 && page[1] != names[len(names)-1] {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=2&last=%s>; rel="next"`, repository, page[1]))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": page})
+	})
+	mux.HandleFunc("/v2/"+repository+"/manifests/", func(w http.ResponseWriter, req *http.Request) {
+		if !r.authorized(w, req) {
+			return
+		}
+		manifest, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/"+repository+"/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sum := sha256.Sum256([]byte(manifest))
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		if req.Method == http.MethodGet || !r.noDigestOnHead {
+			w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+		}
+		if req.Method == http.MethodGet {
+			fmt.Fprint(w, manifest)
+		}
+	})
+	r.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// authorized challenges requests without the token.
+func (r *testRegistry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if req.Header.Get("Authorization") == "Bearer t0ken" {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.URL))
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+func digest(manifest string) string {
+	sum := sha256.Sum256([]byte(manifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestResolve(t *testing.T) {
+	reg := newTestRegistry(t, "team/app", map[string]string{"v1": `{"v": 1}`, "latest": `{"v": 2}`})
+	client := &HTTPClient{HTTP: reg.Client()}
+	creds := Credentials{Username: "robot", Password: "s3cret"}
+	ctx := context.Background()
+
+	got, err := client.Resolve(ctx, reg.host()+"/team/app:v1", creds)
+	if err != nil || got != digest(`{"v": 1}`) {
+		t.Errorf("Resolve(v1) = %s, %v", got, err)
+	}
+	if got, err = client.Resolve(ctx, reg.host()+"/team/app", creds); err != nil || got != digest(`{"v": 2}`) {
+		t.Errorf("Resolve without tag = %s, %v, want latest", got, err)
+	}
+	if got, err = client.Resolve(ctx, reg.host()+"/team/app:v1@sha256:abc", Credentials{}); err != nil || got != "sha256:abc" {
+		t.Errorf("Resolve of a pinned image = %s, %v", got, err)
+	}
+	if _, err = client.Resolve(ctx, reg.host()+"/team/app:v9", creds); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Resolve of a missing tag = %v", err)
+	}
+	if _, err = client.Resolve(ctx, reg.host()+"/team/app:v1", Credentials{}); err == nil {
+		t.Error("Resolve without credentials succeeded")
+	}
+
+	reg.noDigestOnHead = true
+	if got, err = client.Resolve(ctx, reg.host()+"/team/app:v1", creds); err != nil || got != digest(`{"v": 1}`) {
+		t.Errorf("Resolve without digest on HEAD = %s, %v", got, err)
+	}
+}
+
+func TestResolveTimesOutOnStalledRegistry(t *testing.T) {
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-stall
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(stall) })
+	host := strings.TrimPrefix(server.URL, "http://")
+	client := &HTTPClient{Insecure: []string{host}, Timeout: 50 * time.Millisecond}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Resolve(context.Background(), host+"/team/app:v1", Credentials{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "Client.Timeout") {
+			t.Errorf("Resolve against a stalled registry = %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resolve did not time out")
+	}
+}
+
+func TestTags(t *testing.T) {
+	manifests := map[string]string{}
+	for _, tag := range []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "latest"} {
+		manifests[tag] = tag
+	}
+	reg := newTestRegistry(t, "team/app", manifests)
+	client := &HTTPClient{HTTP: reg.Client()}
+
+	got, err := client.Tags(context.Background(), reg.host()+"/team/app:1.0.0", Credentials{Username: "robot", Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "latest"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags = %v, want %v across pages", got, want)
+	}
+}
+
+func TestFromSecret(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:s3cret"))
+	secret := &corev1.Secret{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+			"ghcr.io": {"username": "bot", "password": "pat"}
+		}}`)},
+	}
+	for registry, want := range map[string]Credentials{
+		"docker.io": {Username: "robot", Password: "s3cret"},
+		"ghcr.io":   {Username: "bot", Password: "pat"},
+		"quay.io":   {},
+	} {
+		got, ok, err := FromSecret(secret, registry)
+		if err != nil || got != want || ok != (want != Credentials{}) {
+			t.Errorf("FromSecret(%s) = %+v, %v, %v, want %+v", registry, got, ok, err, want)
+		}
+	}
+	if _, _, err := FromSecret(&corev1.Secret{Type: corev1.SecretTypeOpaque}, "ghcr.io"); err == nil {
+		t.Error("FromSecret accepted an opaque secret")
+	}
+}
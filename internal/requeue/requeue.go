@@ -0,0 +1,55 @@
+package requeue
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// NewRateLimiter builds the workqueue rate limiter of the Application
+// controller from the operator config.
+func NewRateLimiter(cfg config.RateLimiterConfig) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(cfg.BaseDelay.Duration, cfg.MaxDelay.Duration),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(cfg.QPS), cfg.Burst)},
+	)
+}
+
+// Apply maps the outcome of a reconcile to the result handed back to
+// controller-runtime according to the requeue policy:
+//   - success is requeued after the resync period for drift detection,
+//   - update conflicts are retried after a short fixed delay, since the
+//     next attempt reads the fresh object and will most likely succeed,
+//   - writes rejected by a ResourceQuota are retried after a long delay
+//     instead of hammering the API server with exponential backoff,
+//   - any other error is returned and backs off through the rate limiter.
+func Apply(ctx context.Context, cfg config.ReconcileConfig, result ctrl.Result, err error) (ctrl.Result, error) {
+	switch {
+	case err == nil:
+		if !result.Requeue && result.RequeueAfter == 0 && cfg.ResyncPeriod.Duration > 0 {
+			result.RequeueAfter = cfg.ResyncPeriod.Duration
+		}
+		return result, nil
+	case errors.IsConflict(err):
+		log.Debug(ctx, "retrying after conflict", zap.Error(err))
+		return ctrl.Result{Requeue: true, RequeueAfter: cfg.ConflictDelay.Duration}, nil
+	case IsQuotaExceeded(err):
+		log.Warn(ctx, "quota exceeded, backing off", zap.Error(err), zap.Duration("after", cfg.QuotaExceededDelay.Duration))
+		return ctrl.Result{Requeue: true, RequeueAfter: cfg.QuotaExceededDelay.Duration}, nil
+	default:
+		return result, err
+	}
+}
+
+// IsQuotaExceeded reports whether err is the API server rejecting a write
+// because it would exceed a ResourceQuota.
+func IsQuotaExceeded(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota")
+}
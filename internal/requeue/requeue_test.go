@@ -0,0 +1,81 @@
+package requeue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestApply(t *testing.T) {
+	cfg := config.Default().Reconcile
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	quota := apierrors.NewForbidden(deployments, "web", errors.New("exceeded quota: compute, requested: cpu=2"))
+	other := errors.New("connection refused")
+
+	for name, tc := range map[string]struct {
+		result  ctrl.Result
+		err     error
+		want    ctrl.Result
+		wantErr error
+	}{
+		"success is resynced": {
+			want: ctrl.Result{RequeueAfter: cfg.ResyncPeriod.Duration},
+		},
+		"an earlier requeue is kept": {
+			result: ctrl.Result{RequeueAfter: time.Minute},
+			want:   ctrl.Result{RequeueAfter: time.Minute},
+		},
+		"a conflict is retried shortly": {
+			err:  apierrors.NewConflict(deployments, "web", errors.New("object was modified")),
+			want: ctrl.Result{Requeue: true, RequeueAfter: cfg.ConflictDelay.Duration},
+		},
+		"an exceeded quota is retried late": {
+			err:  quota,
+			want: ctrl.Result{Requeue: true, RequeueAfter: cfg.QuotaExceededDelay.Duration},
+		},
+		"other errors back off": {
+			err:     other,
+			wantErr: other,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := Apply(context.Background(), cfg, tc.result, tc.err)
+			if got != tc.want || err != tc.wantErr {
+				t.Errorf("Apply = %+v, %v, want %+v, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+
+	cfg.ResyncPeriod.Duration = 0
+	if got, _ := Apply(context.Background(), cfg, ctrl.Result{}, nil); got != (ctrl.Result{}) {
+		t.Errorf("Apply with resyncs disabled = %+v", got)
+	}
+}
+
+func TestIsQuotaExceeded(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	if IsQuotaExceeded(apierrors.NewForbidden(pods, "web", errors.New("not allowed by policy"))) {
+		t.Error("a forbidden write counts as an exceeded quota")
+	}
+	if !IsQuotaExceeded(apierrors.NewForbidden(pods, "web", errors.New("exceeded quota: compute"))) {
+		t.Error("an exceeded quota is not detected")
+	}
+}
+
+func TestNewRateLimiterBacksOffPerItem(t *testing.T) {
+	limiter := NewRateLimiter(config.Default().Reconcile.RateLimiter)
+	first, second := limiter.When("web"), limiter.When("web")
+	if first != 5*time.Millisecond || second != 10*time.Millisecond {
+		t.Errorf("backoff = %v, %v, want 5ms, 10ms", first, second)
+	}
+	limiter.Forget("web")
+	if got := limiter.When("web"); got != 5*time.Millisecond {
+		t.Errorf("backoff after Forget = %v, want 5ms", got)
+	}
+}
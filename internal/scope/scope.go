@@ -0,0 +1,171 @@
+package scope
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Scope restricts the operator to a set of namespaces, given either by name
+// or by a label selector on Namespace objects. The zero value watches the
+// whole cluster.
+type Scope struct {
+	Namespaces []string
+	Selector   string
+}
+
+// ClusterWide reports whether the scope covers every namespace.
+func (s Scope) ClusterWide() bool {
+	return len(s.Namespaces) == 0 && s.Selector == ""
+}
+
+// Resolve returns the sorted namespaces covered by the scope, listing the
+// Namespaces matching the selector when one is set.
+func (s Scope) Resolve(ctx context.Context, kube client.Reader) ([]string, error) {
+	namespaces := append([]string(nil), s.Namespaces...)
+	if s.Selector != "" {
+		selector, err := labels.Parse(s.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %w", s.Selector, err)
+		}
+		list := &corev1.NamespaceList{}
+		if err := kube.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		for _, ns := range list.Items {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	sort.Strings(namespaces)
+	return dedup(namespaces), nil
+}
+
+// ApplyTo configures the manager cache to only watch namespaces, and derives
+// the leader election ID from them so that operators with disjoint scopes in
+// one cluster do not compete for the same lease.
+func ApplyTo(opts *manager.Options, namespaces []string) {
+	switch len(namespaces) {
+	case 0:
+		return
+	case 1:
+		opts.Namespace = namespaces[0]
+	default:
+		opts.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(namespaces, ",")))
+	id := opts.LeaderElectionID
+	if i := strings.IndexRune(id, '.'); i > 0 {
+		opts.LeaderElectionID = fmt.Sprintf("%s-%08x%s", id[:i], h.Sum32(), id[i:])
+	} else {
+		opts.LeaderElectionID = fmt.Sprintf("%s-%08x", id, h.Sum32())
+	}
+}
+
+// NewReader returns an uncached client for resolving the scope before the
+// manager is started.
+func NewReader(cfg *rest.Config) (client.Reader, error) {
+	return client.New(cfg, client.Options{})
+}
+
+// SelectorWatcher stops the manager when the set of namespaces matching the
+// selector changes, so that the cache is rebuilt on restart. A change must
+// persist for Debounce, so that relabeling several namespaces one after the
+// other, or a label flapping, restarts the manager at most once.
+type SelectorWatcher struct {
+	Scope    Scope
+	Reader   client.Reader
+	Current  []string
+	Interval time.Duration
+	// Debounce defaults to Interval.
+	Debounce time.Duration
+}
+
+func (w *SelectorWatcher) Start(ctx context.Context) error {
+	debounce := w.Debounce
+	if debounce == 0 {
+		debounce = w.Interval
+	}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	var pending []string
+	var since time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			namespaces, err := w.Scope.Resolve(ctx, w.Reader)
+			if err != nil {
+				log.Errorf(ctx, err)
+				continue
+			}
+			if reflect.DeepEqual(namespaces, w.Current) {
+				if pending != nil {
+					log.Info(ctx, "watched namespaces changed back, not restarting")
+				}
+				pending = nil
+				continue
+			}
+			if pending == nil || !reflect.DeepEqual(namespaces, pending) {
+				added, removed := diff(w.Current, namespaces)
+				log.Info(ctx, "watched namespaces changed, restarting unless it is undone",
+					zap.Strings("added", added), zap.Strings("removed", removed), zap.Duration("after", debounce))
+				pending, since = namespaces, time.Now()
+				continue
+			}
+			if time.Since(since) >= debounce {
+				added, removed := diff(w.Current, namespaces)
+				return fmt.Errorf("namespaces matching %q changed: added %v, removed %v", w.Scope.Selector, added, removed)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica watch for scope changes.
+func (w *SelectorWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// diff returns the namespaces of new missing from old, and those of old
+// missing from new.
+func diff(old, new []string) (added, removed []string) {
+	in := func(list []string, s string) bool {
+		i := sort.SearchStrings(list, s)
+		return i < len(list) && list[i] == s
+	}
+	for _, s := range new {
+		if !in(old, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if !in(new, s) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+func dedup(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
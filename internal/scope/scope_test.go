@@ -0,0 +1,127 @@
+package scope
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestResolveMergesNamesAndSelector(t *testing.T) {
+	kube := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		namespace("team-a", map[string]string{"tenant": "a"}),
+		namespace("team-a-staging", map[string]string{"tenant": "a"}),
+		namespace("team-b", map[string]string{"tenant": "b"}),
+	).Build()
+	s := Scope{Namespaces: []string{"shared", "team-a"}, Selector: "tenant=a"}
+	if s.ClusterWide() {
+		t.Error("a restricted scope is cluster-wide")
+	}
+	namespaces, err := s.Resolve(context.Background(), kube)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"shared", "team-a", "team-a-staging"}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("Resolve = %v, want %v", namespaces, want)
+	}
+
+	if _, err := (Scope{Selector: "tenant in"}).Resolve(context.Background(), kube); err == nil {
+		t.Error("an invalid selector was accepted")
+	}
+	if !(Scope{}).ClusterWide() {
+		t.Error("the zero scope is not cluster-wide")
+	}
+}
+
+func TestApplyToDerivesLeaderElectionID(t *testing.T) {
+	opts := manager.Options{LeaderElectionID: "04e7cab7.cloudclub.com"}
+	ApplyTo(&opts, nil)
+	if opts.LeaderElectionID != "04e7cab7.cloudclub.com" || opts.Namespace != "" || opts.NewCache != nil {
+		t.Errorf("cluster-wide options changed: %+v", opts)
+	}
+
+	single := manager.Options{LeaderElectionID: "04e7cab7.cloudclub.com"}
+	ApplyTo(&single, []string{"team-a"})
+	if single.Namespace != "team-a" {
+		t.Errorf("namespace = %q, want team-a", single.Namespace)
+	}
+	multi := manager.Options{LeaderElectionID: "04e7cab7.cloudclub.com"}
+	ApplyTo(&multi, []string{"team-a", "team-b"})
+	if multi.NewCache == nil {
+		t.Error("several namespaces do not use a multi-namespace cache")
+	}
+	for _, id := range []string{single.LeaderElectionID, multi.LeaderElectionID} {
+		if !strings.HasPrefix(id, "04e7cab7-") || !strings.HasSuffix(id, ".cloudclub.com") {
+			t.Errorf("leader election ID = %q", id)
+		}
+	}
+	if single.LeaderElectionID == multi.LeaderElectionID {
+		t.Error("disjoint scopes share a leader election ID")
+	}
+}
+
+func TestSelectorWatcherDebouncesChanges(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+		namespace("team-a", map[string]string{"tenant": "a"}),
+		namespace("team-b", nil),
+	).Build()
+	relabel := func(labels map[string]string) {
+		ns := &corev1.Namespace{}
+		if err := kube.Get(ctx, client.ObjectKey{Name: "team-b"}, ns); err != nil {
+			t.Fatal(err)
+		}
+		ns.Labels = labels
+		if err := kube.Update(ctx, ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := func(debounce time.Duration) <-chan error {
+		w := &SelectorWatcher{
+			Scope:    Scope{Selector: "tenant=a"},
+			Reader:   kube,
+			Current:  []string{"team-a"},
+			Interval: 10 * time.Millisecond,
+			Debounce: debounce,
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+		done := make(chan error, 1)
+		go func() { done <- w.Start(ctx) }()
+		return done
+	}
+
+	// A label that is undone within the debounce does not restart.
+	done := start(time.Hour)
+	relabel(map[string]string{"tenant": "a"})
+	time.Sleep(50 * time.Millisecond)
+	relabel(nil)
+	select {
+	case err := <-done:
+		t.Fatalf("watcher stopped on a flapping label: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A lasting change restarts once the debounce has passed.
+	relabel(map[string]string{"tenant": "a"})
+	select {
+	case err := <-start(50 * time.Millisecond):
+		if err == nil || !strings.Contains(err.Error(), "added [team-b]") {
+			t.Errorf("Start = %v, want the added namespace", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not stop on a lasting change")
+	}
+}
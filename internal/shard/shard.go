@@ -0,0 +1,228 @@
+package shard
+
+import (
+	"context"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const groupLabel = "app.cloudclub.com/shard-group"
+
+// Member takes part in a shard group by renewing a Lease named after its
+// identity. Every live member of the group owns an equal hash range of
+// Application keys; the ranges are recomputed whenever a member joins or its
+// lease expires. It implements manager.Runnable.
+type Member struct {
+	Client    client.Client
+	Reader    client.Reader
+	Namespace string
+	Group     string
+	Identity  string
+	// LeaseDuration is how long a member is considered alive after its last
+	// renewal. Leases are renewed every third of it.
+	LeaseDuration time.Duration
+
+	mu      sync.RWMutex
+	members []string
+	// renewed is when the lease was last renewed. The others take the
+	// range over once it is LeaseDuration old.
+	renewed  time.Time
+	onChange []func(context.Context)
+}
+
+// OnChange registers fn to be called when the set of live members changes.
+// It must be called before the Member is started.
+func (m *Member) OnChange(fn func(context.Context)) {
+	m.onChange = append(m.onChange, fn)
+}
+
+// Owns reports whether key falls into this member's hash range. Nothing is
+// owned until the first membership round has completed, nor once the lease
+// could not be renewed for LeaseDuration, when the other members consider
+// this one gone and split its range among themselves.
+func (m *Member) Owns(key types.NamespacedName) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if time.Since(m.renewed) >= m.LeaseDuration {
+		return false
+	}
+	return owner(m.members, key) == m.Identity
+}
+
+// owner returns the member whose range of the 32-bit hash space contains key.
+func owner(members []string, key types.NamespacedName) string {
+	if len(members) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key.String()))
+	width := (uint64(1) << 32) / uint64(len(members))
+	i := uint64(h.Sum32()) / width
+	if i >= uint64(len(members)) {
+		i = uint64(len(members)) - 1
+	}
+	return members[i]
+}
+
+func (m *Member) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.LeaseDuration / 3)
+	defer ticker.Stop()
+	defer m.release()
+	for {
+		m.round(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// round renews the lease of the member and recomputes the ranges.
+func (m *Member) round(ctx context.Context) {
+	if err := m.renew(ctx); err != nil {
+		log.Errorf(ctx, err)
+		m.drop(ctx)
+	} else if err := m.refresh(ctx); err != nil {
+		log.Errorf(ctx, err)
+	}
+}
+
+// NeedLeaderElection lets every replica run its member.
+func (m *Member) NeedLeaderElection() bool {
+	return false
+}
+
+// EveryReplica returns mgr adding its runnables, typically the controller
+// of the sharded kind, to every replica instead of the leader only. Leader
+// election keeps guarding the runnables added to mgr itself.
+func EveryReplica(mgr manager.Manager) manager.Manager {
+	return everyReplica{mgr}
+}
+
+type everyReplica struct {
+	manager.Manager
+}
+
+func (m everyReplica) Add(r manager.Runnable) error {
+	return m.Manager.Add(leaderless{r})
+}
+
+type leaderless struct {
+	manager.Runnable
+}
+
+func (leaderless) NeedLeaderElection() bool {
+	return false
+}
+
+func (m *Member) leaseName() string {
+	return "cloudclub-shard-" + m.Identity
+}
+
+// renew creates or renews the lease of the member.
+func (m *Member) renew(ctx context.Context) error {
+	renewed := time.Now()
+	now := metav1.NewMicroTime(renewed)
+	seconds := int32(m.LeaseDuration.Seconds())
+	lease := &coordinationv1.Lease{}
+	err := m.Reader.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.leaseName()}, lease)
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.Namespace,
+				Labels:    map[string]string{groupLabel: m.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		err = m.Client.Create(ctx, lease)
+	} else if err == nil {
+		lease.Spec.HolderIdentity = &m.Identity
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.RenewTime = &now
+		err = m.Client.Update(ctx, lease)
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.renewed = renewed
+	m.mu.Unlock()
+	return nil
+}
+
+// drop gives up the ranges of the member after a failed renewal: the others
+// may already have taken them over. They are recomputed, and the
+// Applications in them requeued, by the next successful round.
+func (m *Member) drop(ctx context.Context) {
+	m.mu.Lock()
+	dropped := m.members != nil
+	m.members = nil
+	m.mu.Unlock()
+	if dropped {
+		log.Info(ctx, "dropped shard after failed lease renewal", zap.String("identity", m.Identity))
+	}
+}
+
+// refresh lists the live leases of the group and recomputes the ranges.
+func (m *Member) refresh(ctx context.Context) error {
+	leases := &coordinationv1.LeaseList{}
+	if err := m.Reader.List(ctx, leases, client.InNamespace(m.Namespace), client.MatchingLabels{groupLabel: m.Group}); err != nil {
+		return err
+	}
+	var members []string
+	for _, lease := range leases.Items {
+		if alive(lease) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+
+	m.mu.Lock()
+	changed := !reflect.DeepEqual(members, m.members)
+	m.members = members
+	m.mu.Unlock()
+
+	if changed {
+		log.Info(ctx, "shard membership changed", zap.Strings("members", members), zap.String("identity", m.Identity))
+		for _, fn := range m.onChange {
+			fn(ctx)
+		}
+	}
+	return nil
+}
+
+// release deletes the member's lease so the others rebalance immediately
+// instead of waiting for it to expire.
+func (m *Member) release() {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: m.Namespace, Name: m.leaseName()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = client.IgnoreNotFound(m.Client.Delete(ctx, lease))
+}
+
+func alive(lease coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().Before(expiry)
+}
@@ -0,0 +1,114 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOwnerSplitsKeysAcrossMembers(t *testing.T) {
+	members := []string{"a", "b", "c"}
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := types.NamespacedName{Namespace: "team", Name: fmt.Sprintf("app-%d", i)}
+		counts[owner(members, key)]++
+	}
+	for _, m := range members {
+		if counts[m] < 800 || counts[m] > 1200 {
+			t.Errorf("member %s owns %d of 3000 keys, want about 1000", m, counts[m])
+		}
+	}
+}
+
+func TestOwnerIsStableForSameMembers(t *testing.T) {
+	key := types.NamespacedName{Namespace: "team", Name: "web"}
+	if owner([]string{"a", "b"}, key) != owner([]string{"a", "b"}, key) {
+		t.Errorf("owner is not deterministic")
+	}
+	if owner(nil, key) != "" {
+		t.Errorf("no member should own keys before membership is known")
+	}
+}
+
+// failingClient fails every write while fail is set, like an API server the
+// member lost contact with.
+type failingClient struct {
+	client.Client
+	fail bool
+}
+
+func (c *failingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.fail {
+		return errors.New("connection refused")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *failingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.fail {
+		return errors.New("connection refused")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func newMember(kube client.Client) (*Member, *failingClient) {
+	writer := &failingClient{Client: kube}
+	return &Member{
+		Client:        writer,
+		Reader:        kube,
+		Namespace:     "operator",
+		Group:         "test",
+		Identity:      "a",
+		LeaseDuration: time.Minute,
+	}, writer
+}
+
+func TestMemberDropsRangesWhenRenewFails(t *testing.T) {
+	ctx := context.Background()
+	m, writer := newMember(fake.NewClientBuilder().WithScheme(scheme.Scheme).Build())
+	var changes int
+	m.OnChange(func(context.Context) { changes++ })
+	key := types.NamespacedName{Namespace: "team", Name: "web"}
+
+	m.round(ctx)
+	if !m.Owns(key) {
+		t.Fatal("the only member does not own every key")
+	}
+
+	writer.fail = true
+	m.round(ctx)
+	if m.Owns(key) {
+		t.Error("member owns keys after a failed renewal")
+	}
+
+	writer.fail = false
+	m.round(ctx)
+	if !m.Owns(key) {
+		t.Error("member did not take its range back after renewing")
+	}
+	if changes != 2 {
+		t.Errorf("OnChange called %d times, want on joining and on rejoining", changes)
+	}
+}
+
+func TestMemberDropsRangesWhenLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newMember(fake.NewClientBuilder().WithScheme(scheme.Scheme).Build())
+	key := types.NamespacedName{Namespace: "team", Name: "web"}
+	m.round(ctx)
+
+	// The renewal loop is stuck, e.g. on a hanging request.
+	m.mu.Lock()
+	m.renewed = time.Now().Add(-m.LeaseDuration)
+	m.mu.Unlock()
+	if m.Owns(key) {
+		t.Error("member owns keys with an expired lease")
+	}
+}
@@ -0,0 +1,146 @@
+// Package signature verifies cosign-style image signatures. A signature of
+// the manifest with digest sha256:<hex> is stored in the repository of the
+// image under the tag sha256-<hex>.sig, as a manifest whose layers are
+// simple signing payloads annotated with their signature.
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+)
+
+const (
+	// PayloadType is the media type of the layers of signature manifests.
+	PayloadType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// Annotation holds the base64 signature of a layer.
+	Annotation = "dev.cosignproject.cosign/signature"
+	// payloadKind is the type of simple signing payloads of images.
+	payloadKind = "cosign container image signature"
+)
+
+// Key is a public key signatures are verified with.
+type Key struct {
+	Name      string
+	PublicKey crypto.PublicKey
+}
+
+// ParseKey reads a PEM encoded ECDSA or RSA public key.
+func ParseKey(name, data string) (Key, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s is not PEM encoded", name)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: %w", name, err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return Key{Name: name, PublicKey: pub}, nil
+	default:
+		return Key{}, fmt.Errorf("key %s: unsupported key type %T", name, pub)
+	}
+}
+
+// Payload is the simple signing payload a signature is made over.
+type Payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// NewPayload returns the payload that signs the manifest digest of the
+// repository of image.
+func NewPayload(image, digest string) ([]byte, error) {
+	var p Payload
+	p.Critical.Identity.DockerReference = registry.ParseReference(image).Name()
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = payloadKind
+	return json.Marshal(p)
+}
+
+// Tag returns the tag the signatures of the manifest digest are stored
+// under.
+func Tag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+type manifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// Verify checks that the manifest digest of the repository of image is
+// signed by one of keys, and returns the name of the first key that signed
+// it.
+func Verify(ctx context.Context, client registry.Client, image, digest string, creds registry.Credentials, keys []Key) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no keys to verify %s with", image)
+	}
+	name := registry.ParseReference(image).Name()
+	data, err := client.Manifest(ctx, name+":"+Tag(digest), creds)
+	if err != nil {
+		return "", fmt.Errorf("reading signatures of %s@%s: %w", name, digest, err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", fmt.Errorf("decoding signatures of %s@%s: %w", name, digest, err)
+	}
+	for _, layer := range m.Layers {
+		encoded, ok := layer.Annotations[Annotation]
+		if layer.MediaType != PayloadType || !ok {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		payload, err := client.Blob(ctx, name, layer.Digest, creds)
+		if err != nil {
+			return "", fmt.Errorf("reading signature payload of %s@%s: %w", name, digest, err)
+		}
+		var p Payload
+		if err := json.Unmarshal(payload, &p); err != nil || p.Critical.Type != payloadKind ||
+			p.Critical.Image.DockerManifestDigest != digest {
+			continue
+		}
+		for _, key := range keys {
+			if verify(key.PublicKey, payload, sig) {
+				return key.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s@%s is not signed by any trusted key", name, digest)
+}
+
+func verify(pub crypto.PublicKey, payload, sig []byte) bool {
+	sum := sha256.Sum256(payload)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, sum[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	}
+	return false
+}
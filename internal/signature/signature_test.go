@@ -0,0 +1,127 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloud-club/cloudclub-operator/internal/registry"
+)
+
+// localRegistry is an unauthenticated registry serving plain HTTP, like a
+// registry run next to an air-gapped cluster.
+type localRegistry struct {
+	*httptest.Server
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+func newLocalRegistry(t *testing.T) *localRegistry {
+	r := &localRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var content []byte
+		var ok bool
+		if i := strings.Index(req.URL.Path, "/manifests/"); i >= 0 {
+			content, ok = r.manifests[req.URL.Path[len("/v2/"):i]+":"+req.URL.Path[i+len("/manifests/"):]]
+		} else if i := strings.Index(req.URL.Path, "/blobs/"); i >= 0 {
+			content, ok = r.blobs[req.URL.Path[i+len("/blobs/"):]]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *localRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// sign pushes a signature of digest in repository made with key.
+func (r *localRegistry) sign(t *testing.T, repository, digest string, key *ecdsa.PrivateKey) {
+	payload, err := NewPayload(r.host()+"/"+repository, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadDigest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[payloadDigest] = payload
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"layers": []map[string]interface{}{{
+			"mediaType":   PayloadType,
+			"digest":      payloadDigest,
+			"size":        len(payload),
+			"annotations": map[string]string{Annotation: base64.StdEncoding.EncodeToString(sig)},
+		}},
+	}
+	r.manifests[repository+":"+Tag(digest)], _ = json.Marshal(m)
+}
+
+func newKey(t *testing.T, name string) (*ecdsa.PrivateKey, Key) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(name, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, key
+}
+
+func TestVerify(t *testing.T) {
+	reg := newLocalRegistry(t)
+	client := &registry.HTTPClient{Insecure: []string{reg.host()}}
+	release, releaseKey := newKey(t, "release")
+	_, otherKey := newKey(t, "other")
+	const signed, unsigned = "sha256:1111", "sha256:2222"
+	reg.sign(t, "team/app", signed, release)
+	image := reg.host() + "/team/app:1.0"
+	ctx := context.Background()
+
+	if name, err := Verify(ctx, client, image, signed, registry.Credentials{}, []Key{otherKey, releaseKey}); err != nil || name != "release" {
+		t.Errorf("Verify(signed) = %q, %v", name, err)
+	}
+	if _, err := Verify(ctx, client, image, signed, registry.Credentials{}, []Key{otherKey}); err == nil {
+		t.Error("Verify accepted a signature by an untrusted key")
+	}
+	if _, err := Verify(ctx, client, image, unsigned, registry.Credentials{}, []Key{releaseKey}); err == nil {
+		t.Error("Verify accepted an unsigned digest")
+	}
+
+	// A signature of another digest copied to the tag of this one does not
+	// count.
+	reg.manifests["team/app:"+Tag(unsigned)] = reg.manifests["team/app:"+Tag(signed)]
+	if _, err := Verify(ctx, client, image, unsigned, registry.Credentials{}, []Key{releaseKey}); err == nil {
+		t.Error("Verify accepted a signature of another digest")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("garbage", "not a key"); err == nil {
+		t.Error("ParseKey accepted garbage")
+	}
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Client wraps a client.Client so that every API request is recorded as a
+// span of the reconcile that issued it.
+type Client struct {
+	client.Client
+}
+
+func NewClient(c client.Client) client.Client {
+	return &Client{Client: c}
+}
+
+func (c *Client) start(ctx context.Context, verb string, obj runtime.Object, key client.ObjectKey) (context.Context, func(error)) {
+	kind := ""
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	ctx, span := Start(ctx, "k8s."+verb,
+		attribute.String("k8s.verb", verb),
+		attribute.String("k8s.kind", kind),
+		attribute.String("k8s.namespace", key.Namespace),
+		attribute.String("k8s.name", key.Name),
+	)
+	return ctx, func(err error) { End(span, err) }
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) (err error) {
+	ctx, end := c.start(ctx, "get", obj, key)
+	defer func() { end(err) }()
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	ctx, end := c.start(ctx, "list", list, client.ObjectKey{Namespace: listOpts.Namespace})
+	defer func() { end(err) }()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, end := c.start(ctx, "create", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, end := c.start(ctx, "update", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, end := c.start(ctx, "patch", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, end := c.start(ctx, "delete", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	client *Client
+}
+
+func (s *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, end := s.client.start(ctx, "update-status", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return s.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (s *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, end := s.client.start(ctx, "patch-status", obj, client.ObjectKeyFromObject(obj))
+	defer func() { end(err) }()
+	return s.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
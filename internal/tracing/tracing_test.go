@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// collector is an in-process OTLP/HTTP endpoint recording received span names
+// together with their parent span IDs.
+type collector struct {
+	mu      sync.Mutex
+	spans   map[string][]byte
+	parents map[string][]byte
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &collectortrace.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans[span.Name] = span.SpanId
+				c.parents[span.Name] = span.ParentSpanId
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(nil)
+}
+
+func TestSetupExportsNestedSpans(t *testing.T) {
+	c := &collector{spans: map[string][]byte{}, parents: map[string][]byte{}}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	ctx := context.Background()
+	shutdown, err := Setup(ctx, Options{
+		Endpoint:    strings.TrimPrefix(srv.URL, "http://"),
+		Insecure:    true,
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kube := NewClient(fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}).Build())
+
+	reconcileCtx, span := Start(ctx, "ApplicationClient.Reconcile")
+	if err := kube.Get(reconcileCtx, client.ObjectKey{Namespace: "default", Name: "web"}, &corev1.Service{}); err != nil {
+		t.Fatal(err)
+	}
+	End(span, nil)
+
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	root, ok := c.spans["ApplicationClient.Reconcile"]
+	if !ok {
+		t.Fatalf("reconcile span was not exported, got %v", c.spans)
+	}
+	if _, ok := c.spans["k8s.get"]; !ok {
+		t.Fatalf("api request span was not exported, got %v", c.spans)
+	}
+	if string(c.parents["k8s.get"]) != string(root) {
+		t.Errorf("api request span is not a child of the reconcile span")
+	}
+}
+
+func TestSetupWithoutEndpointIsNoop(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -17,10 +17,20 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	cloudclub "github.com/cloud-club/cloudclub-operator/internal"
+	"github.com/cloud-club/cloudclub-operator/internal/admission"
+	"github.com/cloud-club/cloudclub-operator/internal/config"
+	"github.com/cloud-club/cloudclub-operator/internal/log"
+	"github.com/cloud-club/cloudclub-operator/internal/project"
+	"github.com/cloud-club/cloudclub-operator/internal/scope"
+	"github.com/cloud-club/cloudclub-operator/internal/shard"
+	"github.com/cloud-club/cloudclub-operator/internal/tracing"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -51,24 +61,116 @@ func init() {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run sets up and runs the manager, returning the exit code. Errors return
+// rather than exit so that the deferred tracer shutdown flushes the spans
+// recorded until then.
+func run() int {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var tracingOpts tracing.Options
+	var drivers string
+	var configFile string
+	var watchNamespaces string
+	var watchSelector string
+	var sharding bool
+	var shardGroup string
+	var shardIdentity string
+	var shardLeaseDuration time.Duration
+	var reconcileOverrides reconcileFlags
+	var enableWebhooks bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&configFile, "config", "",
+		"The operator config file. It is reloaded when it changes. Built-in defaults are used when empty.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to watch. All namespaces are watched when neither this nor --watch-namespace-selector is set.")
+	flag.StringVar(&watchSelector, "watch-namespace-selector", "",
+		"Label selector of the namespaces to watch, e.g. cloudclub.com/tenant=team-a.")
+	flag.BoolVar(&sharding, "shard", false,
+		"Split Applications across all replicas by a hash of their namespace/name. A leader is still elected for Projects.")
+	flag.StringVar(&shardGroup, "shard-group", "cloud-club-operator", "The group of replicas sharing Applications.")
+	flag.StringVar(&shardIdentity, "shard-identity", os.Getenv("POD_NAME"), "The identity of this replica in its shard group.")
+	flag.DurationVar(&shardLeaseDuration, "shard-lease-duration", 15*time.Second,
+		"How long a replica keeps its shard after its last lease renewal.")
+	reconcileOverrides.bind(flag.CommandLine)
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", os.Getenv("ENABLE_WEBHOOKS") == "true",
+		"Serve the validating webhook for Applications. It needs a serving certificate, see config/certmanager.")
+	flag.StringVar(&drivers, "drivers", "",
+		"Comma separated list of resource drivers to enable, e.g. deployment,service,ingress,hpa,pdb. All are enabled when empty.")
+	flag.StringVar(&tracingOpts.Endpoint, "otlp-endpoint", "",
+		"The host:port of the OTLP/HTTP collector reconcile traces are exported to. Tracing is disabled when empty.")
+	flag.BoolVar(&tracingOpts.Insecure, "otlp-insecure", false, "Export traces over plain HTTP instead of HTTPS.")
+	flag.Float64Var(&tracingOpts.SampleRatio, "otlp-sample-ratio", 1, "The fraction of reconciles that are traced.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := log.New(&opts)
+	ctrl.SetLogger(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		return 1
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "problem shutting down tracing")
+		}
+	}()
+
+	cfg, err := config.NewStore(configFile, reconcileOverrides.apply)
+	if err != nil {
+		setupLog.Error(err, "unable to load operator config")
+		return 1
+	}
+
+	watchScope := scope.Scope{
+		Namespaces: cfg.Get().Watch.Namespaces,
+		Selector:   cfg.Get().Watch.NamespaceSelector,
+	}
+	if watchNamespaces != "" {
+		watchScope.Namespaces = strings.Split(watchNamespaces, ",")
+	}
+	if watchSelector != "" {
+		watchScope.Selector = watchSelector
+	}
+	restConfig := ctrl.GetConfigOrDie()
+	reader, err := scope.NewReader(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+	namespaces, err := watchScope.Resolve(context.Background(), reader)
+	if err != nil {
+		setupLog.Error(err, "unable to resolve watched namespaces")
+		return 1
+	}
+	if !watchScope.ClusterWide() && len(namespaces) == 0 {
+		setupLog.Error(nil, "no namespaces match the watch scope", "selector", watchScope.Selector)
+		return 1
+	}
+	if !watchScope.ClusterWide() {
+		setupLog.Info("restricting operator to namespaces", "namespaces", namespaces)
+	}
+
+	var operatorNamespace string
+	if !watchScope.ClusterWide() {
+		operatorNamespace = os.Getenv("POD_NAMESPACE")
+	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	mgrOpts := ctrl.Options{
 		Scheme:                 scheme,
+		Logger:                 logger,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
@@ -85,41 +187,185 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	scope.ApplyTo(&mgrOpts, namespaces)
+	if sharding {
+		if shardIdentity == "" || os.Getenv("POD_NAMESPACE") == "" {
+			setupLog.Error(nil, "--shard-identity (or POD_NAME) and POD_NAMESPACE are required with --shard")
+			return 1
+		}
+		// Applications are split across the replicas, while the Project
+		// controller keeps running on the leader only.
+		mgrOpts.LeaderElection = true
+	}
+	mgr, err := ctrl.NewManager(restConfig, mgrOpts)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
-		os.Exit(1)
+		return 1
+	}
+
+	if err := mgr.Add(cfg); err != nil {
+		setupLog.Error(err, "unable to watch operator config")
+		return 1
 	}
 
-	cloudMgr, err := cloudclub.NewManager(mgr.GetClient(), mgr.GetScheme())
+	if watchScope.Selector != "" {
+		if err := mgr.Add(&scope.SelectorWatcher{
+			Scope:    watchScope,
+			Reader:   reader,
+			Current:  namespaces,
+			Interval: time.Minute,
+		}); err != nil {
+			setupLog.Error(err, "unable to watch namespace selector")
+			return 1
+		}
+	}
+
+	var shardMember *shard.Member
+	if sharding {
+		shardMember = &shard.Member{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Namespace:     os.Getenv("POD_NAMESPACE"),
+			Group:         shardGroup,
+			Identity:      shardIdentity,
+			LeaseDuration: shardLeaseDuration,
+		}
+		if err := mgr.Add(shardMember); err != nil {
+			setupLog.Error(err, "unable to join shard group")
+			return 1
+		}
+	}
+
+	var enabledDrivers []string
+	if drivers != "" {
+		enabledDrivers = strings.Split(drivers, ",")
+	}
+	cloudMgr, err := cloudclub.NewManager(tracing.NewClient(mgr.GetClient()), mgr.GetAPIReader(), mgr.GetScheme(), cfg, enabledDrivers)
 
 	if err != nil {
 		setupLog.Error(err, "unable to create cloud-club manager")
-		os.Exit(1)
+		return 1
+	}
+	if sharding {
+		cloudMgr.ApplicationClient.Shard = shardIdentity
 	}
+	// Projects bind cluster-scoped Namespaces and are only managed by an
+	// operator watching the whole cluster.
+	cloudMgr.ApplicationClient.Projects = watchScope.ClusterWide()
+	cloudMgr.ApplicationClient.Events = mgr.GetEventRecorderFor("application-controller")
 
 	if err = (&controllers.ApplicationReconciler{
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
 		CloudClub: cloudMgr,
+		Config:    cfg,
+		Shard:     shardMember,
+		// The Secrets of the operator config live in its own namespace,
+		// which a namespace-scoped cache may leave out.
+		OperatorNamespace: operatorNamespace,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Application")
-		os.Exit(1)
+		return 1
+	}
+	if watchScope.ClusterWide() {
+		if err = (&controllers.ProjectReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Projects: &project.Client{
+				Kubernetes: tracing.NewClient(mgr.GetClient()),
+				Schema:     mgr.GetScheme(),
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Project")
+			return 1
+		}
+	}
+	if enableWebhooks {
+		if err = (&admission.ApplicationValidator{Applications: cloudMgr.ApplicationClient}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Application")
+			return 1
+		}
 	}
 	//+kubebuilder:scaffold:builder
 
+	if err := mgr.AddMetricsExtraHandler("/log-level", log.LevelHandler()); err != nil {
+		setupLog.Error(err, "unable to set up log level endpoint")
+		return 1
+	}
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		return 1
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		return 1
 	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+		return 1
+	}
+	return 0
+}
+
+// reconcileFlags override the reconcile section of the operator config when
+// they are set on the command line.
+type reconcileFlags struct {
+	fs                      *flag.FlagSet
+	set                     map[string]bool
+	maxConcurrentReconciles int
+	baseDelay               time.Duration
+	maxDelay                time.Duration
+	qps                     float64
+	burst                   int
+	resyncPeriod            time.Duration
+	conflictDelay           time.Duration
+	quotaExceededDelay      time.Duration
+}
+
+func (f *reconcileFlags) bind(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.IntVar(&f.maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of Applications reconciled in parallel.")
+	fs.DurationVar(&f.baseDelay, "rate-limiter-base-delay", 5*time.Millisecond, "The initial backoff after a failed reconcile.")
+	fs.DurationVar(&f.maxDelay, "rate-limiter-max-delay", 1000*time.Second, "The maximum backoff after repeated failed reconciles.")
+	fs.Float64Var(&f.qps, "rate-limiter-qps", 10, "The overall rate of reconciles retried after a failure.")
+	fs.IntVar(&f.burst, "rate-limiter-burst", 100, "The burst of reconciles retried after a failure.")
+	fs.DurationVar(&f.resyncPeriod, "resync-period", 10*time.Minute, "How often every Application is reconciled to correct drift. 0 disables it.")
+	fs.DurationVar(&f.conflictDelay, "conflict-delay", 100*time.Millisecond, "The retry delay after an update conflict.")
+	fs.DurationVar(&f.quotaExceededDelay, "quota-exceeded-delay", 5*time.Minute,
+		"The retry delay after a write was rejected for exceeding a ResourceQuota.")
+}
+
+func (f *reconcileFlags) apply(cfg *config.OperatorConfig) {
+	if f.set == nil {
+		f.set = map[string]bool{}
+		f.fs.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+	}
+	rc := &cfg.Reconcile
+	if f.set["max-concurrent-reconciles"] {
+		rc.MaxConcurrentReconciles = f.maxConcurrentReconciles
+	}
+	if f.set["rate-limiter-base-delay"] {
+		rc.RateLimiter.BaseDelay.Duration = f.baseDelay
+	}
+	if f.set["rate-limiter-max-delay"] {
+		rc.RateLimiter.MaxDelay.Duration = f.maxDelay
+	}
+	if f.set["rate-limiter-qps"] {
+		rc.RateLimiter.QPS = f.qps
+	}
+	if f.set["rate-limiter-burst"] {
+		rc.RateLimiter.Burst = f.burst
+	}
+	if f.set["resync-period"] {
+		rc.ResyncPeriod.Duration = f.resyncPeriod
+	}
+	if f.set["conflict-delay"] {
+		rc.ConflictDelay.Duration = f.conflictDelay
+	}
+	if f.set["quota-exceeded-delay"] {
+		rc.QuotaExceededDelay.Duration = f.quotaExceededDelay
 	}
 }